@@ -5,14 +5,22 @@ package main
 import (
 	"context"
 	"database/sql"
+	"log"
+	"net/http"
+	"time"
 
 	rice "github.com/GeertJohan/go.rice"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
 	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
-	"github.com/andrewstucki/web-app-tools/go/security"
 	"github.com/andrewstucki/web-app-tools/go/server"
+	sqlImpersonation "github.com/andrewstucki/web-app-tools/go/sql/impersonation"
+	sqlSecurity "github.com/andrewstucki/web-app-tools/go/sql/security"
+	sqlTokens "github.com/andrewstucki/web-app-tools/go/sql/tokens"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
 	"github.com/volatiletech/sqlboiler/boil"
-	"github.com/volatiletech/sqlboiler/queries/qm"
 
 	"example/models"
 	"example/roles"
@@ -28,17 +36,41 @@ func userFromClaims(ctx context.Context, claims *verifier.StandardClaims) (*mode
 	return user, err
 }
 
-// This gets a user based off of an API token
-func userFromToken(ctx context.Context, token string) (*models.User, error) {
-	if apiToken, err := uuid.FromString(token); err == nil {
-		user, err := models.Users(qm.InnerJoin("user_tokens on user_tokens.user_id = users.id"), models.UserTokenWhere.ID.EQ(apiToken)).One(ctx, server.Tx(ctx))
-		if err != nil && err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return user, err
+// This gets the owner of an API token
+func userFromToken(ctx context.Context, token *tokens.Token) (*models.User, error) {
+	user, err := models.FindUser(ctx, server.Tx(ctx), token.User)
+	if err != nil && err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// This gets the owner of an impersonation session
+func userFromSession(ctx context.Context, session *impersonation.Session) (*models.User, error) {
+	user, err := models.FindUser(ctx, server.Tx(ctx), session.User)
+	if err != nil && err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+// This gets the id of the current user, it's the impersonated user
+// during an impersonation session
+func currentUserID(r *http.Request) (uuid.UUID, error) {
+	user, err := routes.CurrentUser(r.Context())
+	if err != nil || user == nil {
+		return uuid.UUID{}, err
 	}
-	// we don't have a valid uuid
-	return nil, nil
+	return user.ID, nil
+}
+
+// This gets the id of the real user for the admin routes so that support
+// staff can't change roles as the users they're impersonating
+func actorID(r *http.Request) (uuid.UUID, error) {
+	if session := impersonation.FromContext(r.Context()); session != nil {
+		return session.Actor, nil
+	}
+	return currentUserID(r)
 }
 
 var config = server.Config{
@@ -50,34 +82,97 @@ var config = server.Config{
 	// internal auth handlers are set up, it's where the main route initialization code
 	// should be
 	Setup: func(config *server.SetupConfig) {
-		roles.Register()
-		config.Router.Route("/v1", routes.NewV1Handler(config.Logger, config.Render).Register)
+		// Roles are registered on the server's own authorizer
+		roles.Register(config.Authorizer)
+		// Engineers can request temporary elevations that expire on their own,
+		// expired grants are swept from the database every few minutes until
+		// the server shuts down
+		config.Authorizer.WithElevations(sqlSecurity.NewElevationStore(config.DB), 4*time.Hour)
+		config.Server.OnStart(func(ctx context.Context) error {
+			go config.Authorizer.SweepExpiredGrants(ctx, 5*time.Minute, func(err error) {
+				config.Logger.Error().Err(err).Msg("error sweeping expired grants")
+			})
+			return nil
+		})
+		config.Router.Route("/v1", routes.NewV1Handler(config.Logger, config.Render, config.Authorizer).Register)
+		config.Router.Mount("/admin", server.NewAdminRouter(server.AdminConfig{
+			Authorizer: config.Authorizer,
+			Render:     config.Render,
+			Logger:     config.Logger,
+			Actor:      actorID,
+		}))
+		// The frontend checks permissions against this manifest rather than the raw
+		// policies, it revalidates with the ETag so unchanged manifests aren't resent
+		config.Router.Get("/permissions", server.ManifestHandler(config.Authorizer, config.Render, config.Logger, currentUserID))
+		// Users can create API tokens limited to a subset of their own policies,
+		// only a hash of each token is stored, tokens can't be managed while
+		// impersonating someone
+		config.Router.With(impersonation.RequireRealUser(config.Render)).Mount("/tokens", tokens.NewRouter(tokens.RouterConfig{
+			Manager: config.Tokens,
+			Render:  config.Render,
+			Logger:  config.Logger,
+			User:    currentUserID,
+		}))
+		// Support staff can start sessions to see the app as another user, sessions
+		// last at most an hour and are sent in the X-Impersonation-Session header
+		config.Router.Mount("/impersonation", impersonation.NewRouter(impersonation.RouterConfig{
+			Manager: config.Impersonation,
+			Render:  config.Render,
+			Logger:  config.Logger,
+			Actor:   actorID,
+		}))
+	},
+	// This stores hashed API tokens, requests with an X-Api-Token header are
+	// authenticated against it before GetCurrentUser is called
+	Tokens: func(db *sqlx.DB) tokens.Store {
+		return sqlTokens.NewStore(db)
+	},
+	// This stores impersonation sessions, UserID lets the server check that
+	// a session belongs to the real user making the request
+	Impersonation: func(db *sqlx.DB) impersonation.Store {
+		return sqlImpersonation.NewStore(db)
+	},
+	UserID: func(user interface{}) uuid.UUID {
+		return user.(*models.User).ID
 	},
-	// This callback is used to actually return the currently logged in user and allows
-	// us to invoked the server.CurrentUser(ctx) method to get the user
-	GetCurrentUser: func(ctx context.Context, claimsOrToken *server.ClaimsOrToken) (interface{}, error) {
+	// This callback is used to actually return the currently logged in user, it's
+	// called at most once per request and its result is returned by the generated
+	// routes.CurrentUser(ctx) accessor
+	GetCurrentUser: routes.ResolveUser(func(ctx context.Context, claimsOrToken *server.ClaimsOrToken) (*models.User, error) {
+		if claimsOrToken.Impersonation != nil {
+			return userFromSession(ctx, claimsOrToken.Impersonation)
+		}
 		if claimsOrToken.Claims != nil {
 			return userFromClaims(ctx, claimsOrToken.Claims)
 		}
-		return userFromToken(ctx, claimsOrToken.Token)
-	},
+		if claimsOrToken.APIToken != nil {
+			return userFromToken(ctx, claimsOrToken.APIToken)
+		}
+		return nil, nil
+	}),
 	// This gets invoked the first time anyone ever logs into the system
 	// it's useful for setting up an admin user
-	OnFirstUser: func(ctx context.Context, claims *verifier.StandardClaims) error {
-		user := models.User{Email: claims.Email, GoogleID: claims.Subject}
+	OnFirstUser: func(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, error) {
+		user := models.User{Email: event.Claims.Email, GoogleID: event.Claims.Subject}
 		if err := user.Upsert(ctx, server.Tx(ctx), false, nil, boil.Infer(), boil.Infer()); err != nil {
-			return err
+			return oauth.LoginDecision{}, err
 		}
-		return security.SetRole(ctx, roles.SuperAdminRole, user.ID)
+		return oauth.Allow(), server.Authorizer(ctx).SetRole(ctx, roles.SuperAdminRole, user.ID)
 	},
 	// This gets called every subsequent log in, it's useful for inserting
-	// a user if they don't already exist
-	OnLogin: func(ctx context.Context, claims *verifier.StandardClaims) error {
-		user := models.User{Email: claims.Email, GoogleID: claims.Subject}
-		return user.Upsert(ctx, server.Tx(ctx), false, nil, boil.Infer(), boil.Infer())
+	// a user if they don't already exist, its decision can also turn the
+	// user away with a message
+	OnLogin: func(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, error) {
+		if !event.Claims.EmailVerified {
+			return oauth.Deny("Your email address needs to be verified before you can sign in."), nil
+		}
+		user := models.User{Email: event.Claims.Email, GoogleID: event.Claims.Subject}
+		return oauth.Allow(), user.Upsert(ctx, server.Tx(ctx), false, nil, boil.Infer(), boil.Infer())
 	},
 }
 
 func main() {
-	server.RunServer(config)
+	if err := server.RunServer(config); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
 	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
 	"github.com/andrewstucki/web-app-tools/go/security"
 	"github.com/andrewstucki/web-app-tools/go/security/memory"
 	"github.com/andrewstucki/web-app-tools/go/server"
 	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
 	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
 	uuid "github.com/satori/go.uuid"
 	"github.com/stretchr/testify/require"
 	"github.com/volatiletech/sqlboiler/boil"
@@ -42,24 +48,22 @@ func testInTransaction(t *testing.T, inner func(ctx context.Context)) {
 	require.NoError(t, tx.Rollback())
 }
 
-func TestGetCurrentUser_InvalidToken(t *testing.T) {
+func TestGetCurrentUser_TokenUserNotFound(t *testing.T) {
 	testInTransaction(t, func(ctx context.Context) {
 		returned, err := config.GetCurrentUser(ctx, &server.ClaimsOrToken{
-			Token: "1",
+			APIToken: &tokens.Token{User: uuid.NewV4()},
 		})
 		require.NoError(t, err)
 		require.Nil(t, returned)
 	})
 }
 
-func TestGetCurrentUser_ValidTokenFound(t *testing.T) {
+func TestGetCurrentUser_TokenUserFound(t *testing.T) {
 	testInTransaction(t, func(ctx context.Context) {
 		user := randomUser()
-		token := &models.UserToken{}
 		require.NoError(t, user.Insert(ctx, sqlContext.FromContext(ctx), boil.Infer()))
-		require.NoError(t, user.AddUserTokens(ctx, sqlContext.FromContext(ctx), true, token))
 		returned, err := config.GetCurrentUser(ctx, &server.ClaimsOrToken{
-			Token: token.ID.String(),
+			APIToken: &tokens.Token{User: user.ID},
 		})
 		require.NoError(t, err)
 		require.NotNil(t, returned)
@@ -67,12 +71,23 @@ func TestGetCurrentUser_ValidTokenFound(t *testing.T) {
 	})
 }
 
-func TestGetCurrentUser_ValidTokenNotFound(t *testing.T) {
+func TestGetCurrentUser_Impersonation(t *testing.T) {
 	testInTransaction(t, func(ctx context.Context) {
 		user := randomUser()
-		token := &models.UserToken{}
 		require.NoError(t, user.Insert(ctx, sqlContext.FromContext(ctx), boil.Infer()))
-		require.NoError(t, user.AddUserTokens(ctx, sqlContext.FromContext(ctx), true, token))
+		returned, err := config.GetCurrentUser(ctx, &server.ClaimsOrToken{
+			// the impersonated user is returned rather than the one in the claims
+			Claims:        &verifier.StandardClaims{Subject: randomdata.StringNumber(16, "")},
+			Impersonation: &impersonation.Session{Actor: uuid.NewV4(), User: user.ID},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, returned)
+		require.Equal(t, user.ID, returned.(*models.User).ID)
+	})
+}
+
+func TestGetCurrentUser_RawToken(t *testing.T) {
+	testInTransaction(t, func(ctx context.Context) {
 		returned, err := config.GetCurrentUser(ctx, &server.ClaimsOrToken{
 			Token: uuid.NewV4().String(),
 		})
@@ -112,22 +127,29 @@ func TestGetCurrentUser_ClaimsFound(t *testing.T) {
 
 func TestOnLogin(t *testing.T) {
 	testInTransaction(t, func(ctx context.Context) {
-		security.RegisterManager(memory.NewNamespaceManager())
-		security.Register(roles.SuperAdminRole)
+		authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+		roles.Register(authorizer)
+		ctx = server.WithAuthorizer(ctx, authorizer)
 
 		claims := &verifier.StandardClaims{
 			Email:   randomdata.Email(),
 			Subject: randomdata.StringNumber(16, ""),
 		}
-		err := config.OnLogin(ctx, claims)
+		decision, err := config.OnLogin(ctx, &oauth.LoginEvent{Claims: claims})
 		require.NoError(t, err)
+		require.True(t, decision.Denied)
+
+		claims.EmailVerified = true
+		decision, err = config.OnLogin(ctx, &oauth.LoginEvent{Claims: claims})
+		require.NoError(t, err)
+		require.Equal(t, oauth.Allow(), decision)
 
 		user, err := models.Users(models.UserWhere.GoogleID.EQ(claims.Subject)).One(ctx, sqlContext.FromContext(ctx))
 		require.NoError(t, err)
 		require.NotNil(t, user)
 		require.Equal(t, claims.Email, user.Email)
 
-		policies, err := security.WithUser(user.ID).Policies(ctx)
+		policies, err := authorizer.WithUser(user.ID).Policies(ctx)
 		require.NoError(t, err)
 		require.Empty(t, policies)
 	})
@@ -135,23 +157,72 @@ func TestOnLogin(t *testing.T) {
 
 func TestOnFirstUser(t *testing.T) {
 	testInTransaction(t, func(ctx context.Context) {
-		security.RegisterManager(memory.NewNamespaceManager())
-		security.Register(roles.SuperAdminRole)
+		authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+		roles.Register(authorizer)
+		ctx = server.WithAuthorizer(ctx, authorizer)
 
 		claims := &verifier.StandardClaims{
 			Email:   randomdata.Email(),
 			Subject: randomdata.StringNumber(16, ""),
 		}
-		err := config.OnFirstUser(ctx, claims)
+		decision, err := config.OnFirstUser(ctx, &oauth.LoginEvent{Claims: claims})
 		require.NoError(t, err)
+		require.Equal(t, oauth.Allow(), decision)
 
 		user, err := models.Users(models.UserWhere.GoogleID.EQ(claims.Subject)).One(ctx, sqlContext.FromContext(ctx))
 		require.NoError(t, err)
 		require.NotNil(t, user)
 		require.Equal(t, claims.Email, user.Email)
 
-		policies, err := security.WithUser(user.ID).Policies(ctx)
+		policies, err := authorizer.WithUser(user.ID).Policies(ctx)
 		require.NoError(t, err)
 		require.ElementsMatch(t, roles.SuperAdminRole.Policies, policies)
 	})
 }
+
+type fakeVerifier struct {
+	users map[string]*models.User
+}
+
+func (v *fakeVerifier) VerifyIDToken(token string, claims verifier.GoogleClaims) error {
+	user, ok := v.users[token]
+	if !ok {
+		return verifier.ErrInvalidToken
+	}
+	standard := claims.(*verifier.StandardClaims)
+	standard.Subject = user.GoogleID
+	standard.Email = user.Email
+	standard.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	return nil
+}
+
+func TestServer_Me(t *testing.T) {
+	db := sqlx.MustConnect("postgres", postgresURL)
+	defer db.Close()
+
+	user := randomUser()
+	require.NoError(t, user.Insert(context.Background(), db, boil.Infer()))
+	defer user.Delete(context.Background(), db)
+
+	// the oauth settings are only checked for presence since the verifier is faked
+	testConfig := config
+	testConfig.ClientID, testConfig.ClientSecret, testConfig.SecretKey = "client", "secret", "key"
+	handler, err := server.New(testConfig,
+		server.WithDB(db),
+		server.WithVerifier(&fakeVerifier{users: map[string]*models.User{"token": user}}),
+		server.WithLogger(zerolog.Nop()),
+	)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/api/v1/me", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), user.ID.String())
+
+	request = httptest.NewRequest("GET", "/api/v1/me", nil)
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	require.Equal(t, http.StatusUnauthorized, response.Code)
+}
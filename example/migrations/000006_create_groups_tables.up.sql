@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS user_groups (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name varchar(50) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+  group_id uuid NOT NULL,
+  user_id uuid NOT NULL,
+  PRIMARY KEY (group_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_memberships (
+  namespace_id uuid NOT NULL,
+  group_id uuid NOT NULL,
+  role varchar(50) NOT NULL,
+  PRIMARY KEY (namespace_id, group_id)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+  id bigserial PRIMARY KEY,
+  kind varchar(20) NOT NULL,
+  actor_id uuid NOT NULL,
+  subject_id uuid NOT NULL,
+  subject_kind varchar(20) NOT NULL,
+  namespace_id uuid NOT NULL,
+  role varchar(50) NOT NULL,
+  action varchar(255) NOT NULL,
+  resource text NOT NULL,
+  allowed boolean NOT NULL,
+  policy text NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_subject_id_idx ON audit_events (subject_id, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS elevations;
+DROP TABLE IF EXISTS membership_grants;
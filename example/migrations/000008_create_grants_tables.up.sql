@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS membership_grants (
+  namespace_id uuid NOT NULL,
+  user_id uuid NOT NULL,
+  role varchar(50) NOT NULL,
+  reason text NOT NULL DEFAULT '',
+  expires_at timestamp with time zone,
+  PRIMARY KEY (namespace_id, user_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS membership_grants_expires_at_idx ON membership_grants (expires_at);
+
+CREATE TABLE IF NOT EXISTS elevations (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id uuid NOT NULL,
+  namespace_id uuid NOT NULL,
+  role varchar(50) NOT NULL,
+  reason text NOT NULL,
+  duration bigint NOT NULL,
+  status varchar(20) NOT NULL,
+  requested_at timestamp with time zone NOT NULL DEFAULT NOW(),
+  decided_by uuid,
+  decided_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS elevations_status_idx ON elevations (status, requested_at);
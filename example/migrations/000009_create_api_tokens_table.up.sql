@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name varchar(255) NOT NULL,
+  hint varchar(50) NOT NULL,
+  hash varchar(64) NOT NULL UNIQUE,
+  scopes jsonb NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+  expires_at timestamp with time zone,
+  last_used_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens (user_id, created_at);
@@ -0,0 +1 @@
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS kind varchar(20) NOT NULL DEFAULT 'user';
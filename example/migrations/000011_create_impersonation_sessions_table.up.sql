@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  actor_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  reason text NOT NULL DEFAULT '',
+  created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+  expires_at timestamp with time zone NOT NULL,
+  ended_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS impersonation_sessions_actor_id_idx ON impersonation_sessions (actor_id, created_at);
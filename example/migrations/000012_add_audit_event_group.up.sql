@@ -0,0 +1 @@
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS group_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+  id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  namespace_id uuid NOT NULL,
+  name varchar(255) NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS service_accounts_namespace_id_idx ON service_accounts (namespace_id, name);
+
+CREATE TABLE IF NOT EXISTS service_account_keys (
+  id varchar(255) PRIMARY KEY,
+  account_id uuid NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+  public_key text NOT NULL,
+  created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS service_account_keys_account_id_idx ON service_account_keys (account_id);
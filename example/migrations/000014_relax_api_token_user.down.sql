@@ -0,0 +1,2 @@
+DELETE FROM api_tokens WHERE kind <> 'user';
+ALTER TABLE api_tokens ADD CONSTRAINT api_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+-- service account tokens are owned by a service account rather than a user
+ALTER TABLE api_tokens DROP CONSTRAINT IF EXISTS api_tokens_user_id_fkey;
@@ -1,6 +1,9 @@
 package roles
 
-import "github.com/andrewstucki/web-app-tools/go/security"
+import (
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
 
 var (
 	// SuperAdminRole users can do anything
@@ -10,8 +13,16 @@ var (
 			security.Policy{Resource: security.ResourceAll, Action: security.ActionAll},
 		},
 	}
+	// SupportRole users can see the app as any other user
+	SupportRole = security.Role{
+		Name: "support",
+		Policies: []security.Policy{
+			security.Policy{Resource: impersonation.ResourceImpersonation + "/*", Action: security.ActionCreate},
+		},
+	}
 )
 
-func Register() {
-	security.Register(SuperAdminRole)
+// Register adds the application roles to the authorizer
+func Register(authorizer *security.Authorizer) {
+	authorizer.MustRegister(SuperAdminRole, SupportRole)
 }
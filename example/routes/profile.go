@@ -3,16 +3,19 @@ package routes
 import (
 	"net/http"
 
-	"github.com/andrewstucki/web-app-tools/go/security"
-
 	"example/payload"
 	"example/roles"
 )
 
 // Me returns the current user and their permission policies
 func (h *V1Handler) Me(w http.ResponseWriter, r *http.Request) {
-	user := CurrentUser(r.Context())
-	policies, err := security.WithUser(user.ID).Policies(r.Context())
+	user, err := CurrentUser(r.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("error retrieving current user")
+		h.InternalError(w)
+		return
+	}
+	policies, err := h.authorizer.WithUser(user.ID).Policies(r.Context())
 	if err != nil {
 		h.logger.Error().Err(err).Msg("error retrieving policies")
 		h.InternalError(w)
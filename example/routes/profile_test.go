@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/andrewstucki/web-app-tools/go/security"
 	"github.com/stretchr/testify/require"
 
 	"example/roles"
@@ -14,10 +13,10 @@ import (
 
 func TestMe_Admin(t *testing.T) {
 	user := randomUser()
-	server, cleanup := setupTest(t, user)
+	server, authorizer, cleanup := setupTest(t, user)
 	defer cleanup()
 
-	require.NoError(t, security.SetRole(context.Background(), roles.SuperAdminRole, user.ID))
+	require.NoError(t, authorizer.SetRole(context.Background(), roles.SuperAdminRole, user.ID))
 	expected := fmt.Sprintf(`{
 		"user": %s,
 		"policies": [{"action":"*","resource":"*"}],
@@ -32,7 +31,7 @@ func TestMe_Admin(t *testing.T) {
 
 func TestMe_NoAdmin(t *testing.T) {
 	user := randomUser()
-	server, cleanup := setupTest(t, user)
+	server, _, cleanup := setupTest(t, user)
 	defer cleanup()
 
 	expected := fmt.Sprintf(`{
@@ -48,7 +47,7 @@ func TestMe_NoAdmin(t *testing.T) {
 }
 
 func TestMe_NoUser(t *testing.T) {
-	server, cleanup := setupTest(t, nil)
+	server, _, cleanup := setupTest(t, nil)
 	defer cleanup()
 
 	response, body := testRequest(t, server, "GET", "/api/v1/me", nil)
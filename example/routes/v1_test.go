@@ -46,11 +46,11 @@ func jsonifyUser(user *models.User) string {
 	}`, user.ID.String(), user.Email, user.CreatedAt.Format(time.RFC3339Nano), user.UpdatedAt.Format(time.RFC3339Nano))
 }
 
-func setupTest(t *testing.T, user *models.User) (*httptest.Server, func()) {
+func setupTest(t *testing.T, user *models.User) (*httptest.Server, *security.Authorizer, func()) {
 	router := chi.NewRouter()
 	db := sqlx.MustConnect("postgres", postgresURL)
-	security.RegisterManager(memory.NewNamespaceManager())
-	security.Register(roles.SuperAdminRole)
+	authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+	roles.Register(authorizer)
 	router.Route("/api/v1", func(router chi.Router) {
 		router.Use(func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -66,10 +66,10 @@ func setupTest(t *testing.T, user *models.User) (*httptest.Server, func()) {
 				require.NoError(t, tx.Rollback())
 			})
 		})
-		NewV1Handler(zerolog.Nop(), common.NewJSONRenderer()).Register(router)
+		NewV1Handler(zerolog.Nop(), common.NewJSONRenderer(), authorizer).Register(router)
 	})
 	server := httptest.NewServer(router)
-	return server, func() {
+	return server, authorizer, func() {
 		server.Close()
 		db.Close()
 	}
@@ -0,0 +1,295 @@
+package accounts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+const (
+	// MaxAssertionLifetime is the longest an assertion can be valid for
+	MaxAssertionLifetime = time.Hour
+
+	// clockSkew is how far in the future an assertion can be issued to
+	// allow for clients with clocks that are slightly ahead
+	clockSkew = time.Minute
+)
+
+var (
+	// ErrServiceAccountNotFound is returned when a service account doesn't exist
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	// ErrKeyNotFound is returned when a key doesn't exist
+	ErrKeyNotFound = errors.New("service account key not found")
+	// ErrInvalidName is returned when creating a service account without a name
+	ErrInvalidName = errors.New("service accounts must have a name")
+	// ErrInvalidKey is returned when registering a key that isn't a pem encoded
+	// rsa or ecdsa public key
+	ErrInvalidKey = errors.New("service account keys must be pem encoded rsa or ecdsa public keys")
+	// ErrInvalidAssertion is returned when authenticating with an assertion that is
+	// malformed, signed by an unknown key, expired or meant for another audience
+	ErrInvalidAssertion = errors.New("invalid service account assertion")
+)
+
+// ServiceAccount is a named non-human principal owned by a namespace, it holds
+// roles through the namespace manager just like a user does with its id
+type ServiceAccount struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	Namespace uuid.UUID `json:"namespace" db:"namespace_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// Key is the public half of a key that a service account signs its assertions
+// with, its id is the thumbprint of the key and is given as the "kid" header
+type Key struct {
+	ID        string    `json:"id" db:"id"`
+	Account   uuid.UUID `json:"account" db:"account_id"`
+	PublicKey string    `json:"publicKey" db:"public_key"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// Store is the storage interface for service accounts and their keys
+type Store interface {
+	// CreateServiceAccount stores a new service account
+	CreateServiceAccount(ctx context.Context, account *ServiceAccount) error
+	// GetServiceAccount returns the service account with the given id or ErrServiceAccountNotFound
+	GetServiceAccount(ctx context.Context, id uuid.UUID) (*ServiceAccount, error)
+	// ListServiceAccounts returns the service accounts owned by the namespace ordered by name
+	ListServiceAccounts(ctx context.Context, namespace uuid.UUID) ([]ServiceAccount, error)
+	// DeleteServiceAccount deletes the service account and its keys, it returns
+	// ErrServiceAccountNotFound if there's no such service account
+	DeleteServiceAccount(ctx context.Context, id uuid.UUID) error
+	// AddKey stores a new key
+	AddKey(ctx context.Context, key *Key) error
+	// GetKey returns the key with the given id or ErrKeyNotFound
+	GetKey(ctx context.Context, id string) (*Key, error)
+	// RemoveKey deletes the service account's key with the given id, it returns
+	// ErrKeyNotFound if the service account has no such key
+	RemoveKey(ctx context.Context, account uuid.UUID, id string) error
+}
+
+// Manager creates service accounts, registers their keys and authenticates
+// the assertions signed with them
+type Manager struct {
+	store    Store
+	audience string
+	now      func() time.Time
+}
+
+// NewManager creates a new service account manager backed by the store, assertions
+// must be addressed to the audience, e.g. the server's base url
+func NewManager(store Store, audience string) *Manager {
+	return &Manager{
+		store:    store,
+		audience: audience,
+		now:      time.Now,
+	}
+}
+
+// WithClock sets the function used to get the current time
+func (m *Manager) WithClock(now func() time.Time) *Manager {
+	m.now = now
+	return m
+}
+
+// Create creates a service account owned by the namespace
+func (m *Manager) Create(ctx context.Context, namespace uuid.UUID, name string) (*ServiceAccount, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrInvalidName
+	}
+	account := &ServiceAccount{
+		ID:        uuid.NewV4(),
+		Namespace: namespace,
+		Name:      name,
+		CreatedAt: m.now(),
+	}
+	if err := m.store.CreateServiceAccount(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Get returns the service account with the given id
+func (m *Manager) Get(ctx context.Context, id uuid.UUID) (*ServiceAccount, error) {
+	return m.store.GetServiceAccount(ctx, id)
+}
+
+// List returns the service accounts owned by the namespace
+func (m *Manager) List(ctx context.Context, namespace uuid.UUID) ([]ServiceAccount, error) {
+	return m.store.ListServiceAccounts(ctx, namespace)
+}
+
+// Delete deletes the service account and its keys, any roles it holds
+// should be removed separately
+func (m *Manager) Delete(ctx context.Context, id uuid.UUID) error {
+	return m.store.DeleteServiceAccount(ctx, id)
+}
+
+// RegisterKey registers the pem encoded public key of a key pair that the service
+// account signs its assertions with, the private key never leaves the service account
+func (m *Manager) RegisterKey(ctx context.Context, account uuid.UUID, publicKey []byte) (*Key, error) {
+	if _, err := m.store.GetServiceAccount(ctx, account); err != nil {
+		return nil, err
+	}
+	parsed, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	id, err := KeyID(parsed)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{
+		ID:        id,
+		Account:   account,
+		PublicKey: string(publicKey),
+		CreatedAt: m.now(),
+	}
+	if err := m.store.AddKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RemoveKey removes the service account's key with the given id
+func (m *Manager) RemoveKey(ctx context.Context, account uuid.UUID, id string) error {
+	return m.store.RemoveKey(ctx, account, id)
+}
+
+// Authenticate returns the service account that signed the assertion, the assertion
+// must name the service account as both its issuer and subject, be addressed to the
+// manager's audience and expire no more than MaxAssertionLifetime after it was issued,
+// ErrKeyNotFound is returned when the assertion isn't signed by a registered key
+func (m *Manager) Authenticate(ctx context.Context, assertion string) (*ServiceAccount, error) {
+	claims := &jwt.StandardClaims{}
+	var key *Key
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		if id == "" {
+			return nil, ErrKeyNotFound
+		}
+		found, err := m.store.GetKey(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := parsePublicKey([]byte(found.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		switch publicKey.(type) {
+		case *rsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, ErrInvalidAssertion
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+				return nil, ErrInvalidAssertion
+			}
+		}
+		key = found
+		return publicKey, nil
+	})
+	if err != nil {
+		// anything other than a bad assertion, e.g. the store failing, is passed along
+		if validation, ok := err.(*jwt.ValidationError); ok && validation.Errors&jwt.ValidationErrorUnverifiable != 0 {
+			switch validation.Inner {
+			case nil, ErrInvalidAssertion, ErrInvalidKey:
+			default:
+				return nil, validation.Inner
+			}
+		}
+		return nil, ErrInvalidAssertion
+	}
+
+	now := m.now()
+	subject := key.Account.String()
+	switch {
+	case claims.Issuer != subject || claims.Subject != subject:
+		return nil, ErrInvalidAssertion
+	case !claims.VerifyAudience(m.audience, true):
+		return nil, ErrInvalidAssertion
+	case claims.IssuedAt == 0 || claims.ExpiresAt == 0:
+		return nil, ErrInvalidAssertion
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)) || !now.Before(time.Unix(claims.ExpiresAt, 0)):
+		return nil, ErrInvalidAssertion
+	case time.Duration(claims.ExpiresAt-claims.IssuedAt)*time.Second > MaxAssertionLifetime:
+		return nil, ErrInvalidAssertion
+	}
+
+	account, err := m.store.GetServiceAccount(ctx, key.Account)
+	if err != nil {
+		if err == ErrServiceAccountNotFound {
+			return nil, ErrInvalidAssertion
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// SignAssertion creates an assertion for the service account signed with the private
+// half of the registered key with the given id, it's valid for the given lifetime
+func SignAssertion(privateKey interface{}, keyID string, account uuid.UUID, audience string, issuedAt time.Time, lifetime time.Duration) (string, error) {
+	var method jwt.SigningMethod
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 384:
+			method = jwt.SigningMethodES384
+		case 521:
+			method = jwt.SigningMethodES512
+		default:
+			method = jwt.SigningMethodES256
+		}
+	default:
+		return "", ErrInvalidKey
+	}
+	token := jwt.NewWithClaims(method, jwt.StandardClaims{
+		Issuer:    account.String(),
+		Subject:   account.String(),
+		Audience:  audience,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(lifetime).Unix(),
+	})
+	token.Header["kid"] = keyID
+	return token.SignedString(privateKey)
+}
+
+// KeyID returns the id of a public key, it's the unpadded url safe base64
+// encoding of the sha256 of the key's der encoding
+func KeyID(publicKey interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", ErrInvalidKey
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func parsePublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, ErrInvalidKey
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return publicKey, nil
+	}
+	return nil, ErrInvalidKey
+}
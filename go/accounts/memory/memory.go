@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Store is a service account store that
+// keeps every account and key in memory
+type Store struct {
+	mutex    sync.RWMutex
+	accounts map[uuid.UUID]accounts.ServiceAccount
+	keys     map[string]accounts.Key
+}
+
+// NewStore creates a new service account store that
+// stores everything in memory
+func NewStore() *Store {
+	return &Store{
+		accounts: make(map[uuid.UUID]accounts.ServiceAccount),
+		keys:     make(map[string]accounts.Key),
+	}
+}
+
+// CreateServiceAccount stores a new service account
+func (s *Store) CreateServiceAccount(ctx context.Context, account *accounts.ServiceAccount) error {
+	s.mutex.Lock()
+	s.accounts[account.ID] = *account
+	s.mutex.Unlock()
+	return nil
+}
+
+// GetServiceAccount returns the service account with the given id or ErrServiceAccountNotFound
+func (s *Store) GetServiceAccount(ctx context.Context, id uuid.UUID) (*accounts.ServiceAccount, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, accounts.ErrServiceAccountNotFound
+	}
+	return &account, nil
+}
+
+// ListServiceAccounts returns the service accounts owned by the namespace ordered by name
+func (s *Store) ListServiceAccounts(ctx context.Context, namespace uuid.UUID) ([]accounts.ServiceAccount, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	found := []accounts.ServiceAccount{}
+	for _, account := range s.accounts {
+		if uuid.Equal(account.Namespace, namespace) {
+			found = append(found, account)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Name < found[j].Name
+	})
+	return found, nil
+}
+
+// DeleteServiceAccount deletes the service account and its keys, it returns
+// ErrServiceAccountNotFound if there's no such service account
+func (s *Store) DeleteServiceAccount(ctx context.Context, id uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.accounts[id]; !ok {
+		return accounts.ErrServiceAccountNotFound
+	}
+	delete(s.accounts, id)
+	for keyID, key := range s.keys {
+		if uuid.Equal(key.Account, id) {
+			delete(s.keys, keyID)
+		}
+	}
+	return nil
+}
+
+// AddKey stores a new key
+func (s *Store) AddKey(ctx context.Context, key *accounts.Key) error {
+	s.mutex.Lock()
+	s.keys[key.ID] = *key
+	s.mutex.Unlock()
+	return nil
+}
+
+// GetKey returns the key with the given id or ErrKeyNotFound
+func (s *Store) GetKey(ctx context.Context, id string) (*accounts.Key, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, accounts.ErrKeyNotFound
+	}
+	return &key, nil
+}
+
+// RemoveKey deletes the service account's key with the given id, it returns
+// ErrKeyNotFound if the service account has no such key
+func (s *Store) RemoveKey(ctx context.Context, account uuid.UUID, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || !uuid.Equal(key.Account, account) {
+		return accounts.ErrKeyNotFound
+	}
+	delete(s.keys, id)
+	return nil
+}
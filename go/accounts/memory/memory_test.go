@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	"github.com/andrewstucki/web-app-tools/go/common"
+)
+
+const audience = "https://example.com"
+
+func publicKeyPEM(t *testing.T, publicKey interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestServiceAccounts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	manager := accounts.NewManager(NewStore(), audience).WithClock(func() time.Time {
+		return now
+	})
+
+	namespace := uuid.NewV4()
+	_, err := manager.Create(ctx, namespace, " ")
+	require.Equal(t, accounts.ErrInvalidName, err)
+	deployer, err := manager.Create(ctx, namespace, "deployer")
+	require.NoError(t, err)
+	builder, err := manager.Create(ctx, namespace, "builder")
+	require.NoError(t, err)
+	listed, err := manager.List(ctx, namespace)
+	require.NoError(t, err)
+	require.Equal(t, []accounts.ServiceAccount{*builder, *deployer}, listed)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, err = manager.RegisterKey(ctx, deployer.ID, []byte("not a key"))
+	require.Equal(t, accounts.ErrInvalidKey, err)
+	_, err = manager.RegisterKey(ctx, uuid.NewV4(), publicKeyPEM(t, &rsaKey.PublicKey))
+	require.Equal(t, accounts.ErrServiceAccountNotFound, err)
+	rsaRegistered, err := manager.RegisterKey(ctx, deployer.ID, publicKeyPEM(t, &rsaKey.PublicKey))
+	require.NoError(t, err)
+	ecdsaRegistered, err := manager.RegisterKey(ctx, builder.ID, publicKeyPEM(t, &ecdsaKey.PublicKey))
+	require.NoError(t, err)
+
+	sign := func(key interface{}, keyID string, account uuid.UUID, audience string, issuedAt time.Time, lifetime time.Duration) string {
+		assertion, err := accounts.SignAssertion(key, keyID, account, audience, issuedAt, lifetime)
+		require.NoError(t, err)
+		return assertion
+	}
+
+	authenticated, err := manager.Authenticate(ctx, sign(rsaKey, rsaRegistered.ID, deployer.ID, audience, now, time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, deployer, authenticated)
+	authenticated, err = manager.Authenticate(ctx, sign(ecdsaKey, ecdsaRegistered.ID, builder.ID, audience, now, time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, builder, authenticated)
+
+	for name, assertion := range map[string]string{
+		"malformed":           "assertion",
+		"another account":     sign(ecdsaKey, ecdsaRegistered.ID, deployer.ID, audience, now, time.Minute),
+		"another key":         sign(ecdsaKey, rsaRegistered.ID, deployer.ID, audience, now, time.Minute),
+		"another audience":    sign(rsaKey, rsaRegistered.ID, deployer.ID, "https://other.com", now, time.Minute),
+		"expired":             sign(rsaKey, rsaRegistered.ID, deployer.ID, audience, now.Add(-time.Hour), time.Minute),
+		"issued in future":    sign(rsaKey, rsaRegistered.ID, deployer.ID, audience, now.Add(time.Hour), time.Minute),
+		"too long a lifetime": sign(rsaKey, rsaRegistered.ID, deployer.ID, audience, now, 2*accounts.MaxAssertionLifetime),
+	} {
+		_, err := manager.Authenticate(ctx, assertion)
+		require.Equal(t, accounts.ErrInvalidAssertion, err, name)
+	}
+
+	_, err = manager.Authenticate(ctx, sign(rsaKey, "unknown", deployer.ID, audience, now, time.Minute))
+	require.Equal(t, accounts.ErrKeyNotFound, err)
+
+	// assertions are authenticated by the middleware
+	var principal *accounts.ServiceAccount
+	handler := accounts.Middleware(manager, common.NewJSONRenderer(), zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = accounts.FromContext(r.Context())
+	}))
+	request := func(assertion string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		if assertion != "" {
+			r.Header.Set("Authorization", "Bearer "+assertion)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, request(""))
+	require.Nil(t, principal)
+	require.Equal(t, http.StatusOK, request(sign(rsaKey, "unknown", deployer.ID, audience, now, time.Minute)))
+	require.Nil(t, principal)
+	require.Equal(t, http.StatusUnauthorized, request("assertion"))
+	require.Equal(t, http.StatusOK, request(sign(rsaKey, rsaRegistered.ID, deployer.ID, audience, now, time.Minute)))
+	require.Equal(t, deployer, principal)
+
+	require.Equal(t, accounts.ErrKeyNotFound, manager.RemoveKey(ctx, builder.ID, rsaRegistered.ID))
+	require.NoError(t, manager.RemoveKey(ctx, deployer.ID, rsaRegistered.ID))
+	_, err = manager.Authenticate(ctx, sign(rsaKey, rsaRegistered.ID, deployer.ID, audience, now, time.Minute))
+	require.Equal(t, accounts.ErrKeyNotFound, err)
+
+	require.NoError(t, manager.Delete(ctx, builder.ID))
+	require.Equal(t, accounts.ErrServiceAccountNotFound, manager.Delete(ctx, builder.ID))
+	_, err = manager.Authenticate(ctx, sign(ecdsaKey, ecdsaRegistered.ID, builder.ID, audience, now, time.Minute))
+	require.Equal(t, accounts.ErrKeyNotFound, err)
+}
@@ -0,0 +1,60 @@
+package accounts
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+var (
+	serviceAccountKey = "service-account-context-key"
+)
+
+// WithServiceAccount returns a context for requests made by the service
+// account, the service account is set as the actor
+func WithServiceAccount(ctx context.Context, account *ServiceAccount) context.Context {
+	ctx = security.WithActorKind(ctx, account.ID, security.PrincipalServiceAccount)
+	return context.WithValue(ctx, &serviceAccountKey, account)
+}
+
+// FromContext returns the service account set on the context with WithServiceAccount
+func FromContext(ctx context.Context) *ServiceAccount {
+	account := ctx.Value(&serviceAccountKey)
+	if account == nil {
+		return nil
+	}
+	return account.(*ServiceAccount)
+}
+
+// Middleware authenticates requests that have an assertion as their bearer token and
+// sets the service account on the request context with WithServiceAccount, requests
+// with a bearer token that isn't signed by a registered key, such as a google id token,
+// are passed along untouched and requests with an invalid assertion are rejected
+func Middleware(manager *Manager, render common.Renderer, logger zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+			if len(auth) != 2 || strings.ToLower(auth[0]) != "bearer" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			account, err := manager.Authenticate(r.Context(), auth[1])
+			switch err {
+			case nil:
+				next.ServeHTTP(w, r.Clone(WithServiceAccount(r.Context(), account)))
+			case ErrKeyNotFound:
+				next.ServeHTTP(w, r)
+			case ErrInvalidAssertion:
+				render.Error(w, http.StatusUnauthorized, err.Error())
+			default:
+				logger.Error().Err(err).Msg("error authenticating service account")
+				render.InternalError(w)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+)
+
+// Mode decides which login sets up the site
+type Mode string
+
+const (
+	// ModeFirstUser sets the site up on the first login
+	ModeFirstUser = Mode("first_user")
+	// ModeEmail sets the site up on the first login with an allow-listed, verified email
+	ModeEmail = Mode("email")
+	// ModeSetupToken sets the site up when a logged in user redeems the one-time
+	// setup token generated at startup
+	ModeSetupToken = Mode("setup_token")
+
+	setupTokenBytes = 24
+)
+
+var (
+	// ErrInvalidMode is returned when the mode isn't one of the known modes
+	ErrInvalidMode = errors.New("invalid setup mode")
+	// ErrAlreadyInitialized is returned when redeeming a setup token after the site is set up
+	ErrAlreadyInitialized = errors.New("the site has already been set up")
+	// ErrInvalidSetupToken is returned when redeeming the wrong setup token or
+	// redeeming one when the manager isn't in ModeSetupToken
+	ErrInvalidSetupToken = errors.New("invalid setup token")
+)
+
+// Valid checks whether the mode is one of the known modes
+func (m Mode) Valid() bool {
+	switch m {
+	case ModeFirstUser, ModeEmail, ModeSetupToken:
+		return true
+	}
+	return false
+}
+
+// Bootstrapper records whether the site has been set up, implementations need to
+// make sure that setup only ever runs once, even across replicas
+type Bootstrapper interface {
+	// Initialized reports whether the site has been set up
+	Initialized(ctx context.Context) (bool, error)
+	// Initialize runs setup and marks the site as set up unless it already is, the
+	// site is only marked when setup succeeds, it returns whether setup ran
+	Initialize(ctx context.Context, setup func(ctx context.Context) error) (bool, error)
+}
+
+// Manager sets the site up with the first login allowed by its mode
+type Manager struct {
+	bootstrapper Bootstrapper
+	mode         Mode
+	emails       map[string]struct{}
+	onCommit     func(ctx context.Context, fn func())
+
+	mutex       sync.Mutex
+	initialized bool
+	token       string
+}
+
+// NewManager creates a new manager backed by the bootstrapper in ModeFirstUser
+func NewManager(bootstrapper Bootstrapper) *Manager {
+	return &Manager{
+		bootstrapper: bootstrapper,
+		mode:         ModeFirstUser,
+		emails:       make(map[string]struct{}),
+		onCommit:     func(ctx context.Context, fn func()) { fn() },
+	}
+}
+
+// WithMode sets the mode, an empty mode is ModeFirstUser
+func (m *Manager) WithMode(mode Mode) *Manager {
+	if mode == "" {
+		mode = ModeFirstUser
+	}
+	m.mode = mode
+	return m
+}
+
+// WithEmails sets the emails allowed to set the site up in ModeEmail, they're
+// compared case insensitively
+func (m *Manager) WithEmails(emails ...string) *Manager {
+	for _, email := range emails {
+		m.emails[strings.ToLower(strings.TrimSpace(email))] = struct{}{}
+	}
+	return m
+}
+
+// WithOnCommit sets how to run a function once the transaction on a context commits,
+// e.g. sqlContext.OnCommit, the site is only remembered as set up once the transaction
+// that saw it commits so that a rolled back setup is never remembered. Without it
+// the site is remembered right away
+func (m *Manager) WithOnCommit(onCommit func(ctx context.Context, fn func())) *Manager {
+	m.onCommit = onCommit
+	return m
+}
+
+// Mode returns the manager's mode
+func (m *Manager) Mode() Mode {
+	return m.mode
+}
+
+// Login runs setup for the user logging in if the site hasn't been set up and
+// the mode allows them to set it up, it returns whether setup ran
+func (m *Manager) Login(ctx context.Context, claims *verifier.StandardClaims, setup func(ctx context.Context) error) (bool, error) {
+	switch m.mode {
+	case ModeFirstUser:
+	case ModeEmail:
+		if !m.allowed(claims) {
+			return false, nil
+		}
+	case ModeSetupToken:
+		return false, nil
+	default:
+		return false, ErrInvalidMode
+	}
+	return m.initialize(ctx, setup)
+}
+
+// SetupToken returns the one-time setup token in ModeSetupToken, it's generated on
+// the first call and is empty once the site has been set up, since tokens are kept in
+// memory each replica has its own
+func (m *Manager) SetupToken(ctx context.Context) (string, error) {
+	if m.mode != ModeSetupToken {
+		return "", nil
+	}
+	initialized, err := m.Initialized(ctx)
+	if err != nil || initialized {
+		return "", err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.token == "" {
+		random := make([]byte, setupTokenBytes)
+		if _, err := rand.Read(random); err != nil {
+			return "", errors.Wrap(err, "failed to generate setup token")
+		}
+		m.token = base64.RawURLEncoding.EncodeToString(random)
+	}
+	return m.token, nil
+}
+
+// Redeem runs setup for the logged in user if the token is the setup token, the
+// token is cleared once the setup commits, until then redeeming it again returns
+// ErrAlreadyInitialized
+func (m *Manager) Redeem(ctx context.Context, token string, setup func(ctx context.Context) error) error {
+	m.mutex.Lock()
+	expected := m.token
+	m.mutex.Unlock()
+	if m.mode != ModeSetupToken || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return ErrInvalidSetupToken
+	}
+	ran, err := m.initialize(ctx, setup)
+	if err != nil {
+		return err
+	}
+	if !ran {
+		return ErrAlreadyInitialized
+	}
+	return nil
+}
+
+// Initialized reports whether the site has been set up, once it has the
+// bootstrapper isn't checked again since a committed setup never reverts
+func (m *Manager) Initialized(ctx context.Context) (bool, error) {
+	m.mutex.Lock()
+	initialized := m.initialized
+	m.mutex.Unlock()
+	if initialized {
+		return true, nil
+	}
+	initialized, err := m.bootstrapper.Initialized(ctx)
+	if err != nil {
+		return false, err
+	}
+	if initialized {
+		m.onCommit(ctx, m.markInitialized)
+	}
+	return initialized, nil
+}
+
+// initialize runs setup through the bootstrapper, the site is marked
+// as set up once the caller's transaction commits
+func (m *Manager) initialize(ctx context.Context, setup func(ctx context.Context) error) (bool, error) {
+	initialized, err := m.Initialized(ctx)
+	if err != nil || initialized {
+		return false, err
+	}
+	ran, err := m.bootstrapper.Initialize(ctx, setup)
+	if err != nil {
+		return false, err
+	}
+	m.onCommit(ctx, m.markInitialized)
+	return ran, nil
+}
+
+func (m *Manager) markInitialized() {
+	m.mutex.Lock()
+	m.initialized = true
+	m.token = ""
+	m.mutex.Unlock()
+}
+
+func (m *Manager) allowed(claims *verifier.StandardClaims) bool {
+	if claims == nil || !claims.EmailVerified {
+		return false
+	}
+	_, ok := m.emails[strings.ToLower(claims.Email)]
+	return ok
+}
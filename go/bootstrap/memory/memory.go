@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// Bootstrapper is a bootstrapper that keeps whether
+// the site has been set up in memory, it's only safe
+// to use with a single replica
+type Bootstrapper struct {
+	mutex       sync.Mutex
+	initialized bool
+}
+
+// NewBootstrapper creates a new bootstrapper for a
+// site that hasn't been set up
+func NewBootstrapper() *Bootstrapper {
+	return &Bootstrapper{}
+}
+
+// Initialized reports whether the site has been set up
+func (b *Bootstrapper) Initialized(ctx context.Context) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.initialized, nil
+}
+
+// Initialize runs setup and marks the site as set up unless it already is
+func (b *Bootstrapper) Initialize(ctx context.Context, setup func(ctx context.Context) error) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.initialized {
+		return false, nil
+	}
+	if err := setup(ctx); err != nil {
+		return false, err
+	}
+	b.initialized = true
+	return true, nil
+}
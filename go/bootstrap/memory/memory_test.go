@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
+	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+)
+
+func TestFirstUser(t *testing.T) {
+	ctx := context.Background()
+	manager := bootstrap.NewManager(NewBootstrapper())
+	claims := &verifier.StandardClaims{Email: "user@example.com"}
+
+	failed := errors.New("failed")
+	_, err := manager.Login(ctx, claims, func(ctx context.Context) error { return failed })
+	require.Equal(t, failed, err)
+
+	// setup only runs once no matter how many logins race
+	var runs int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := manager.Login(ctx, claims, func(ctx context.Context) error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), runs)
+
+	initialized, err := manager.Initialized(ctx)
+	require.NoError(t, err)
+	require.True(t, initialized)
+	token, err := manager.SetupToken(ctx)
+	require.NoError(t, err)
+	require.Empty(t, token)
+}
+
+func TestEmail(t *testing.T) {
+	ctx := context.Background()
+	manager := bootstrap.NewManager(NewBootstrapper()).WithMode(bootstrap.ModeEmail).WithEmails("Admin@Example.com")
+	setup := func(ctx context.Context) error { return nil }
+
+	ran, err := manager.Login(ctx, &verifier.StandardClaims{Email: "user@example.com", EmailVerified: true}, setup)
+	require.NoError(t, err)
+	require.False(t, ran)
+	ran, err = manager.Login(ctx, &verifier.StandardClaims{Email: "admin@example.com"}, setup)
+	require.NoError(t, err)
+	require.False(t, ran)
+	ran, err = manager.Login(ctx, &verifier.StandardClaims{Email: "admin@example.com", EmailVerified: true}, setup)
+	require.NoError(t, err)
+	require.True(t, ran)
+	ran, err = manager.Login(ctx, &verifier.StandardClaims{Email: "admin@example.com", EmailVerified: true}, setup)
+	require.NoError(t, err)
+	require.False(t, ran)
+}
+
+func TestSetupToken(t *testing.T) {
+	ctx := context.Background()
+	manager := bootstrap.NewManager(NewBootstrapper()).WithMode(bootstrap.ModeSetupToken)
+	setup := func(ctx context.Context) error { return nil }
+
+	ran, err := manager.Login(ctx, &verifier.StandardClaims{Email: "user@example.com"}, setup)
+	require.NoError(t, err)
+	require.False(t, ran)
+	require.Equal(t, bootstrap.ErrInvalidSetupToken, manager.Redeem(ctx, "", setup))
+
+	token, err := manager.SetupToken(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	again, err := manager.SetupToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, token, again)
+
+	require.Equal(t, bootstrap.ErrInvalidSetupToken, manager.Redeem(ctx, "wrong", setup))
+	require.NoError(t, manager.Redeem(ctx, token, setup))
+	require.Equal(t, bootstrap.ErrInvalidSetupToken, manager.Redeem(ctx, token, setup))
+	token, err = manager.SetupToken(ctx)
+	require.NoError(t, err)
+	require.Empty(t, token)
+}
+
+func TestOnCommit(t *testing.T) {
+	ctx := context.Background()
+	var hooks []func()
+	manager := bootstrap.NewManager(NewBootstrapper()).WithMode(bootstrap.ModeSetupToken).WithOnCommit(func(ctx context.Context, fn func()) {
+		hooks = append(hooks, fn)
+	})
+	setup := func(ctx context.Context) error { return nil }
+
+	token, err := manager.SetupToken(ctx)
+	require.NoError(t, err)
+	require.NoError(t, manager.Redeem(ctx, token, setup))
+	// the token is kept until the setup commits but can't set the site up again
+	require.Equal(t, bootstrap.ErrAlreadyInitialized, manager.Redeem(ctx, token, setup))
+
+	require.NotEmpty(t, hooks)
+	for _, hook := range hooks {
+		hook()
+	}
+	require.Equal(t, bootstrap.ErrInvalidSetupToken, manager.Redeem(ctx, token, setup))
+}
@@ -0,0 +1,125 @@
+// Command usergen generates a typed wrapper around server.CurrentUser, it's meant
+// to be run with go generate, e.g.
+//
+//	//go:generate go run github.com/andrewstucki/web-app-tools/go/cmd/usergen -type example/models.User
+//
+// which writes CurrentUser and ResolveUser functions for *models.User to current_user.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+var generated = template.Must(template.New("user").Parse(`// Code generated by usergen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/andrewstucki/web-app-tools/go/server"
+{{- if .ImportPath}}
+
+	{{if .Renamed}}{{.Alias}} {{end}}"{{.ImportPath}}"
+{{- end}}
+)
+
+// CurrentUser returns the current user, it's nil if there is no current user
+func CurrentUser(ctx context.Context) (*{{.Type}}, error) {
+	current, err := server.CurrentUser(ctx)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	user, ok := current.(*{{.Type}})
+	if !ok {
+		return nil, server.ErrUserType
+	}
+	return user, nil
+}
+
+// ResolveUser adapts a typed resolver for use as the server's GetCurrentUser
+func ResolveUser(resolver func(ctx context.Context, claimsOrToken *server.ClaimsOrToken) (*{{.Type}}, error)) func(ctx context.Context, claimsOrToken *server.ClaimsOrToken) (interface{}, error) {
+	return func(ctx context.Context, claimsOrToken *server.ClaimsOrToken) (interface{}, error) {
+		user, err := resolver(ctx, claimsOrToken)
+		if err != nil || user == nil {
+			return nil, err
+		}
+		return user, nil
+	}
+}
+`))
+
+type options struct {
+	Package    string
+	ImportPath string
+	Alias      string
+	// Renamed is set when the alias differs from the last element of the import path
+	Renamed bool
+	Type    string
+}
+
+// parseType splits a type such as "example/models.User" into its import path,
+// package alias and qualified name, types without an import path are local
+func parseType(name, pkg string) (*options, error) {
+	separator := strings.LastIndex(name, ".")
+	if separator == -1 {
+		if name == "" {
+			return nil, fmt.Errorf("a type is required")
+		}
+		return &options{Package: pkg, Type: name}, nil
+	}
+	importPath, typeName := name[:separator], name[separator+1:]
+	if importPath == "" || typeName == "" {
+		return nil, fmt.Errorf("invalid type %q", name)
+	}
+	alias := strings.Replace(path.Base(importPath), "-", "_", -1)
+	return &options{
+		Package:    pkg,
+		ImportPath: importPath,
+		Alias:      alias,
+		Renamed:    alias != path.Base(importPath),
+		Type:       alias + "." + typeName,
+	}, nil
+}
+
+func generate(options *options) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := generated.Execute(&buffer, options); err != nil {
+		return nil, err
+	}
+	return format.Source(buffer.Bytes())
+}
+
+func main() {
+	typeName := flag.String("type", "", "the user type, e.g. example/models.User")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "the package of the generated file")
+	output := flag.String("output", "current_user.go", "the generated file")
+	flag.Parse()
+
+	options, err := parseType(*typeName, *pkg)
+	if err == nil && options.Package == "" {
+		err = fmt.Errorf("a package is required outside of go generate")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	source, err := generate(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*output, source, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
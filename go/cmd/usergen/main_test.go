@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	parsed, err := parseType("example/models.User", "routes")
+	require.NoError(t, err)
+	require.Equal(t, &options{Package: "routes", ImportPath: "example/models", Alias: "models", Type: "models.User"}, parsed)
+	source, err := generate(parsed)
+	require.NoError(t, err)
+	require.Contains(t, string(source), "package routes")
+	require.Contains(t, string(source), `"example/models"`)
+	require.Contains(t, string(source), "func CurrentUser(ctx context.Context) (*models.User, error)")
+
+	parsed, err = parseType("User", "main")
+	require.NoError(t, err)
+	source, err = generate(parsed)
+	require.NoError(t, err)
+	require.NotContains(t, string(source), "models")
+	require.Contains(t, string(source), "user, ok := current.(*User)")
+
+	parsed, err = parseType("example/user-models.User", "main")
+	require.NoError(t, err)
+	source, err = generate(parsed)
+	require.NoError(t, err)
+	require.Contains(t, string(source), `user_models "example/user-models"`)
+
+	_, err = parseType("", "main")
+	require.Error(t, err)
+	_, err = parseType("example/models.", "main")
+	require.Error(t, err)
+}
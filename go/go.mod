@@ -20,4 +20,5 @@ require (
 	github.com/unrolled/render v1.0.2
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	gopkg.in/dgrijalva/jwt-go.v3 v3.2.0
+	gopkg.in/yaml.v2 v2.2.2
 )
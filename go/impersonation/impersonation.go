@@ -0,0 +1,193 @@
+package impersonation
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+const (
+	// ResourceImpersonation is the resource checked before starting a session, the
+	// impersonated user's id is appended to it, e.g. "impersonation/<id>", so that
+	// policies can limit who can be impersonated
+	ResourceImpersonation = security.Resource("impersonation")
+	// DefaultMaxDuration is the longest a session can last unless another
+	// limit is set with WithMaxDuration
+	DefaultMaxDuration = time.Hour
+)
+
+var (
+	// ErrSessionNotFound is returned when a session doesn't exist, belongs to
+	// another actor or has been stopped
+	ErrSessionNotFound = errors.New("impersonation session not found")
+	// ErrSessionExpired is returned when authenticating with a session that has expired
+	ErrSessionExpired = errors.New("impersonation session expired")
+	// ErrInvalidDuration is returned when starting a session that doesn't end
+	// in the future or lasts longer than the maximum duration
+	ErrInvalidDuration = errors.New("invalid impersonation duration")
+	// ErrSelfImpersonation is returned when a user tries to impersonate themselves
+	ErrSelfImpersonation = errors.New("users can't impersonate themselves")
+	// ErrForbidden is returned when the actor isn't allowed to impersonate the user
+	ErrForbidden = errors.New("impersonation not allowed")
+)
+
+// Session lets an actor, e.g. a support engineer, act as another user until it
+// expires or is stopped
+type Session struct {
+	ID     uuid.UUID `json:"id" db:"id"`
+	Actor  uuid.UUID `json:"actor" db:"actor_id"`
+	User   uuid.UUID `json:"user" db:"user_id"`
+	Reason string    `json:"reason" db:"reason"`
+
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	ExpiresAt time.Time  `json:"expiresAt" db:"expires_at"`
+	EndedAt   *time.Time `json:"endedAt,omitempty" db:"ended_at"`
+}
+
+// Expired checks whether the session has expired
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// Store is the storage interface for impersonation sessions
+type Store interface {
+	// CreateSession stores a new session
+	CreateSession(ctx context.Context, session *Session) error
+	// GetSession returns the session with the given id or ErrSessionNotFound
+	GetSession(ctx context.Context, id uuid.UUID) (*Session, error)
+	// EndSession records when the actor's session was stopped, it returns
+	// ErrSessionNotFound if the actor has no such session that is still running
+	EndSession(ctx context.Context, actor, id uuid.UUID, endedAt time.Time) error
+}
+
+// Manager starts, authenticates and stops impersonation sessions
+type Manager struct {
+	store       Store
+	authorizer  *security.Authorizer
+	maxDuration time.Duration
+	now         func() time.Time
+}
+
+// NewManager creates a new impersonation manager backed by the store
+func NewManager(store Store) *Manager {
+	return &Manager{
+		store:       store,
+		maxDuration: DefaultMaxDuration,
+		now:         time.Now,
+	}
+}
+
+// WithAuthorizer sets the authorizer that actors are checked against,
+// it defaults to security.Default()
+func (m *Manager) WithAuthorizer(authorizer *security.Authorizer) *Manager {
+	m.authorizer = authorizer
+	return m
+}
+
+// WithMaxDuration sets the longest a session can last
+func (m *Manager) WithMaxDuration(duration time.Duration) *Manager {
+	m.maxDuration = duration
+	return m
+}
+
+// WithClock sets the function used to get the current time
+func (m *Manager) WithClock(now func() time.Time) *Manager {
+	m.now = now
+	return m
+}
+
+func (m *Manager) getAuthorizer() *security.Authorizer {
+	if m.authorizer == nil {
+		return security.Default()
+	}
+	return m.authorizer
+}
+
+// Start starts a session for the actor to act as the user, the actor needs to be
+// able to create the user's impersonation resource, e.g. with a policy such as
+// {Resource: "impersonation/*", Action: "create"}, and to hold every policy of the
+// user, starting and stopping sessions is recorded in the authorizer's audit log
+func (m *Manager) Start(ctx context.Context, actor, user uuid.UUID, duration time.Duration, reason string) (*Session, error) {
+	if uuid.Equal(actor, user) {
+		return nil, ErrSelfImpersonation
+	}
+	if duration <= 0 || duration > m.maxDuration {
+		return nil, ErrInvalidDuration
+	}
+	authorizer := m.getAuthorizer()
+	ctx = security.WithActor(ctx, actor)
+	allowed, err := authorizer.WithUser(actor).Can(ctx, security.ActionCreate, Resource(user))
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden
+	}
+	// nobody can see more as the user than they could see as themselves
+	policies, err := authorizer.PoliciesFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	delegated, err := authorizer.CanDelegate(ctx, actor, policies)
+	if err != nil {
+		return nil, err
+	}
+	if !delegated {
+		return nil, ErrForbidden
+	}
+
+	now := m.now()
+	session := &Session{
+		ID:        uuid.NewV4(),
+		Actor:     actor,
+		User:      user,
+		Reason:    reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+	if err := m.store.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	if err := authorizer.Audit(ctx, security.AuditImpersonationStart, security.PrincipalUser, uuid.UUID{}, user); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Stop ends the actor's session with the given id
+func (m *Manager) Stop(ctx context.Context, actor, id uuid.UUID) error {
+	session, err := m.store.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := m.store.EndSession(ctx, actor, id, m.now()); err != nil {
+		return err
+	}
+	return m.getAuthorizer().Audit(security.WithActor(ctx, actor), security.AuditImpersonationStop, security.PrincipalUser, uuid.UUID{}, session.User)
+}
+
+// Authenticate returns the actor's running session with the given id, it returns
+// ErrSessionNotFound for unknown, stopped or other actors' sessions and
+// ErrSessionExpired for expired ones
+func (m *Manager) Authenticate(ctx context.Context, actor, id uuid.UUID) (*Session, error) {
+	session, err := m.store.GetSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !uuid.Equal(session.Actor, actor) || session.EndedAt != nil {
+		return nil, ErrSessionNotFound
+	}
+	if session.Expired(m.now()) {
+		return nil, ErrSessionExpired
+	}
+	return session, nil
+}
+
+// Resource returns the resource checked before impersonating the user
+func Resource(user uuid.UUID) security.Resource {
+	return ResourceImpersonation + security.Resource("/"+user.String())
+}
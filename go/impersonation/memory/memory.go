@@ -0,0 +1,61 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Store is an impersonation store that keeps
+// every session in memory
+type Store struct {
+	mutex    sync.RWMutex
+	sessions map[uuid.UUID]impersonation.Session
+}
+
+// NewStore creates a new impersonation store that
+// stores everything in memory
+func NewStore() *Store {
+	return &Store{
+		sessions: make(map[uuid.UUID]impersonation.Session),
+	}
+}
+
+// CreateSession stores a new session
+func (s *Store) CreateSession(ctx context.Context, session *impersonation.Session) error {
+	s.mutex.Lock()
+	s.sessions[session.ID] = *session
+	s.mutex.Unlock()
+	return nil
+}
+
+// GetSession returns the session with the given id or ErrSessionNotFound
+func (s *Store) GetSession(ctx context.Context, id uuid.UUID) (*impersonation.Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, impersonation.ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+// EndSession records when the actor's session was stopped, it returns
+// ErrSessionNotFound if the actor has no such session that is still running
+func (s *Store) EndSession(ctx context.Context, actor, id uuid.UUID, endedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || !uuid.Equal(session.Actor, actor) || session.EndedAt != nil {
+		return impersonation.ErrSessionNotFound
+	}
+	session.EndedAt = &endedAt
+	s.sessions[id] = session
+	return nil
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	securityMemory "github.com/andrewstucki/web-app-tools/go/security/memory"
+)
+
+func testManager(t *testing.T) (*impersonation.Manager, *security.Authorizer, uuid.UUID, *time.Time) {
+	support := security.Role{Name: "support", Policies: []security.Policy{
+		{Resource: "impersonation/*", Action: security.ActionCreate},
+	}}
+	authorizer := security.NewAuthorizer(securityMemory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(support))
+	actor := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), support, actor))
+
+	now := time.Now()
+	manager := impersonation.NewManager(NewStore()).WithAuthorizer(authorizer).WithClock(func() time.Time {
+		return now
+	})
+	return manager, authorizer, actor, &now
+}
+
+func TestManager(t *testing.T) {
+	manager, authorizer, actor, now := testManager(t)
+	audit := securityMemory.NewAuditLog()
+	authorizer.WithAudit(audit, security.AuditOptions{})
+	ctx := context.Background()
+	user := uuid.NewV4()
+
+	admin := security.Role{Name: "admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	require.NoError(t, authorizer.Register(admin))
+	privileged := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(ctx, admin, privileged))
+	_, err := manager.Start(ctx, actor, privileged, time.Minute, "")
+	require.Equal(t, impersonation.ErrForbidden, err)
+
+	_, err = manager.Start(ctx, actor, actor, time.Minute, "")
+	require.Equal(t, impersonation.ErrSelfImpersonation, err)
+	_, err = manager.Start(ctx, actor, user, 2*time.Hour, "")
+	require.Equal(t, impersonation.ErrInvalidDuration, err)
+	_, err = manager.Start(ctx, user, actor, time.Minute, "")
+	require.Equal(t, impersonation.ErrForbidden, err)
+
+	session, err := manager.Start(ctx, actor, user, 30*time.Minute, "ticket 123")
+	require.NoError(t, err)
+	require.Equal(t, user, session.User)
+	require.True(t, now.Add(30*time.Minute).Equal(session.ExpiresAt))
+
+	_, err = manager.Authenticate(ctx, user, session.ID)
+	require.Equal(t, impersonation.ErrSessionNotFound, err)
+	authenticated, err := manager.Authenticate(ctx, actor, session.ID)
+	require.NoError(t, err)
+	require.Equal(t, session.ID, authenticated.ID)
+
+	*now = session.ExpiresAt
+	_, err = manager.Authenticate(ctx, actor, session.ID)
+	require.Equal(t, impersonation.ErrSessionExpired, err)
+
+	require.Equal(t, impersonation.ErrSessionNotFound, manager.Stop(ctx, user, session.ID))
+	require.NoError(t, manager.Stop(ctx, actor, session.ID))
+	require.Equal(t, impersonation.ErrSessionNotFound, manager.Stop(ctx, actor, session.ID))
+	_, err = manager.Authenticate(ctx, actor, session.ID)
+	require.Equal(t, impersonation.ErrSessionNotFound, err)
+
+	events, err := audit.History(ctx, security.AuditQuery{Subject: &user})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, security.AuditImpersonationStop, events[0].Kind)
+	require.Equal(t, security.AuditImpersonationStart, events[1].Kind)
+	require.Equal(t, actor, events[1].Actor)
+}
+
+func TestRouterAndMiddleware(t *testing.T) {
+	manager, _, actor, _ := testManager(t)
+	user := uuid.NewV4()
+	render := common.NewJSONRenderer()
+
+	router := impersonation.NewRouter(impersonation.RouterConfig{
+		Manager: manager,
+		Render:  render,
+		Logger:  zerolog.Nop(),
+	})
+	request := func(as uuid.UUID, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r = r.WithContext(security.WithActor(r.Context(), as))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, request(uuid.UUID{}, "POST", "/", `{}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(actor, "POST", "/", `{"user":"`+user.String()+`","duration":"forever"}`).Code)
+	require.Equal(t, http.StatusForbidden, request(user, "POST", "/", `{"user":"`+actor.String()+`","duration":"30m"}`).Code)
+	response := request(actor, "POST", "/", `{"user":"`+user.String()+`","duration":"30m","reason":"ticket 123"}`)
+	require.Equal(t, http.StatusCreated, response.Code)
+	session := &impersonation.Session{}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), session))
+
+	var seen *impersonation.Session
+	var seenActor uuid.UUID
+	protected := impersonation.Middleware(manager, render, zerolog.Nop(), func(r *http.Request) (uuid.UUID, error) {
+		return actor, nil
+	})(impersonation.RequireRealUser(render)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	handler := impersonation.Middleware(manager, render, zerolog.Nop(), func(r *http.Request) (uuid.UUID, error) {
+		return actor, nil
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = impersonation.FromContext(r.Context())
+		seenActor, _ = security.ActorFrom(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(handler http.Handler, header string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set(impersonation.Header, header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, call(handler, ""))
+	require.Nil(t, seen)
+	require.Equal(t, http.StatusUnauthorized, call(handler, "invalid"))
+	require.Equal(t, http.StatusUnauthorized, call(handler, uuid.NewV4().String()))
+	require.Equal(t, http.StatusOK, call(handler, session.ID.String()))
+	require.NotNil(t, seen)
+	require.Equal(t, user, seen.User)
+	// the real actor is kept for audit logs and security checks
+	require.Equal(t, actor, seenActor)
+
+	require.Equal(t, http.StatusOK, call(protected, ""))
+	require.Equal(t, http.StatusForbidden, call(protected, session.ID.String()))
+
+	require.Equal(t, http.StatusNotFound, request(user, "DELETE", "/"+session.ID.String(), "").Code)
+	require.Equal(t, http.StatusNoContent, request(actor, "DELETE", "/"+session.ID.String(), "").Code)
+	require.Equal(t, http.StatusUnauthorized, call(handler, session.ID.String()))
+}
@@ -0,0 +1,91 @@
+package impersonation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// Header is the header that impersonation session ids are read from
+const Header = "X-Impersonation-Session"
+
+var (
+	sessionKey = "impersonation-session-context-key"
+)
+
+// WithSession returns a context for requests made during the session, the real
+// actor is kept as the security actor so that audit logs and checks made against
+// the actor are attributed to them rather than to the impersonated user
+func WithSession(ctx context.Context, session *Session) context.Context {
+	ctx = security.WithActor(ctx, session.Actor)
+	return context.WithValue(ctx, &sessionKey, session)
+}
+
+// FromContext returns the session set on the context with WithSession
+func FromContext(ctx context.Context) *Session {
+	session := ctx.Value(&sessionKey)
+	if session == nil {
+		return nil
+	}
+	return session.(*Session)
+}
+
+// Middleware authenticates requests that have a session id in the X-Impersonation-Session
+// header against the actor making the request and sets the session on the request context
+// with WithSession, requests without a session are passed along untouched and requests
+// with an unknown, stopped or expired session are rejected
+func Middleware(manager *Manager, render common.Renderer, logger zerolog.Logger, actor func(r *http.Request) (uuid.UUID, error)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(Header)
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := uuid.FromString(header)
+			if err != nil {
+				render.Error(w, http.StatusUnauthorized, ErrSessionNotFound.Error())
+				return
+			}
+			current, err := actor(r)
+			if err != nil {
+				logger.Error().Err(err).Msg("error getting current user")
+				render.InternalError(w)
+				return
+			}
+			if uuid.Equal(current, uuid.UUID{}) {
+				render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+				return
+			}
+			session, err := manager.Authenticate(r.Context(), current, id)
+			switch err {
+			case nil:
+				next.ServeHTTP(w, r.Clone(WithSession(r.Context(), session)))
+			case ErrSessionNotFound, ErrSessionExpired:
+				render.Error(w, http.StatusUnauthorized, err.Error())
+			default:
+				logger.Error().Err(err).Msg("error authenticating impersonation session")
+				render.InternalError(w)
+			}
+		})
+	}
+}
+
+// RequireRealUser is a middleware for sensitive endpoints that rejects
+// requests made while impersonating another user
+func RequireRealUser(render common.Renderer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if FromContext(r.Context()) != nil {
+				render.Error(w, http.StatusForbidden, "not allowed while impersonating")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package impersonation
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// RouterConfig provides the configuration for the impersonation router
+type RouterConfig struct {
+	Manager *Manager
+	Render  common.Renderer
+	Logger  zerolog.Logger
+	// Actor returns the real user making the request, it defaults to the
+	// user set on the request context with security.WithActor
+	Actor func(r *http.Request) (uuid.UUID, error)
+}
+
+// StartRequest is the body used to start impersonating a user
+type StartRequest struct {
+	User uuid.UUID `json:"user"`
+	// Duration is parsed with time.ParseDuration, e.g. "30m"
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type impersonationHandler struct {
+	manager *Manager
+	render  common.Renderer
+	logger  zerolog.Logger
+	actor   func(r *http.Request) (uuid.UUID, error)
+}
+
+// NewRouter returns a router with endpoints for starting and stopping impersonation
+// sessions, the returned session's id is sent in the X-Impersonation-Session header
+// to act as the impersonated user
+func NewRouter(config RouterConfig) chi.Router {
+	handler := &impersonationHandler{
+		manager: config.Manager,
+		render:  config.Render,
+		logger:  config.Logger,
+		actor:   config.Actor,
+	}
+	if handler.actor == nil {
+		handler.actor = security.ActorFromRequest
+	}
+
+	router := chi.NewRouter()
+	router.Post("/", handler.start)
+	router.Delete("/{session}", handler.stop)
+	return router
+}
+
+func (h *impersonationHandler) start(w http.ResponseWriter, r *http.Request) {
+	h.authenticated(w, r, func(actor uuid.UUID) {
+		if FromContext(r.Context()) != nil {
+			h.render.Error(w, http.StatusForbidden, "impersonation sessions can't be nested")
+			return
+		}
+		request := StartRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.render.Error(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil {
+			h.render.Error(w, http.StatusBadRequest, "invalid duration")
+			return
+		}
+		session, err := h.manager.Start(r.Context(), actor, request.User, duration, request.Reason)
+		if err != nil {
+			h.sessionError(w, err)
+			return
+		}
+		h.render.Render(w, http.StatusCreated, session)
+	})
+}
+
+func (h *impersonationHandler) stop(w http.ResponseWriter, r *http.Request) {
+	h.authenticated(w, r, func(actor uuid.UUID) {
+		id, err := uuid.FromString(chi.URLParam(r, "session"))
+		if err != nil {
+			h.render.Error(w, http.StatusBadRequest, "invalid session")
+			return
+		}
+		if err := h.manager.Stop(r.Context(), actor, id); err != nil {
+			h.sessionError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (h *impersonationHandler) sessionError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrSessionNotFound:
+		h.render.Error(w, http.StatusNotFound, err.Error())
+	case ErrInvalidDuration, ErrSelfImpersonation:
+		h.render.Error(w, http.StatusBadRequest, err.Error())
+	case ErrForbidden:
+		h.render.Error(w, http.StatusForbidden, err.Error())
+	default:
+		h.logger.Error().Err(err).Msg("error handling impersonation request")
+		h.render.InternalError(w)
+	}
+}
+
+// authenticated calls inner with the real user making the request
+func (h *impersonationHandler) authenticated(w http.ResponseWriter, r *http.Request, inner func(actor uuid.UUID)) {
+	actor, err := h.actor(r)
+	if err != nil && err != security.ErrNoActor {
+		h.logger.Error().Err(err).Msg("error getting current user")
+		h.render.InternalError(w)
+		return
+	}
+	if err == security.ErrNoActor || uuid.Equal(actor, uuid.UUID{}) {
+		h.render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+	inner(actor)
+}
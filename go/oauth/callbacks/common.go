@@ -6,7 +6,9 @@ const (
 	// MessageTokenRejected is displayed when a token handed back from Google has been rejected
 	// for some reason, often due to an Audience or Domain mismatch
 	MessageTokenRejected = "The token received was rejected, make sure you signed in with the right account."
-	errorString          = `
+	// MessageLoginDenied is displayed when a login is denied without a reason
+	MessageLoginDenied = "You aren't allowed to sign in."
+	errorString        = `
 <!doctype html>
 	<body>
 	{{.}}
@@ -67,6 +67,14 @@ func (c *CookieCallbacks) OnSuccess(w http.ResponseWriter, location, token strin
 	w.Header().Set("Location", location)
 }
 
+// OnDenied returns a forbidden status with the reason the login was denied
+func (c *CookieCallbacks) OnDenied(w http.ResponseWriter, reason string) {
+	if reason == "" {
+		reason = MessageLoginDenied
+	}
+	c.renderer().Error(w, http.StatusForbidden, reason)
+}
+
 // OnInvalidToken returns an invalid token status
 func (c *CookieCallbacks) OnInvalidToken(w http.ResponseWriter, err error) {
 	c.renderer().Error(w, http.StatusUnauthorized, MessageTokenRejected)
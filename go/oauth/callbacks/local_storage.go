@@ -82,6 +82,14 @@ func (c *LocalStorageCallbacks) OnSuccess(w http.ResponseWriter, location, token
 	})
 }
 
+// OnDenied returns a forbidden status with the reason the login was denied
+func (c *LocalStorageCallbacks) OnDenied(w http.ResponseWriter, reason string) {
+	if reason == "" {
+		reason = MessageLoginDenied
+	}
+	c.renderer().Error(w, http.StatusForbidden, reason)
+}
+
 // OnInvalidToken returns an invalid token status
 func (c *LocalStorageCallbacks) OnInvalidToken(w http.ResponseWriter, err error) {
 	c.renderer().Error(w, http.StatusUnauthorized, MessageTokenRejected)
@@ -28,21 +28,38 @@ type Callbacks interface {
 	OnRefresh(w http.ResponseWriter, raw string) error
 }
 
+// DeniedCallbacks are callbacks that render logins denied by the login hook,
+// it's optional so that existing callbacks keep working, callbacks that don't
+// implement it get ErrLoginDenied passed to OnError
+type DeniedCallbacks interface {
+	// OnDenied is invoked when the login hook denies a login
+	OnDenied(w http.ResponseWriter, reason string)
+}
+
 // Config is a configuration object for OAuth handlers.
 type Config struct {
 	// ClientTimeout is the timeout for doing the OAuth token exchange
 	// if none is specified, defaults to 10 seconds
 	ClientTimeout time.Duration
 	// Verifier specifies the JWT verifier for the id token
-	Verifier *verifier.Verifier
+	// if none is specified, defaults to a verifier.Verifier
+	Verifier Verifier
 	// TokenManager manages token storage
 	TokenManager TokenManager
 	// Callbacks manage the error/success handling of the endpoint
 	Callbacks Callbacks
+	// OnLogin is invoked at the end of every OAuth flow before OnSuccess, its
+	// decision can deny the login or redirect the user elsewhere, redirects
+	// have to be in AllowedRedirects and the user's refresh token is only
+	// stored once the login is allowed
+	OnLogin LoginHook
 	// AllowedRedirects whitelists where we can redirect to after getting a token
 	AllowedRedirects []string
 	// Logger is a zerolog instance used for logging
 	Logger *zerolog.Logger
+	// Clock provides the current time when issuing and checking
+	// expirations, if none is specified, defaults to time.Now
+	Clock func() time.Time
 
 	// All of these must be specified
 
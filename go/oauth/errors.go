@@ -23,6 +23,8 @@ var (
 	// ErrInvalidToken occurs when we the token returned after the exchange
 	// by the provider is bad
 	ErrInvalidToken = errors.New("invalid token")
+	// ErrLoginDenied occurs when the login hook denies a login
+	ErrLoginDenied = errors.New("login denied")
 
 	// The following values are annotations around the underlying errors
 
@@ -38,6 +40,8 @@ var (
 	// MessageUserFailed occurs when we can't get information about the user from
 	// the provider
 	MessageUserFailed = "user retrieval failed"
+	// MessageLoginHookFailed occurs when the login hook errors
+	MessageLoginHookFailed = "login hook failed"
 	// MessageStateGenerationFailed occurs when we can't generate the state cookie for some
 	// reason
 	MessageStateGenerationFailed = "state generation failed"
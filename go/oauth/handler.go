@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"html/template"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -42,8 +43,10 @@ type Handler struct {
 	url              string
 	timeout          time.Duration
 	tokenManager     TokenManager
-	verifier         *verifier.Verifier
+	verifier         Verifier
+	now              func() time.Time
 	callbacks        Callbacks
+	onLogin          LoginHook
 	secretKey        string
 	allowedRedirects []string
 	logger           zerolog.Logger
@@ -65,6 +68,11 @@ func New(config *Config) (*Handler, error) {
 		tokenVerifier = verifier.NewVerifier()
 	}
 
+	clock := config.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+
 	tokenManager := config.TokenManager
 	if tokenManager == nil {
 		tokenManager = state.NewMemoryTokenManager()
@@ -96,9 +104,11 @@ func New(config *Config) (*Handler, error) {
 		},
 		url:              config.MountURL,
 		callbacks:        tokenCallbacks,
+		onLogin:          config.OnLogin,
 		timeout:          timeout,
 		tokenManager:     tokenManager,
 		verifier:         tokenVerifier,
+		now:              clock,
 		secretKey:        config.SecretKey,
 		allowedRedirects: allowedRedirects,
 		logger:           logger,
@@ -135,15 +145,7 @@ func (h *Handler) handleBegin(w http.ResponseWriter, r *http.Request) {
 		location = "/"
 	}
 
-	found := false
-	for _, whitelisted := range h.allowedRedirects {
-		if whitelisted == location {
-			found = true
-			break
-		}
-	}
-
-	if !found {
+	if !h.allowedRedirect(location) {
 		h.logger.Warn().Err(ErrInvalidRedirect).Msgf("attempted redirect to '%s'", location)
 		h.callbacks.OnError(w, ErrInvalidRedirect)
 		return
@@ -159,6 +161,15 @@ func (h *Handler) handleBegin(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, h.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce), http.StatusFound)
 }
 
+func (h *Handler) allowedRedirect(location string) bool {
+	for _, whitelisted := range h.allowedRedirects {
+		if whitelisted == location {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *Handler) handleEnd(w http.ResponseWriter, r *http.Request) {
 	disableCaching(w)
 
@@ -197,12 +208,50 @@ func (h *Handler) handleEnd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, rawToken, err := h.getClaimsAndCacheToken(r.Context(), token)
+	claims, rawToken, err := h.getClaims(token)
 	if err != nil {
 		h.callbacks.OnInvalidToken(w, err)
 		return
 	}
 
+	if h.onLogin != nil {
+		decision, err := h.onLogin(r.Context(), &LoginEvent{
+			Request:   r,
+			Claims:    claims,
+			Provider:  ProviderGoogle,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+			Location:  location,
+		})
+		if err != nil {
+			h.logger.Error().Err(err).Msg(MessageLoginHookFailed)
+			h.callbacks.OnError(w, errors.Wrap(err, MessageLoginHookFailed))
+			return
+		}
+		if decision.Denied {
+			h.logger.Info().Str("subject", claims.Subject).Str("reason", decision.Reason).Msg("login denied")
+			if denied, ok := h.callbacks.(DeniedCallbacks); ok {
+				denied.OnDenied(w, decision.Reason)
+			} else {
+				h.callbacks.OnError(w, ErrLoginDenied)
+			}
+			return
+		}
+		if decision.Location != "" {
+			if !h.allowedRedirect(decision.Location) {
+				h.logger.Warn().Err(ErrInvalidRedirect).Msgf("login hook attempted redirect to '%s'", decision.Location)
+				h.callbacks.OnError(w, ErrInvalidRedirect)
+				return
+			}
+			location = decision.Location
+		}
+	}
+
+	// the refresh token is only kept for logins that were allowed
+	if err := h.cacheToken(r.Context(), claims.Subject, token); err != nil {
+		h.callbacks.OnInvalidToken(w, err)
+		return
+	}
+
 	h.callbacks.OnSuccess(w, location, rawToken, claims)
 }
 
@@ -235,7 +284,7 @@ func (h *Handler) AuthenticationMiddleware(requireAuth bool, unauthorizedHandler
 			}
 
 			expiration := time.Unix(tokenClaims.ExpiresAt, 0)
-			if time.Until(expiration) < 10*time.Minute {
+			if expiration.Sub(h.now()) < 10*time.Minute {
 				// refresh the token, if anything apart from our hook
 				// fails, then just don't do anything until the next request
 				serialized, err := h.tokenManager.Get(r.Context(), tokenClaims.Subject)
@@ -290,6 +339,14 @@ func (h *Handler) Claims(ctx context.Context) *verifier.StandardClaims {
 	return claims.(*verifier.StandardClaims)
 }
 
+// Close closes the token manager if it holds any resources
+func (h *Handler) Close() error {
+	if closer, ok := h.tokenManager.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // MustClaims panics if no claims exist on the context
 func (h *Handler) MustClaims(ctx context.Context) *verifier.StandardClaims {
 	claims := ctx.Value(contextKey)
@@ -301,6 +358,17 @@ func (h *Handler) MustClaims(ctx context.Context) *verifier.StandardClaims {
 
 // any errors here are going to result in an ErrInvalidToken above
 func (h *Handler) getClaimsAndCacheToken(ctx context.Context, token *oauth2.Token) (*verifier.StandardClaims, string, error) {
+	claims, idToken, err := h.getClaims(token)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := h.cacheToken(ctx, claims.Subject, token); err != nil {
+		return nil, "", err
+	}
+	return claims, idToken, nil
+}
+
+func (h *Handler) getClaims(token *oauth2.Token) (*verifier.StandardClaims, string, error) {
 	if !token.Valid() {
 		h.logger.Warn().Err(ErrInvalidToken).Msg("token failed validation")
 		return nil, "", ErrInvalidToken
@@ -322,18 +390,21 @@ func (h *Handler) getClaimsAndCacheToken(ctx context.Context, token *oauth2.Toke
 		h.logger.Warn().Err(err).Msg("token verification failed")
 		return nil, "", err
 	}
+	return tokenClaims, idToken, nil
+}
 
+func (h *Handler) cacheToken(ctx context.Context, subject string, token *oauth2.Token) error {
 	// use the token manager to store the token serialized as JSON
 	serialized, err := json.Marshal(token)
 	if err != nil {
 		h.logger.Warn().Err(err).Msg("json marshaling failed")
-		return nil, "", err
+		return err
 	}
-	if err := h.tokenManager.Set(ctx, tokenClaims.Subject, string(serialized)); err != nil {
+	if err := h.tokenManager.Set(ctx, subject, string(serialized)); err != nil {
 		h.logger.Warn().Err(err).Msg("failed to write token to manager")
-		return nil, "", err
+		return err
 	}
-	return tokenClaims, idToken, nil
+	return nil
 }
 
 type stateClaims struct {
@@ -344,7 +415,7 @@ type stateClaims struct {
 func (h *Handler) generateState(location string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, stateClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(1 * time.Minute).Unix(),
+			ExpiresAt: h.now().Add(1 * time.Minute).Unix(),
 		},
 		Location: location,
 	})
@@ -353,7 +424,9 @@ func (h *Handler) generateState(location string) (string, error) {
 
 func (h *Handler) validateState(token string) (string, error) {
 	claims := &stateClaims{}
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+	// expiration is checked against the handler's clock below
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			h.logger.Warn().Msg("wrong signing method for token")
 			return nil, ErrInvalidStateValue
@@ -363,5 +436,8 @@ func (h *Handler) validateState(token string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if !claims.VerifyExpiresAt(h.now().Unix(), true) {
+		return "", ErrInvalidStateValue
+	}
 	return claims.Location, nil
 }
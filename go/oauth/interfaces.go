@@ -1,6 +1,10 @@
 package oauth
 
-import "context"
+import (
+	"context"
+
+	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+)
 
 // TokenManager maintains state
 // for storing tokens
@@ -8,3 +12,9 @@ type TokenManager interface {
 	Set(ctx context.Context, subject, token string) error
 	Get(ctx context.Context, subject string) (string, error)
 }
+
+// Verifier verifies an id token and
+// fills in its claims
+type Verifier interface {
+	VerifyIDToken(token string, claims verifier.GoogleClaims) error
+}
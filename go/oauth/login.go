@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+)
+
+// ProviderGoogle is the provider of logins through the google oauth flow
+const ProviderGoogle = "google"
+
+// LoginEvent describes a login at the end of an oauth flow
+type LoginEvent struct {
+	// Request is the provider's callback request, it can be used for the
+	// user's ip address or user agent
+	Request  *http.Request
+	Claims   *verifier.StandardClaims
+	Provider string
+	// ExpiresAt is when the id token expires
+	ExpiresAt time.Time
+	// Location is where the user is sent once they're logged in
+	Location string
+}
+
+// LoginDecision is the outcome of a login hook, the zero value allows the login
+type LoginDecision struct {
+	// Denied rejects the login, Reason is shown to the user
+	Denied bool
+	Reason string
+	// Location sends the user somewhere other than where they were going
+	Location string
+}
+
+// Allow allows the login
+func Allow() LoginDecision {
+	return LoginDecision{}
+}
+
+// Deny rejects the login with a reason shown to the user
+func Deny(reason string) LoginDecision {
+	return LoginDecision{Denied: true, Reason: reason}
+}
+
+// Redirect allows the login and sends the user to the location
+func Redirect(location string) LoginDecision {
+	return LoginDecision{Location: location}
+}
+
+// LoginHook decides whether to allow a login, it's called with the callback
+// request's context so it's canceled along with the request
+type LoginHook func(ctx context.Context, event *LoginEvent) (LoginDecision, error)
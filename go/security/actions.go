@@ -0,0 +1,119 @@
+package security
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// DefaultActions is the vocabulary every authorizer starts with,
+	// applications can extend it with RegisterActions
+	DefaultActions = []Action{ActionCreate, ActionRead, ActionUpdate, ActionDelete, ActionList}
+
+	// ErrInvalidAction occurs when an action can't be registered
+	ErrInvalidAction = errors.New("invalid action")
+	// ErrUnknownAction occurs when a role references an action
+	// that isn't in the action catalog
+	ErrUnknownAction = errors.New("unknown action")
+)
+
+// actionSeparator separates the namespaces of hierarchical
+// actions such as "billing:invoice:read"
+const actionSeparator = ":"
+
+// Matches determines whether the action, used as a pattern, matches the
+// given action. A "*" segment matches any single segment, or everything
+// that remains when it's the last segment, so "billing:*" matches
+// "billing:invoice:read"
+func (a Action) Matches(action Action) bool {
+	if a == ActionAll || a == action {
+		return true
+	}
+	pattern, value := a.String(), action.String()
+	for {
+		patternPart, patternRest, patternMore := cutAction(pattern)
+		if value == "" {
+			return false
+		}
+		part, rest, more := cutAction(value)
+		if patternPart == "*" {
+			if !patternMore {
+				return true
+			}
+		} else if patternPart != part {
+			return false
+		}
+		if !patternMore || !more {
+			return patternMore == more
+		}
+		pattern, value = patternRest, rest
+	}
+}
+
+func cutAction(action string) (part, rest string, more bool) {
+	if i := strings.Index(action, actionSeparator); i >= 0 {
+		return action[:i], action[i+len(actionSeparator):], true
+	}
+	return action, "", false
+}
+
+func validateActionName(action Action) error {
+	for _, part := range strings.Split(action.String(), actionSeparator) {
+		if part == "" || strings.Contains(part, "*") {
+			return errors.Wrapf(ErrInvalidAction, "%q", action)
+		}
+	}
+	return nil
+}
+
+func (m *roleManager) registerActions(actions ...Action) error {
+	for _, action := range actions {
+		if err := validateActionName(action); err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	for _, action := range actions {
+		m.actions[action] = struct{}{}
+	}
+	m.mutex.Unlock()
+	return nil
+}
+
+// knownAction checks that the action is in the catalog, or in the case
+// of wildcards that it matches at least one action in the catalog, it
+// must be called with the mutex held
+func (m *roleManager) knownAction(action Action) bool {
+	if action == ActionAll {
+		return true
+	}
+	if _, ok := m.actions[action]; ok {
+		return true
+	}
+	if !strings.Contains(action.String(), "*") {
+		return false
+	}
+	for registered := range m.actions {
+		if action.Matches(registered) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *roleManager) getActions() []Action {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	actions := make([]Action, 0, len(m.actions))
+	for action := range m.actions {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i] < actions[j]
+	})
+	return actions
+}
@@ -0,0 +1,83 @@
+package security
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestActionMatches(t *testing.T) {
+	tests := []struct {
+		pattern Action
+		action  Action
+		want    bool
+	}{
+		{ActionAll, ActionRead, true},
+		{ActionAll, "billing:invoice:read", true},
+		{ActionRead, ActionRead, true},
+		{ActionRead, ActionList, false},
+		{"billing:*", "billing:invoice:read", true},
+		{"billing:*", "billing:invoice", true},
+		{"billing:*", "billing", false},
+		{"billing:*", "shipping:invoice", false},
+		{"billing:*:read", "billing:invoice:read", true},
+		{"billing:*:read", "billing:invoice:write", false},
+		{"billing:*:read", "billing:invoice:line:read", false},
+		{"billing:invoice", "billing:invoice:read", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern.String()+"-"+tt.action.String(), func(t *testing.T) {
+			if have := tt.pattern.Matches(tt.action); have != tt.want {
+				t.Errorf("Matches() = %v, want %v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+
+	err := authorizer.Register(Role{"billing", []Policy{{Resource: ResourceAll, Action: "billing:invoice:read"}}})
+	if errors.Cause(err) != ErrUnknownAction {
+		t.Errorf("Register() = %v, want %v", err, ErrUnknownAction)
+	}
+	err = authorizer.Register(Role{"billing", []Policy{{Resource: ResourceAll, Action: "billing:*"}}})
+	if errors.Cause(err) != ErrUnknownAction {
+		t.Errorf("Register() = %v, want %v", err, ErrUnknownAction)
+	}
+	err = authorizer.Register(Role{"reader", []Policy{{Resource: "projects/:", Action: ActionRead}}})
+	if errors.Cause(err) != ErrInvalidPattern {
+		t.Errorf("Register() = %v, want %v", err, ErrInvalidPattern)
+	}
+	if err := authorizer.RegisterActions("billing:*"); errors.Cause(err) != ErrInvalidAction {
+		t.Errorf("RegisterActions() = %v, want %v", err, ErrInvalidAction)
+	}
+
+	if err := authorizer.RegisterActions("billing:invoice:read", "billing:invoice:void"); err != nil {
+		t.Fatal(err)
+	}
+	role := Role{"billing", []Policy{{Resource: ResourceAll, Action: "billing:*"}}}
+	if err := authorizer.Register(role); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	user := uuid.NewV4()
+	authorizer.SetRole(ctx, role, user)
+	allowed, err := authorizer.WithUser(user).Can(ctx, "billing:invoice:void", Resource("invoices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected the wildcard action to be allowed")
+	}
+	allowed, err = authorizer.WithUser(user).Can(ctx, ActionDelete, Resource("invoices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("expected actions outside of the wildcard to be denied")
+	}
+}
@@ -3,67 +3,149 @@ package security
 import (
 	"context"
 	"sync"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 )
 
 var (
-	once                   sync.Once
-	globalNamespaceManager NamespaceManager
-	globalRoleManager      *roleManager
-	globalNamespace        = uuid.UUID{}
+	once              sync.Once
+	defaultAuthorizer = NewAuthorizer(nil)
+	globalNamespace   = uuid.UUID{}
 )
 
-func init() {
-	globalRoleManager = newRoleManager()
+// Default returns the authorizer that all of the package
+// level functions delegate to
+func Default() *Authorizer {
+	return defaultAuthorizer
 }
 
-// RegisterManager sets the global namespace manager
+// RegisterManager sets the namespace manager of the default authorizer
 func RegisterManager(namespaceManager NamespaceManager) {
 	once.Do(func() {
-		globalNamespaceManager = namespaceManager
+		defaultAuthorizer.namespaceManager = namespaceManager
 	})
 }
 
-// Register adds roles to the internal role manager
-func Register(roles ...Role) {
-	globalRoleManager.register(roles...)
+// Register adds roles to the default authorizer's role registry, see
+// Authorizer.Register for when it fails, its error has to be checked
+func Register(roles ...Role) error {
+	return defaultAuthorizer.Register(roles...)
+}
+
+// MustRegister adds roles to the default authorizer's role registry
+// and panics if any of them fail validation
+func MustRegister(roles ...Role) {
+	defaultAuthorizer.MustRegister(roles...)
+}
+
+// RegisterActions extends the default authorizer's action catalog
+func RegisterActions(actions ...Action) error {
+	return defaultAuthorizer.RegisterActions(actions...)
+}
+
+// RegisterCondition adds a named condition to the default authorizer
+// that policies can reference
+func RegisterCondition(name string, condition Condition) {
+	defaultAuthorizer.RegisterCondition(name, condition)
 }
 
 // WithUser initializes a policy evaluation engine for the
 // global namespace
 func WithUser(user uuid.UUID) *Evaluator {
-	return WithNamespaceAndUser(globalNamespace, user)
+	return defaultAuthorizer.WithUser(user)
 }
 
 // WithNamespaceAndUser initializes a policy evaluation engine for the
 // given namespace
 func WithNamespaceAndUser(namespace, user uuid.UUID) *Evaluator {
-	return newEvaluator(globalNamespaceManager, namespace, user)
+	return defaultAuthorizer.WithNamespaceAndUser(namespace, user)
 }
 
 // SetRole sets the role of a user in the global namespace
 func SetRole(ctx context.Context, role Role, user uuid.UUID) error {
-	return AddUserToNamespace(ctx, role, globalNamespace, user)
+	return defaultAuthorizer.SetRole(ctx, role, user)
 }
 
 // UnsetRole removes the role of a user in the global namespace
 func UnsetRole(ctx context.Context, user uuid.UUID) error {
-	return RemoveUserFromNamespace(ctx, globalNamespace, user)
+	return defaultAuthorizer.UnsetRole(ctx, user)
 }
 
 // AddUserToNamespace sets the role of a user in the given namespace
 func AddUserToNamespace(ctx context.Context, role Role, id, user uuid.UUID) error {
-	if globalNamespaceManager != nil {
-		return globalNamespaceManager.AddUserToNamespace(ctx, role, id, user)
-	}
-	return nil
+	return defaultAuthorizer.AddUserToNamespace(ctx, role, id, user)
 }
 
 // RemoveUserFromNamespace removes the role of a user in the given namespace
 func RemoveUserFromNamespace(ctx context.Context, id, user uuid.UUID) error {
-	if globalNamespaceManager != nil {
-		return globalNamespaceManager.RemoveUserFromNamespace(ctx, id, user)
-	}
-	return nil
+	return defaultAuthorizer.RemoveUserFromNamespace(ctx, id, user)
+}
+
+// CreateGroup creates a group or renames it if it already exists
+func CreateGroup(ctx context.Context, group Group) error {
+	return defaultAuthorizer.CreateGroup(ctx, group)
+}
+
+// DeleteGroup removes a group along with its members and roles
+func DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	return defaultAuthorizer.DeleteGroup(ctx, id)
+}
+
+// GroupsFor returns all of the groups a user is a member of
+func GroupsFor(ctx context.Context, user uuid.UUID) ([]Group, error) {
+	return defaultAuthorizer.GroupsFor(ctx, user)
+}
+
+// AddUserToGroup adds a user as a member of a group
+func AddUserToGroup(ctx context.Context, group, user uuid.UUID) error {
+	return defaultAuthorizer.AddUserToGroup(ctx, group, user)
+}
+
+// RemoveUserFromGroup removes a user from a group
+func RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error {
+	return defaultAuthorizer.RemoveUserFromGroup(ctx, group, user)
+}
+
+// SetGroupRole sets the role of a group in the global namespace
+func SetGroupRole(ctx context.Context, role Role, group uuid.UUID) error {
+	return defaultAuthorizer.SetGroupRole(ctx, role, group)
+}
+
+// UnsetGroupRole removes the role of a group in the global namespace
+func UnsetGroupRole(ctx context.Context, group uuid.UUID) error {
+	return defaultAuthorizer.UnsetGroupRole(ctx, group)
+}
+
+// AddGroupToNamespace sets the role of a group in the given namespace
+func AddGroupToNamespace(ctx context.Context, role Role, id, group uuid.UUID) error {
+	return defaultAuthorizer.AddGroupToNamespace(ctx, role, id, group)
+}
+
+// RemoveGroupFromNamespace removes the role of a group in the given namespace
+func RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error {
+	return defaultAuthorizer.RemoveGroupFromNamespace(ctx, id, group)
+}
+
+// AddGrant grants a role to a user in the given namespace on top of their
+// membership, a nil expiresAt grants the role until it's removed
+func AddGrant(ctx context.Context, role Role, id, user uuid.UUID, expiresAt *time.Time, reason string) error {
+	return defaultAuthorizer.AddGrant(ctx, role, id, user, expiresAt, reason)
+}
+
+// RemoveGrant removes the grant of a role to a user in the given namespace
+func RemoveGrant(ctx context.Context, role Role, id, user uuid.UUID) error {
+	return defaultAuthorizer.RemoveGrant(ctx, role, id, user)
+}
+
+// WhoCan returns the memberships in the given namespace and in the global namespace
+// whose role permits the action on the resource
+func WhoCan(ctx context.Context, namespace uuid.UUID, action Action, resource Resource) ([]Membership, error) {
+	return defaultAuthorizer.WhoCan(ctx, namespace, action, resource)
+}
+
+// ManifestFor compiles the user's effective policies across every namespace into a
+// permission manifest
+func ManifestFor(ctx context.Context, user uuid.UUID) (*Manifest, error) {
+	return defaultAuthorizer.Manifest(ctx, user)
 }
@@ -0,0 +1,298 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	actorKey = "actor-context-key"
+
+	// ErrNoActor is returned by ActorFromRequest when no actor was set, handlers
+	// treat it like a nil user and respond with a 401
+	ErrNoActor = errors.New("no actor was set on the request")
+	// ErrAuditHistoryUnsupported occurs when querying the history of an
+	// authorizer whose audit sink can't be queried
+	ErrAuditHistoryUnsupported = errors.New("audit sink does not support history queries")
+)
+
+type actor struct {
+	id   uuid.UUID
+	kind PrincipalKind
+}
+
+// WithActor returns a context that records the given user as the
+// actor behind any role changes or decisions made with it
+func WithActor(ctx context.Context, id uuid.UUID) context.Context {
+	return WithActorKind(ctx, id, PrincipalUser)
+}
+
+// WithActorKind is WithActor for actors that aren't users, e.g. service accounts
+func WithActorKind(ctx context.Context, id uuid.UUID, kind PrincipalKind) context.Context {
+	return context.WithValue(ctx, &actorKey, actor{id: id, kind: kind})
+}
+
+// ActorFromRequest returns the actor set on the request's context with
+// WithActor, it returns ErrNoActor if there isn't one
+func ActorFromRequest(r *http.Request) (uuid.UUID, error) {
+	actor, ok := ActorFrom(r.Context())
+	if !ok {
+		return uuid.UUID{}, ErrNoActor
+	}
+	return actor, nil
+}
+
+// ActorFrom returns the actor set on the context with WithActor
+func ActorFrom(ctx context.Context) (uuid.UUID, bool) {
+	value, ok := ctx.Value(&actorKey).(actor)
+	return value.id, ok
+}
+
+// ActorKindFrom returns the kind of the actor set on the context, it's
+// PrincipalUser when no actor was set
+func ActorKindFrom(ctx context.Context) PrincipalKind {
+	value, ok := ctx.Value(&actorKey).(actor)
+	if !ok || value.kind == "" {
+		return PrincipalUser
+	}
+	return value.kind
+}
+
+// AuditEventKind is the type of an audit event
+type AuditEventKind string
+
+const (
+	// AuditGrant is recorded when a role is granted to a principal
+	AuditGrant = AuditEventKind("grant")
+	// AuditRevoke is recorded when a role is revoked from a principal
+	AuditRevoke = AuditEventKind("revoke")
+	// AuditDecision is recorded when a permission check is evaluated
+	AuditDecision = AuditEventKind("decision")
+	// AuditImpersonationStart is recorded when a user starts impersonating another user
+	AuditImpersonationStart = AuditEventKind("impersonation_start")
+	// AuditImpersonationStop is recorded when a user stops impersonating another user
+	AuditImpersonationStop = AuditEventKind("impersonation_stop")
+	// AuditGroupJoin is recorded when a user is added to a group
+	AuditGroupJoin = AuditEventKind("group_join")
+	// AuditGroupLeave is recorded when a user is removed from a group
+	AuditGroupLeave = AuditEventKind("group_leave")
+)
+
+// AuditEvent is a single entry in the audit log
+type AuditEvent struct {
+	Kind AuditEventKind `json:"kind" db:"kind"`
+	// Actor is the user that made the change or the check, it's
+	// empty when no actor was set on the context
+	Actor       uuid.UUID     `json:"actor" db:"actor_id"`
+	Subject     uuid.UUID     `json:"subject" db:"subject_id"`
+	SubjectKind PrincipalKind `json:"subjectKind" db:"subject_kind"`
+	Namespace   uuid.UUID     `json:"namespace" db:"namespace_id"`
+	// Group is set for group membership changes
+	Group uuid.UUID `json:"group" db:"group_id"`
+	// Role is set for grants and revocations
+	Role string `json:"role,omitempty" db:"role"`
+	// Action, Resource, Allowed and Policy are set for decisions
+	Action   Action   `json:"action,omitempty" db:"action"`
+	Resource Resource `json:"resource,omitempty" db:"resource"`
+	Allowed  bool     `json:"allowed" db:"allowed"`
+	Policy   string   `json:"policy,omitempty" db:"policy"`
+
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// AuditQuery filters the history returned from an audit log
+type AuditQuery struct {
+	// Actor limits the history to events caused by the given user
+	Actor *uuid.UUID
+	// Subject limits the history to events about the given principal
+	Subject *uuid.UUID
+	// Limit is the maximum number of events to return, defaults to 100
+	Limit int
+}
+
+// AuditSink receives audit events
+type AuditSink interface {
+	// Record persists an audit event, sinks backed by a database
+	// should write in the transaction on the context so that changes
+	// that get rolled back aren't recorded
+	Record(ctx context.Context, event *AuditEvent) error
+}
+
+// AuditHistory is an audit sink that can also be queried
+type AuditHistory interface {
+	AuditSink
+
+	// History returns events matching the query, newest first
+	History(ctx context.Context, query AuditQuery) ([]AuditEvent, error)
+}
+
+// AuditOptions controls which decisions get recorded, grants and
+// revocations are always recorded
+type AuditOptions struct {
+	// SampleRate is the fraction of decisions that get recorded,
+	// from 0 (none) to 1 (all)
+	SampleRate float64
+	// Denied records every denied decision regardless of the sample rate
+	Denied bool
+}
+
+type auditor struct {
+	sink    AuditSink
+	options AuditOptions
+
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+func (a *auditor) sample() bool {
+	if a.options.SampleRate <= 0 {
+		return false
+	}
+	if a.options.SampleRate >= 1 {
+		return true
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.random.Float64() < a.options.SampleRate
+}
+
+// WithAudit sets the sink that role changes and decisions are recorded to
+func (a *Authorizer) WithAudit(sink AuditSink, options AuditOptions) *Authorizer {
+	a.auditor = &auditor{
+		sink:    sink,
+		options: options,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	return a
+}
+
+// History queries the authorizer's audit sink
+func (a *Authorizer) History(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	if a.auditor == nil {
+		return nil, ErrAuditHistoryUnsupported
+	}
+	history, ok := a.auditor.sink.(AuditHistory)
+	if !ok {
+		return nil, ErrAuditHistoryUnsupported
+	}
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	return history.History(ctx, query)
+}
+
+// Audit records an event of the given kind about the subject, it's meant for
+// features built on the authorizer, the actor is taken from the context
+func (a *Authorizer) Audit(ctx context.Context, kind AuditEventKind, subjectKind PrincipalKind, namespace, subject uuid.UUID) error {
+	return a.auditChange(ctx, kind, subjectKind, namespace, subject, "")
+}
+
+func (a *Authorizer) auditMembership(ctx context.Context, kind AuditEventKind, group, principal uuid.UUID, principalKind PrincipalKind) error {
+	if a.auditor == nil {
+		return nil
+	}
+	actor, _ := ActorFrom(ctx)
+	return a.auditor.sink.Record(ctx, &AuditEvent{
+		Kind:        kind,
+		Actor:       actor,
+		Subject:     principal,
+		SubjectKind: principalKind,
+		Group:       group,
+		CreatedAt:   time.Now(),
+	})
+}
+
+func (a *Authorizer) auditChange(ctx context.Context, kind AuditEventKind, subjectKind PrincipalKind, namespace, subject uuid.UUID, role string) error {
+	if a.auditor == nil {
+		return nil
+	}
+	actor, _ := ActorFrom(ctx)
+	return a.auditor.sink.Record(ctx, &AuditEvent{
+		Kind:        kind,
+		Actor:       actor,
+		Subject:     subject,
+		SubjectKind: subjectKind,
+		Namespace:   namespace,
+		Role:        role,
+		CreatedAt:   time.Now(),
+	})
+}
+
+func (a *Authorizer) auditDecision(ctx context.Context, e *Evaluator, action Action, resource Resource, policy *Policy) error {
+	if a.auditor == nil {
+		return nil
+	}
+	allowed := policy != nil
+	record := !allowed && a.auditor.options.Denied
+	if !record && !a.auditor.sample() {
+		return nil
+	}
+	actor, ok := ActorFrom(ctx)
+	if !ok {
+		actor = e.user
+	}
+	subjectKind := e.kind
+	if subjectKind == "" {
+		// evaluators for the actor are for whatever kind of principal it is
+		subjectKind = PrincipalUser
+		if uuid.Equal(actor, e.user) {
+			subjectKind = ActorKindFrom(ctx)
+		}
+	}
+	event := &AuditEvent{
+		Kind:        AuditDecision,
+		Actor:       actor,
+		Subject:     e.user,
+		SubjectKind: subjectKind,
+		Namespace:   e.namespace,
+		Action:      action,
+		Resource:    resource,
+		Allowed:     allowed,
+		CreatedAt:   time.Now(),
+	}
+	if allowed {
+		event.Policy = policy.String()
+	}
+	return a.auditor.sink.Record(ctx, event)
+}
+
+// LogAuditSink writes audit events to a zerolog logger
+type LogAuditSink struct {
+	logger zerolog.Logger
+}
+
+// NewLogAuditSink creates an audit sink that logs events
+func NewLogAuditSink(logger zerolog.Logger) *LogAuditSink {
+	return &LogAuditSink{
+		logger: logger,
+	}
+}
+
+// Record logs the audit event
+func (s *LogAuditSink) Record(ctx context.Context, event *AuditEvent) error {
+	fields := s.logger.Info().
+		Str("system", "audit").
+		Str("audit.kind", string(event.Kind)).
+		Str("audit.actor", event.Actor.String()).
+		Str("audit.subject", event.Subject.String()).
+		Str("audit.subject_kind", string(event.SubjectKind)).
+		Str("audit.namespace", event.Namespace.String())
+	if event.Kind == AuditDecision {
+		fields = fields.
+			Str("audit.action", event.Action.String()).
+			Str("audit.resource", event.Resource.String()).
+			Bool("audit.allowed", event.Allowed).
+			Str("audit.policy", event.Policy)
+	} else {
+		fields = fields.Str("audit.role", event.Role)
+	}
+	fields.Time("audit.time", event.CreatedAt).Msg("security audit event")
+	return nil
+}
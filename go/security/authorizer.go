@@ -0,0 +1,235 @@
+package security
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ErrNoNamespaceManager is returned when changing memberships through an
+// authorizer that has no namespace manager, e.g. the default authorizer
+// before RegisterManager is called
+var ErrNoNamespaceManager = errors.New("authorizer does not have a namespace manager")
+
+// Authorizer holds its own role registry and namespace
+// manager, multiple authorizers can live side by side
+// in the same process
+type Authorizer struct {
+	namespaceManager NamespaceManager
+	roleManager      *roleManager
+	auditor          *auditor
+	elevations       *elevations
+	// simulated authorizers skip the request cache
+	simulated bool
+}
+
+// NewAuthorizer creates an authorizer that stores role
+// memberships through the given namespace manager
+func NewAuthorizer(namespaceManager NamespaceManager) *Authorizer {
+	return &Authorizer{
+		namespaceManager: namespaceManager,
+		roleManager:      newRoleManager(),
+	}
+}
+
+// Register adds roles to the authorizer's role registry, it fails if
+// a role references an action that isn't in the action catalog or
+// a resource pattern that can't be compiled, in which case none of
+// the roles are registered. Registration used to always succeed so
+// callers that ignore the error silently lose roles, they should check
+// it or use MustRegister
+func (a *Authorizer) Register(roles ...Role) error {
+	return a.roleManager.register(roles...)
+}
+
+// MustRegister adds roles to the authorizer's role registry and
+// panics if any of them fail validation
+func (a *Authorizer) MustRegister(roles ...Role) {
+	if err := a.Register(roles...); err != nil {
+		panic(err)
+	}
+}
+
+// Role returns the registered role with the given name
+func (a *Authorizer) Role(name string) (Role, bool) {
+	return a.roleManager.getRole(name)
+}
+
+// Roles returns every role registered with the authorizer
+func (a *Authorizer) Roles() []Role {
+	return a.roleManager.getRoles()
+}
+
+// RegisterActions extends the authorizer's action catalog, actions must
+// be registered before any roles that reference them
+func (a *Authorizer) RegisterActions(actions ...Action) error {
+	return a.roleManager.registerActions(actions...)
+}
+
+// Actions returns every action in the authorizer's action catalog
+func (a *Authorizer) Actions() []Action {
+	return a.roleManager.getActions()
+}
+
+// RegisterCondition adds a named condition that policies can reference
+func (a *Authorizer) RegisterCondition(name string, condition Condition) {
+	a.roleManager.registerCondition(name, condition)
+}
+
+// WithUser initializes a policy evaluation engine for the
+// global namespace
+func (a *Authorizer) WithUser(user uuid.UUID) *Evaluator {
+	return a.WithNamespaceAndUser(globalNamespace, user)
+}
+
+// WithNamespaceAndUser initializes a policy evaluation engine for the
+// given namespace
+func (a *Authorizer) WithNamespaceAndUser(namespace, user uuid.UUID) *Evaluator {
+	return newEvaluator(a, namespace, user)
+}
+
+// SetRole sets the role of a user in the global namespace
+func (a *Authorizer) SetRole(ctx context.Context, role Role, user uuid.UUID) error {
+	return a.AddUserToNamespace(ctx, role, globalNamespace, user)
+}
+
+// UnsetRole removes the role of a user in the global namespace
+func (a *Authorizer) UnsetRole(ctx context.Context, user uuid.UUID) error {
+	return a.RemoveUserFromNamespace(ctx, globalNamespace, user)
+}
+
+// AddUserToNamespace sets the role of a user in the given namespace
+func (a *Authorizer) AddUserToNamespace(ctx context.Context, role Role, id, user uuid.UUID) error {
+	a.invalidateUser(ctx, user)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.AddUserToNamespace(ctx, role, id, user); err != nil {
+		return err
+	}
+	return a.auditChange(ctx, AuditGrant, PrincipalUser, id, user, role.Name)
+}
+
+// RemoveUserFromNamespace removes the role of a user in the given namespace
+func (a *Authorizer) RemoveUserFromNamespace(ctx context.Context, id, user uuid.UUID) error {
+	a.invalidateUser(ctx, user)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.RemoveUserFromNamespace(ctx, id, user); err != nil {
+		return err
+	}
+	return a.auditChange(ctx, AuditRevoke, PrincipalUser, id, user, "")
+}
+
+// CreateGroup creates a group or renames it if it already exists
+func (a *Authorizer) CreateGroup(ctx context.Context, group Group) error {
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	return a.namespaceManager.CreateGroup(ctx, group)
+}
+
+// DeleteGroup removes a group along with its members and roles
+func (a *Authorizer) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	a.invalidateAll(ctx)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	return a.namespaceManager.DeleteGroup(ctx, id)
+}
+
+// GroupsFor returns all of the groups a user is a member of
+func (a *Authorizer) GroupsFor(ctx context.Context, user uuid.UUID) ([]Group, error) {
+	if a.namespaceManager != nil {
+		return a.namespaceManager.GroupsFor(ctx, user)
+	}
+	return []Group{}, nil
+}
+
+// AddUserToGroup adds a user as a member of a group
+func (a *Authorizer) AddUserToGroup(ctx context.Context, group, user uuid.UUID) error {
+	return a.AddPrincipalToGroup(ctx, group, user, PrincipalUser)
+}
+
+// AddPrincipalToGroup adds a principal that holds roles under its id, e.g.
+// a service account, as a member of a group
+func (a *Authorizer) AddPrincipalToGroup(ctx context.Context, group, principal uuid.UUID, kind PrincipalKind) error {
+	a.invalidateUser(ctx, principal)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.AddUserToGroup(ctx, group, principal); err != nil {
+		return err
+	}
+	return a.auditMembership(ctx, AuditGroupJoin, group, principal, kind)
+}
+
+// RemoveUserFromGroup removes a user from a group
+func (a *Authorizer) RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error {
+	return a.RemovePrincipalFromGroup(ctx, group, user, PrincipalUser)
+}
+
+// RemovePrincipalFromGroup removes a principal that holds roles under its id
+// from a group
+func (a *Authorizer) RemovePrincipalFromGroup(ctx context.Context, group, principal uuid.UUID, kind PrincipalKind) error {
+	a.invalidateUser(ctx, principal)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.RemoveUserFromGroup(ctx, group, principal); err != nil {
+		return err
+	}
+	return a.auditMembership(ctx, AuditGroupLeave, group, principal, kind)
+}
+
+// SetGroupRole sets the role of a group in the global namespace
+func (a *Authorizer) SetGroupRole(ctx context.Context, role Role, group uuid.UUID) error {
+	return a.AddGroupToNamespace(ctx, role, globalNamespace, group)
+}
+
+// UnsetGroupRole removes the role of a group in the global namespace
+func (a *Authorizer) UnsetGroupRole(ctx context.Context, group uuid.UUID) error {
+	return a.RemoveGroupFromNamespace(ctx, globalNamespace, group)
+}
+
+// AddGroupToNamespace sets the role of a group in the given namespace
+func (a *Authorizer) AddGroupToNamespace(ctx context.Context, role Role, id, group uuid.UUID) error {
+	a.invalidateAll(ctx)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.AddGroupToNamespace(ctx, role, id, group); err != nil {
+		return err
+	}
+	return a.auditChange(ctx, AuditGrant, PrincipalGroup, id, group, role.Name)
+}
+
+// RemoveGroupFromNamespace removes the role of a group in the given namespace
+func (a *Authorizer) RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error {
+	a.invalidateAll(ctx)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.RemoveGroupFromNamespace(ctx, id, group); err != nil {
+		return err
+	}
+	return a.auditChange(ctx, AuditRevoke, PrincipalGroup, id, group, "")
+}
+
+// MembersOf returns the memberships of every user and group that holds a role
+// directly in the given namespace, including any unexpired grants
+func (a *Authorizer) MembersOf(ctx context.Context, namespace uuid.UUID) ([]Membership, error) {
+	if a.namespaceManager != nil {
+		return a.namespaceManager.MembersOf(ctx, namespace)
+	}
+	return []Membership{}, nil
+}
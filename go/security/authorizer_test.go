@@ -0,0 +1,62 @@
+package security
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAuthorizerIsolation(t *testing.T) {
+	ctx := context.Background()
+	admin := Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	user := uuid.NewV4()
+
+	first := NewAuthorizer(newTestNamespaceManager())
+	second := NewAuthorizer(newTestNamespaceManager())
+	first.MustRegister(admin)
+	second.MustRegister(admin)
+
+	if err := first.SetRole(ctx, admin, user); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed, err := first.WithUser(user).Can(ctx, ActionRead, Resource("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected the first authorizer to allow access")
+	}
+
+	allowed, err = second.WithUser(user).Can(ctx, ActionRead, Resource("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("expected the second authorizer to deny access")
+	}
+}
+
+func TestAuthorizerWithoutManager(t *testing.T) {
+	ctx := context.Background()
+	admin := Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	authorizer := NewAuthorizer(nil)
+	authorizer.MustRegister(admin)
+
+	if err := authorizer.SetRole(ctx, admin, uuid.NewV4()); err != ErrNoNamespaceManager {
+		t.Errorf("SetRole() = %v, want %v", err, ErrNoNamespaceManager)
+	}
+	if err := authorizer.CreateGroup(ctx, Group{ID: uuid.NewV4(), Name: "group"}); err != ErrNoNamespaceManager {
+		t.Errorf("CreateGroup() = %v, want %v", err, ErrNoNamespaceManager)
+	}
+}
+
+func TestSweepExpiredGrantsWithoutOnError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// every sweep fails since there's no namespace manager
+	NewAuthorizer(nil).SweepExpiredGrants(ctx, time.Millisecond, nil)
+}
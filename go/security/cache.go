@@ -0,0 +1,369 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	roleCacheKey = "role-cache-context-key"
+)
+
+type roleCache struct {
+	mutex sync.Mutex
+	roles map[uuid.UUID]map[string][]NamespaceRole
+}
+
+func newRoleCache() *roleCache {
+	return &roleCache{
+		roles: make(map[uuid.UUID]map[string][]NamespaceRole),
+	}
+}
+
+func (c *roleCache) get(user uuid.UUID, key string) ([]NamespaceRole, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	roles, ok := c.roles[user][key]
+	return roles, ok
+}
+
+func (c *roleCache) set(user uuid.UUID, key string, roles []NamespaceRole) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.roles[user]; !ok {
+		c.roles[user] = make(map[string][]NamespaceRole)
+	}
+	c.roles[user][key] = roles
+}
+
+func (c *roleCache) invalidate(user uuid.UUID) {
+	c.mutex.Lock()
+	delete(c.roles, user)
+	c.mutex.Unlock()
+}
+
+func (c *roleCache) clear() {
+	c.mutex.Lock()
+	c.roles = make(map[uuid.UUID]map[string][]NamespaceRole)
+	c.mutex.Unlock()
+}
+
+// requestCaches holds a role cache per authorizer so that authorizers
+// with different namespace managers never see each other's roles
+type requestCaches struct {
+	mutex  sync.Mutex
+	caches map[*Authorizer]*roleCache
+}
+
+func (c *requestCaches) forAuthorizer(a *Authorizer) *roleCache {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cache, ok := c.caches[a]
+	if !ok {
+		cache = newRoleCache()
+		c.caches[a] = cache
+	}
+	return cache
+}
+
+func cachesFromContext(ctx context.Context) *requestCaches {
+	caches := ctx.Value(&roleCacheKey)
+	if caches == nil {
+		return nil
+	}
+	return caches.(*requestCaches)
+}
+
+// WithRoleCache returns a context that memoizes the roles fetched
+// by evaluators for as long as the context lives, it's meant to
+// wrap a single request
+func WithRoleCache(ctx context.Context) context.Context {
+	if cachesFromContext(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, &roleCacheKey, &requestCaches{
+		caches: make(map[*Authorizer]*roleCache),
+	})
+}
+
+// RoleCacheMiddleware is a middleware that memoizes the roles fetched
+// by evaluators for the life of the request
+func RoleCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.Clone(WithRoleCache(r.Context())))
+	})
+}
+
+func rolesForKey(namespace uuid.UUID) string {
+	return "roles|" + namespace.String()
+}
+
+func namespacesForKey() string {
+	return "namespaces"
+}
+
+// requestCache returns the request cache on the context, simulations
+// never read from or write to it
+func (a *Authorizer) requestCache(ctx context.Context) *roleCache {
+	if a.simulated {
+		return nil
+	}
+	caches := cachesFromContext(ctx)
+	if caches == nil {
+		return nil
+	}
+	return caches.forAuthorizer(a)
+}
+
+// rolesFor fetches the roles of a user in the given namespace, going through
+// the request cache if there is one on the context
+func (a *Authorizer) rolesFor(ctx context.Context, namespace, user uuid.UUID) ([]NamespaceRole, error) {
+	cache := a.requestCache(ctx)
+	if cache != nil {
+		if roles, ok := cache.get(user, rolesForKey(namespace)); ok {
+			return roles, nil
+		}
+	}
+	roles, err := a.namespaceManager.RolesFor(ctx, globalNamespace, namespace, user)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.set(user, rolesForKey(namespace), roles)
+	}
+	return roles, nil
+}
+
+// namespacesFor fetches the roles of a user across all namespaces, going through
+// the request cache if there is one on the context
+func (a *Authorizer) namespacesFor(ctx context.Context, user uuid.UUID) ([]NamespaceRole, error) {
+	cache := a.requestCache(ctx)
+	if cache != nil {
+		if roles, ok := cache.get(user, namespacesForKey()); ok {
+			return roles, nil
+		}
+	}
+	roles, err := a.namespaceManager.NamespacesFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache.set(user, namespacesForKey(), roles)
+	}
+	return roles, nil
+}
+
+// invalidateUser drops any roles of the user memoized in the request
+func (a *Authorizer) invalidateUser(ctx context.Context, user uuid.UUID) {
+	if cache := a.requestCache(ctx); cache != nil {
+		cache.invalidate(user)
+	}
+}
+
+// invalidateAll drops every role memoized in the request
+func (a *Authorizer) invalidateAll(ctx context.Context) {
+	if cache := a.requestCache(ctx); cache != nil {
+		cache.clear()
+	}
+}
+
+type cachedRoles struct {
+	roles   []NamespaceRole
+	expires time.Time
+}
+
+// CachingNamespaceManager wraps a NamespaceManager and caches role
+// lookups process-wide for a fixed TTL, any changes made through the
+// manager invalidate the cached roles they affect, a grant can keep
+// applying for up to the TTL after it expires. Managers backed by a
+// transactional store should be told about its transactions with
+// WithTransactions
+type CachingNamespaceManager struct {
+	NamespaceManager
+
+	ttl           time.Duration
+	now           func() time.Time
+	inTransaction func(ctx context.Context) bool
+	onCommit      func(ctx context.Context, fn func())
+	mutex         sync.Mutex
+	roles         map[uuid.UUID]map[string]cachedRoles
+}
+
+// NewCachingNamespaceManager creates a manager that caches the roles
+// returned by the given manager for the given TTL
+func NewCachingNamespaceManager(manager NamespaceManager, ttl time.Duration) *CachingNamespaceManager {
+	return &CachingNamespaceManager{
+		NamespaceManager: manager,
+		ttl:              ttl,
+		now:              time.Now,
+		inTransaction:    func(ctx context.Context) bool { return false },
+		onCommit:         func(ctx context.Context, fn func()) { fn() },
+		roles:            make(map[uuid.UUID]map[string]cachedRoles),
+	}
+}
+
+// WithTransactions tells the manager whether a context carries a transaction and
+// how to run a function once that transaction commits, e.g. sqlContext.InTransaction
+// and sqlContext.OnCommit. Lookups inside of a transaction bypass the cache since
+// they can see uncommitted rows, and changes made in one invalidate the cache again
+// after the commit so concurrent lookups can't cache the old roles in the meantime
+func (m *CachingNamespaceManager) WithTransactions(inTransaction func(ctx context.Context) bool, onCommit func(ctx context.Context, fn func())) *CachingNamespaceManager {
+	m.inTransaction = inTransaction
+	m.onCommit = onCommit
+	return m
+}
+
+func (m *CachingNamespaceManager) get(user uuid.UUID, key string) ([]NamespaceRole, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cached, ok := m.roles[user][key]
+	if !ok {
+		return nil, false
+	}
+	if !m.now().Before(cached.expires) {
+		delete(m.roles[user], key)
+		return nil, false
+	}
+	return cached.roles, true
+}
+
+func (m *CachingNamespaceManager) set(user uuid.UUID, key string, roles []NamespaceRole) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.roles[user]; !ok {
+		m.roles[user] = make(map[string]cachedRoles)
+	}
+	m.roles[user][key] = cachedRoles{roles, m.now().Add(m.ttl)}
+}
+
+// Invalidate drops all cached roles for the given user
+func (m *CachingNamespaceManager) Invalidate(user uuid.UUID) {
+	m.mutex.Lock()
+	delete(m.roles, user)
+	m.mutex.Unlock()
+}
+
+// Purge drops all cached roles
+func (m *CachingNamespaceManager) Purge() {
+	m.mutex.Lock()
+	m.roles = make(map[uuid.UUID]map[string]cachedRoles)
+	m.mutex.Unlock()
+}
+
+// invalidate drops the cached roles for the user once the change
+// made on the context is visible to other lookups
+func (m *CachingNamespaceManager) invalidate(ctx context.Context, user uuid.UUID) {
+	m.Invalidate(user)
+	m.onCommit(ctx, func() { m.Invalidate(user) })
+}
+
+// purge drops all cached roles once the change made on the
+// context is visible to other lookups
+func (m *CachingNamespaceManager) purge(ctx context.Context) {
+	m.Purge()
+	m.onCommit(ctx, m.Purge)
+}
+
+// AddUserToNamespace sets the role of a user in the given namespace
+func (m *CachingNamespaceManager) AddUserToNamespace(ctx context.Context, role Role, id, user uuid.UUID) error {
+	defer m.invalidate(ctx, user)
+	return m.NamespaceManager.AddUserToNamespace(ctx, role, id, user)
+}
+
+// RemoveUserFromNamespace removes the role of a user in the given namespace
+func (m *CachingNamespaceManager) RemoveUserFromNamespace(ctx context.Context, id, user uuid.UUID) error {
+	defer m.invalidate(ctx, user)
+	return m.NamespaceManager.RemoveUserFromNamespace(ctx, id, user)
+}
+
+// DeleteGroup removes a group along with its members and roles
+func (m *CachingNamespaceManager) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	defer m.purge(ctx)
+	return m.NamespaceManager.DeleteGroup(ctx, id)
+}
+
+// AddUserToGroup adds a user as a member of a group
+func (m *CachingNamespaceManager) AddUserToGroup(ctx context.Context, group, user uuid.UUID) error {
+	defer m.invalidate(ctx, user)
+	return m.NamespaceManager.AddUserToGroup(ctx, group, user)
+}
+
+// RemoveUserFromGroup removes a user from a group
+func (m *CachingNamespaceManager) RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error {
+	defer m.invalidate(ctx, user)
+	return m.NamespaceManager.RemoveUserFromGroup(ctx, group, user)
+}
+
+// AddGroupToNamespace sets the role of a group in the given namespace
+func (m *CachingNamespaceManager) AddGroupToNamespace(ctx context.Context, role Role, id, group uuid.UUID) error {
+	defer m.purge(ctx)
+	return m.NamespaceManager.AddGroupToNamespace(ctx, role, id, group)
+}
+
+// RemoveGroupFromNamespace removes the role of a group in the given namespace
+func (m *CachingNamespaceManager) RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error {
+	defer m.purge(ctx)
+	return m.NamespaceManager.RemoveGroupFromNamespace(ctx, id, group)
+}
+
+// AddGrant stores a grant, replacing any grant of the same role
+// to the user in the namespace
+func (m *CachingNamespaceManager) AddGrant(ctx context.Context, grant Grant) error {
+	defer m.invalidate(ctx, grant.User)
+	return m.NamespaceManager.AddGrant(ctx, grant)
+}
+
+// RemoveGrant removes the grant of a role to a user in the given namespace
+func (m *CachingNamespaceManager) RemoveGrant(ctx context.Context, id, user uuid.UUID, role string) error {
+	defer m.invalidate(ctx, user)
+	return m.NamespaceManager.RemoveGrant(ctx, id, user, role)
+}
+
+// PurgeExpiredGrants deletes every grant that expired by the given time
+// and returns the number of grants deleted
+func (m *CachingNamespaceManager) PurgeExpiredGrants(ctx context.Context, now time.Time) (int64, error) {
+	defer m.purge(ctx)
+	return m.NamespaceManager.PurgeExpiredGrants(ctx, now)
+}
+
+// RolesFor returns the cached roles for the user or fetches them from the wrapped manager
+func (m *CachingNamespaceManager) RolesFor(ctx context.Context, globalNamespace, namespace, user uuid.UUID) ([]NamespaceRole, error) {
+	if m.inTransaction(ctx) {
+		return m.NamespaceManager.RolesFor(ctx, globalNamespace, namespace, user)
+	}
+	key := globalNamespace.String() + "|" + rolesForKey(namespace)
+	if roles, ok := m.get(user, key); ok {
+		return roles, nil
+	}
+	roles, err := m.NamespaceManager.RolesFor(ctx, globalNamespace, namespace, user)
+	if err != nil {
+		return nil, err
+	}
+	m.set(user, key, roles)
+	return roles, nil
+}
+
+// NamespacesFor returns the cached roles for the user or fetches them from the wrapped manager
+func (m *CachingNamespaceManager) NamespacesFor(ctx context.Context, user uuid.UUID) ([]NamespaceRole, error) {
+	if m.inTransaction(ctx) {
+		return m.NamespaceManager.NamespacesFor(ctx, user)
+	}
+	if roles, ok := m.get(user, namespacesForKey()); ok {
+		return roles, nil
+	}
+	roles, err := m.NamespaceManager.NamespacesFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	m.set(user, namespacesForKey(), roles)
+	return roles, nil
+}
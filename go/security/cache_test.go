@@ -0,0 +1,152 @@
+package security
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var testTransactionKey = "test-transaction-context-key"
+
+// testTransaction stands in for a database transaction, it
+// holds the functions to run once it commits
+type testTransaction struct {
+	hooks []func()
+}
+
+func (t *testTransaction) commit() {
+	for _, hook := range t.hooks {
+		hook()
+	}
+}
+
+func testInTransaction(ctx context.Context) bool {
+	return ctx.Value(&testTransactionKey) != nil
+}
+
+func testOnCommit(ctx context.Context, fn func()) {
+	if tx, ok := ctx.Value(&testTransactionKey).(*testTransaction); ok {
+		tx.hooks = append(tx.hooks, fn)
+		return
+	}
+	fn()
+}
+
+type countingNamespaceManager struct {
+	*testNamespaceManager
+	calls int
+}
+
+func (m *countingNamespaceManager) RolesFor(ctx context.Context, globalNamespace, namespace, user uuid.UUID) ([]NamespaceRole, error) {
+	m.calls++
+	return m.testNamespaceManager.RolesFor(ctx, globalNamespace, namespace, user)
+}
+
+func TestRequestRoleCache(t *testing.T) {
+	manager := &countingNamespaceManager{testNamespaceManager: newTestNamespaceManager()}
+	admin := Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	authorizer := NewAuthorizer(manager)
+	authorizer.MustRegister(admin)
+	user := uuid.NewV4()
+
+	ctx := WithRoleCache(context.Background())
+	for i := 0; i < 3; i++ {
+		if allowed, _ := authorizer.WithUser(user).Can(ctx, ActionRead, Resource("foo")); allowed {
+			t.Fatal("expected access to be denied")
+		}
+	}
+	if manager.calls != 1 {
+		t.Errorf("RolesFor() called %d times, want 1", manager.calls)
+	}
+
+	// granting a role inside of the request drops the memoized roles
+	authorizer.SetRole(ctx, admin, user)
+	if allowed, _ := authorizer.WithUser(user).Can(ctx, ActionRead, Resource("foo")); !allowed {
+		t.Error("expected access to be allowed")
+	}
+	if manager.calls != 2 {
+		t.Errorf("RolesFor() called %d times, want 2", manager.calls)
+	}
+
+	// a new request starts with an empty cache
+	authorizer.WithUser(user).Can(WithRoleCache(context.Background()), ActionRead, Resource("foo"))
+	if manager.calls != 3 {
+		t.Errorf("RolesFor() called %d times, want 3", manager.calls)
+	}
+
+	// other authorizers sharing the request don't see the memoized roles
+	other := NewAuthorizer(newTestNamespaceManager())
+	other.MustRegister(admin)
+	if allowed, _ := other.WithUser(user).Can(ctx, ActionRead, Resource("foo")); allowed {
+		t.Error("expected access to be denied")
+	}
+}
+
+func TestCachingNamespaceManager(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingNamespaceManager{testNamespaceManager: newTestNamespaceManager()}
+	manager := NewCachingNamespaceManager(counting, time.Minute)
+	now := time.Now()
+	manager.now = func() time.Time { return now }
+
+	admin := Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	user, namespace := uuid.NewV4(), uuid.NewV4()
+
+	manager.RolesFor(ctx, globalNamespace, namespace, user)
+	manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 1 {
+		t.Errorf("RolesFor() called %d times, want 1", counting.calls)
+	}
+
+	manager.AddUserToNamespace(ctx, admin, globalNamespace, user)
+	roles, _ := manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 2 || len(roles) != 1 {
+		t.Errorf("expected the cache to be invalidated after a role change")
+	}
+
+	now = now.Add(2 * time.Minute)
+	manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 3 {
+		t.Errorf("expected the cached roles to expire")
+	}
+
+	manager.RemoveUserFromNamespace(ctx, globalNamespace, user)
+	roles, _ = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 4 || len(roles) != 0 {
+		t.Errorf("expected the cache to be invalidated after a role removal")
+	}
+
+	// lookups inside of a transaction never touch the cache
+	manager.WithTransactions(testInTransaction, testOnCommit)
+	transaction := &testTransaction{}
+	tx := context.WithValue(ctx, &testTransactionKey, transaction)
+	manager.AddUserToNamespace(tx, admin, globalNamespace, user)
+	roles, _ = manager.RolesFor(tx, globalNamespace, namespace, user)
+	if counting.calls != 5 || len(roles) != 1 {
+		t.Errorf("expected the transaction to read from the wrapped manager")
+	}
+	manager.RemoveUserFromNamespace(tx, globalNamespace, user)
+	roles, _ = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 6 || len(roles) != 0 {
+		t.Errorf("expected rows read in a transaction not to be cached")
+	}
+
+	// roles cached while the transaction is open are dropped once it commits,
+	// the test manager applies writes right away so the change is undone until
+	// the commit to stand in for an uncommitted row
+	manager.AddUserToNamespace(tx, admin, globalNamespace, user)
+	counting.RemoveUserFromNamespace(ctx, globalNamespace, user)
+	manager.RolesFor(ctx, globalNamespace, namespace, user)
+	manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 7 {
+		t.Errorf("RolesFor() called %d times, want 7", counting.calls)
+	}
+	counting.AddUserToNamespace(ctx, admin, globalNamespace, user)
+	transaction.commit()
+	roles, _ = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	if counting.calls != 8 || len(roles) != 1 {
+		t.Errorf("expected the cache to be invalidated after the transaction commits")
+	}
+}
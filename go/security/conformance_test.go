@@ -0,0 +1,16 @@
+package security_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/security/memory"
+	managerTest "github.com/andrewstucki/web-app-tools/go/security/testing"
+)
+
+func TestCachingNamespaceManagerConformance(t *testing.T) {
+	managerTest.ConformanceTest(t, func() security.NamespaceManager {
+		return security.NewCachingNamespaceManager(memory.NewNamespaceManager(), time.Minute)
+	})
+}
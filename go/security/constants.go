@@ -4,7 +4,9 @@ const (
 	// ActionAll matches any action
 	ActionAll = Action("*")
 
-	// create, read, update, delete, list actions compatible with restful api methods
+	// create, read, update, delete, list actions compatible with restful api methods,
+	// these make up DefaultActions and can be extended with namespaced actions
+	// such as "billing:invoice:read"
 
 	// ActionCreate should be used for creation actions
 	ActionCreate = Action("create")
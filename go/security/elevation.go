@@ -0,0 +1,223 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrElevationsUnsupported is returned when the authorizer has no elevation store
+	ErrElevationsUnsupported = errors.New("authorizer does not have an elevation store")
+	// ErrElevationNotFound is returned when an elevation request doesn't exist
+	ErrElevationNotFound = errors.New("elevation request not found")
+	// ErrElevationDecided is returned when approving or denying an elevation
+	// request that was already approved or denied
+	ErrElevationDecided = errors.New("elevation request was already decided")
+	// ErrInvalidElevation is returned when an elevation request is for an unknown
+	// role or its duration is out of bounds
+	ErrInvalidElevation = errors.New("invalid elevation request")
+	// ErrSelfApproval is returned when a user tries to decide their own elevation request
+	ErrSelfApproval = errors.New("elevation requests can't be decided by the requester")
+)
+
+// ElevationStatus is the state of an elevation request
+type ElevationStatus string
+
+const (
+	// ElevationPending is used for requests awaiting a decision
+	ElevationPending = ElevationStatus("pending")
+	// ElevationApproved is used for requests that were granted
+	ElevationApproved = ElevationStatus("approved")
+	// ElevationDenied is used for requests that were denied
+	ElevationDenied = ElevationStatus("denied")
+)
+
+// Elevation is a request from a user to temporarily hold a role
+// in a namespace, once approved the role is granted for the requested
+// duration
+type Elevation struct {
+	ID        uuid.UUID       `json:"id" db:"id"`
+	User      uuid.UUID       `json:"user" db:"user_id"`
+	Namespace uuid.UUID       `json:"namespace" db:"namespace_id"`
+	Role      string          `json:"role" db:"role"`
+	Reason    string          `json:"reason" db:"reason"`
+	Duration  time.Duration   `json:"duration" db:"duration"`
+	Status    ElevationStatus `json:"status" db:"status"`
+
+	RequestedAt time.Time `json:"requestedAt" db:"requested_at"`
+	// DecidedBy and DecidedAt are set once the request is approved or denied
+	DecidedBy *uuid.UUID `json:"decidedBy,omitempty" db:"decided_by"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty" db:"decided_at"`
+}
+
+// ElevationStore is the storage interface for elevation requests
+type ElevationStore interface {
+	// CreateElevation stores a new elevation request
+	CreateElevation(ctx context.Context, elevation *Elevation) error
+	// GetElevation returns the elevation request with the given id or ErrElevationNotFound
+	GetElevation(ctx context.Context, id uuid.UUID) (*Elevation, error)
+	// DecideElevation records the decision on a pending elevation request, it
+	// returns ErrElevationDecided if the request is no longer pending
+	DecideElevation(ctx context.Context, elevation *Elevation) error
+	// PendingElevations returns every elevation request awaiting a decision
+	PendingElevations(ctx context.Context) ([]Elevation, error)
+}
+
+// TransactionalElevationStore is an elevation store that can run an approval
+// and the grant that follows it in a single transaction
+type TransactionalElevationStore interface {
+	ElevationStore
+	// InTransaction runs fn in the transaction on the context, starting one
+	// when there isn't one, it rolls back if fn fails
+	InTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type elevations struct {
+	store       ElevationStore
+	maxDuration time.Duration
+}
+
+func (e *elevations) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if store, ok := e.store.(TransactionalElevationStore); ok {
+		return store.InTransaction(ctx, fn)
+	}
+	return fn(ctx)
+}
+
+// WithElevations sets the store used for elevation requests, requests for
+// longer than maxDuration are rejected
+func (a *Authorizer) WithElevations(store ElevationStore, maxDuration time.Duration) *Authorizer {
+	a.elevations = &elevations{
+		store:       store,
+		maxDuration: maxDuration,
+	}
+	return a
+}
+
+// RequestElevation records a request from the user to hold the role in the
+// given namespace for the given duration
+func (a *Authorizer) RequestElevation(ctx context.Context, role string, id, user uuid.UUID, duration time.Duration, reason string) (*Elevation, error) {
+	if a.elevations == nil {
+		return nil, ErrElevationsUnsupported
+	}
+	if _, ok := a.Role(role); !ok || duration <= 0 || duration > a.elevations.maxDuration {
+		return nil, ErrInvalidElevation
+	}
+	elevation := &Elevation{
+		ID:          uuid.NewV4(),
+		User:        user,
+		Namespace:   id,
+		Role:        role,
+		Reason:      reason,
+		Duration:    duration,
+		Status:      ElevationPending,
+		RequestedAt: time.Now(),
+	}
+	if err := a.elevations.store.CreateElevation(ctx, elevation); err != nil {
+		return nil, err
+	}
+	return elevation, nil
+}
+
+// Elevation returns the elevation request with the given id
+func (a *Authorizer) Elevation(ctx context.Context, id uuid.UUID) (*Elevation, error) {
+	if a.elevations == nil {
+		return nil, ErrElevationsUnsupported
+	}
+	return a.elevations.store.GetElevation(ctx, id)
+}
+
+// PendingElevations returns every elevation request awaiting a decision
+func (a *Authorizer) PendingElevations(ctx context.Context) ([]Elevation, error) {
+	if a.elevations == nil {
+		return nil, ErrElevationsUnsupported
+	}
+	return a.elevations.store.PendingElevations(ctx)
+}
+
+// ApproveElevation approves a pending elevation request and grants its role
+// until the requested duration has passed, the request is claimed before the
+// role is granted so that only one approval grants it, stores implementing
+// TransactionalElevationStore run both steps in one transaction, a grant of
+// the role that lasts at least as long is left alone
+func (a *Authorizer) ApproveElevation(ctx context.Context, id, approver uuid.UUID) (*Elevation, error) {
+	elevation, err := a.pendingElevation(ctx, id, approver)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := a.Role(elevation.Role)
+	if !ok {
+		return nil, ErrInvalidElevation
+	}
+	err = a.elevations.inTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		if err := a.decideElevation(ctx, elevation, approver, ElevationApproved, now); err != nil {
+			return err
+		}
+		expiresAt := now.Add(elevation.Duration)
+		covered, err := a.hasGrant(ctx, role, elevation.Namespace, elevation.User, expiresAt)
+		if err != nil || covered {
+			return err
+		}
+		return a.AddGrant(ctx, role, elevation.Namespace, elevation.User, &expiresAt, elevation.Reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return elevation, nil
+}
+
+// hasGrant checks whether the user already holds a grant of the role in the
+// namespace that lasts until at least expiresAt
+func (a *Authorizer) hasGrant(ctx context.Context, role Role, id, user uuid.UUID, expiresAt time.Time) (bool, error) {
+	grants, err := a.GrantsFor(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		if uuid.Equal(grant.Namespace, id) && grant.Role == role.Name && (grant.ExpiresAt == nil || !grant.ExpiresAt.Before(expiresAt)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DenyElevation denies a pending elevation request
+func (a *Authorizer) DenyElevation(ctx context.Context, id, approver uuid.UUID) (*Elevation, error) {
+	elevation, err := a.pendingElevation(ctx, id, approver)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.decideElevation(ctx, elevation, approver, ElevationDenied, time.Now()); err != nil {
+		return nil, err
+	}
+	return elevation, nil
+}
+
+// pendingElevation returns the elevation request if the approver can still decide it
+func (a *Authorizer) pendingElevation(ctx context.Context, id, approver uuid.UUID) (*Elevation, error) {
+	if a.elevations == nil {
+		return nil, ErrElevationsUnsupported
+	}
+	elevation, err := a.elevations.store.GetElevation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if elevation.Status != ElevationPending {
+		return nil, ErrElevationDecided
+	}
+	if uuid.Equal(elevation.User, approver) {
+		return nil, ErrSelfApproval
+	}
+	return elevation, nil
+}
+
+func (a *Authorizer) decideElevation(ctx context.Context, elevation *Elevation, approver uuid.UUID, status ElevationStatus, now time.Time) error {
+	elevation.Status = status
+	elevation.DecidedBy = &approver
+	elevation.DecidedAt = &now
+	return a.elevations.store.DecideElevation(ctx, elevation)
+}
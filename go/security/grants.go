@@ -0,0 +1,81 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// AddGrant grants a role to a user in the given namespace on top of their
+// membership, a nil expiresAt grants the role until it's removed
+func (a *Authorizer) AddGrant(ctx context.Context, role Role, id, user uuid.UUID, expiresAt *time.Time, reason string) error {
+	a.invalidateUser(ctx, user)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.AddGrant(ctx, Grant{
+		Namespace: id,
+		User:      user,
+		Role:      role.Name,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return err
+	}
+	return a.auditChange(ctx, AuditGrant, PrincipalUser, id, user, role.Name)
+}
+
+// RemoveGrant removes the grant of a role to a user in the given namespace
+func (a *Authorizer) RemoveGrant(ctx context.Context, role Role, id, user uuid.UUID) error {
+	a.invalidateUser(ctx, user)
+
+	if a.namespaceManager == nil {
+		return ErrNoNamespaceManager
+	}
+	if err := a.namespaceManager.RemoveGrant(ctx, id, user, role.Name); err != nil {
+		return err
+	}
+	return a.auditChange(ctx, AuditRevoke, PrincipalUser, id, user, role.Name)
+}
+
+// GrantsFor returns the unexpired grants of a user across every namespace
+func (a *Authorizer) GrantsFor(ctx context.Context, user uuid.UUID) ([]Grant, error) {
+	if a.namespaceManager != nil {
+		return a.namespaceManager.GrantsFor(ctx, user)
+	}
+	return []Grant{}, nil
+}
+
+// PurgeExpiredGrants deletes every grant that has expired and returns
+// the number of grants deleted
+func (a *Authorizer) PurgeExpiredGrants(ctx context.Context) (int64, error) {
+	a.invalidateAll(ctx)
+
+	if a.namespaceManager == nil {
+		return 0, ErrNoNamespaceManager
+	}
+	return a.namespaceManager.PurgeExpiredGrants(ctx, time.Now())
+}
+
+// SweepExpiredGrants purges expired grants on the given interval until
+// the context is cancelled, errors are passed to onError and don't stop
+// the sweeper, a nil onError ignores them
+func (a *Authorizer) SweepExpiredGrants(ctx context.Context, interval time.Duration, onError func(err error)) {
+	if onError == nil {
+		onError = func(err error) {}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.PurgeExpiredGrants(ctx); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
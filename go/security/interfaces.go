@@ -2,6 +2,7 @@ package security
 
 import (
 	"context"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 )
@@ -15,15 +16,108 @@ type NamespaceRole interface {
 	Name() string
 }
 
+// PrincipalKind is the type of principal that holds a role
+type PrincipalKind string
+
+const (
+	// PrincipalUser is used for users
+	PrincipalUser = PrincipalKind("user")
+	// PrincipalGroup is used for groups
+	PrincipalGroup = PrincipalKind("group")
+	// PrincipalServiceAccount is used for service accounts, they hold
+	// roles under their id just like users
+	PrincipalServiceAccount = PrincipalKind("service_account")
+)
+
+// Membership is the role a principal holds in a namespace
+type Membership struct {
+	Namespace uuid.UUID     `json:"namespace" db:"namespace_id"`
+	Principal uuid.UUID     `json:"principal" db:"principal_id"`
+	Kind      PrincipalKind `json:"kind" db:"kind"`
+	Role      string        `json:"role" db:"role"`
+	// ExpiresAt is set for memberships that come from a grant that expires
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+}
+
+// Group is a named collection of users that can
+// hold roles in a namespace as a single principal
+type Group struct {
+	ID   uuid.UUID `json:"id" db:"id"`
+	Name string    `json:"name" db:"name"`
+}
+
+// GroupManager is the storage interface for
+// groups, their members, and the roles they
+// hold in namespaces
+type GroupManager interface {
+	// CreateGroup creates a group or renames it if it already exists
+	CreateGroup(ctx context.Context, group Group) error
+	// DeleteGroup removes a group along with its members and roles
+	DeleteGroup(ctx context.Context, id uuid.UUID) error
+	// GroupsFor returns all of the groups a user is a member of
+	GroupsFor(ctx context.Context, user uuid.UUID) ([]Group, error)
+	// AddUserToGroup adds a user as a member of a group
+	AddUserToGroup(ctx context.Context, group, user uuid.UUID) error
+	// RemoveUserFromGroup removes a user from a group
+	RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error
+	// AddGroupToNamespace sets the role of a group in the given namespace
+	AddGroupToNamespace(ctx context.Context, role Role, id, group uuid.UUID) error
+	// RemoveGroupFromNamespace removes the role of a group in the given namespace
+	RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error
+}
+
+// Grant is a role granted to a user in a namespace on top
+// of their membership, it stops applying once it expires
+type Grant struct {
+	Namespace uuid.UUID `json:"namespace" db:"namespace_id"`
+	User      uuid.UUID `json:"user" db:"user_id"`
+	Role      string    `json:"role" db:"role"`
+	Reason    string    `json:"reason" db:"reason"`
+	// ExpiresAt is nil for grants that never expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+}
+
+// Expired returns whether the grant has expired by the given time
+func (g *Grant) Expired(now time.Time) bool {
+	return g.ExpiresAt != nil && !g.ExpiresAt.After(now)
+}
+
+// GrantManager is the storage interface for
+// grants, expired grants are ignored by the
+// manager's RolesFor and NamespacesFor
+type GrantManager interface {
+	// AddGrant stores a grant, replacing any grant of the same role
+	// to the user in the namespace
+	AddGrant(ctx context.Context, grant Grant) error
+	// RemoveGrant removes the grant of a role to a user in the given namespace
+	RemoveGrant(ctx context.Context, id, user uuid.UUID, role string) error
+	// GrantsFor returns the unexpired grants of a user across every namespace
+	GrantsFor(ctx context.Context, user uuid.UUID) ([]Grant, error)
+	// PurgeExpiredGrants deletes every grant that expired by the given time
+	// and returns the number of grants deleted
+	PurgeExpiredGrants(ctx context.Context, now time.Time) (int64, error)
+}
+
 // NamespaceManager is the main storage
 // interface for storing roles based off of
 // namespaces (including the global namespace)
 type NamespaceManager interface {
+	GroupManager
+	GrantManager
+
 	// AddUserToNamespace sets the role of a user in the given namespace
 	AddUserToNamespace(ctx context.Context, role Role, id, user uuid.UUID) error
 	// RemoveUserFromNamespace removes the role of a user in the given namespace
 	RemoveUserFromNamespace(ctx context.Context, id, user uuid.UUID) error
 	// RolesFor is used in gathering all of the roles for both the global and given namespace for
-	// a given user
+	// a given user, including any roles granted through the groups the user is a member of and
+	// any unexpired grants
 	RolesFor(ctx context.Context, globalNamespace, namespace, user uuid.UUID) ([]NamespaceRole, error)
+	// NamespacesFor is used in gathering all of the roles a user holds across every namespace,
+	// including any roles granted through the groups the user is a member of and any unexpired
+	// grants
+	NamespacesFor(ctx context.Context, user uuid.UUID) ([]NamespaceRole, error)
+	// MembersOf returns the memberships of every user and group that holds a role
+	// directly in the given namespace, including any unexpired grants
+	MembersOf(ctx context.Context, namespace uuid.UUID) ([]Membership, error)
 }
@@ -0,0 +1,229 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	rice "github.com/GeertJohan/go.rice"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Format is the serialization format of role definitions
+type Format int
+
+const (
+	// FormatYAML is used for YAML role definitions
+	FormatYAML Format = iota
+	// FormatJSON is used for JSON role definitions
+	FormatJSON
+)
+
+var (
+	// ErrUnknownFormat occurs when the format of role definitions
+	// can't be determined from a file name
+	ErrUnknownFormat = errors.New("unknown role definition format")
+)
+
+// FormatFromPath determines the format of role definitions based off
+// of the extension of the file they are stored in
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+	return 0, errors.Wrapf(ErrUnknownFormat, "%q", path)
+}
+
+// Definitions are the serialized form of actions and roles, for example:
+//
+//	actions:
+//	  - billing:invoice:read
+//	roles:
+//	  - name: accountant
+//	    policies:
+//	      - resource: invoices/*
+//	        action: billing:*
+type Definitions struct {
+	Actions []Action `json:"actions" yaml:"actions"`
+	Roles   []Role   `json:"roles" yaml:"roles"`
+}
+
+// ParseDefinitions parses role definitions in the given format, unknown
+// fields are rejected in either format
+func ParseDefinitions(data []byte, format Format) (*Definitions, error) {
+	definitions := &Definitions{}
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.UnmarshalStrict(data, definitions)
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(definitions)
+	default:
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing role definitions failed")
+	}
+	return definitions, nil
+}
+
+// Loader loads role definitions into an authorizer, roles that it loaded
+// previously but that are missing from newer definitions are removed
+type Loader struct {
+	authorizer *Authorizer
+
+	mutex  sync.Mutex
+	loaded []string
+}
+
+// NewLoader creates a loader for the given authorizer
+func NewLoader(authorizer *Authorizer) *Loader {
+	return &Loader{
+		authorizer: authorizer,
+	}
+}
+
+// Load validates and registers the definitions, if anything fails
+// validation the previously loaded roles are left in place
+func (l *Loader) Load(definitions *Definitions) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current := make(map[string]struct{}, len(definitions.Roles))
+	for _, role := range definitions.Roles {
+		current[role.Name] = struct{}{}
+	}
+	stale := []string{}
+	for _, name := range l.loaded {
+		if _, ok := current[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	if err := l.authorizer.roleManager.replace(definitions.Actions, definitions.Roles, stale); err != nil {
+		return err
+	}
+
+	l.loaded = make([]string, 0, len(current))
+	for name := range current {
+		l.loaded = append(l.loaded, name)
+	}
+	return nil
+}
+
+// LoadBytes parses and loads role definitions in the given format
+func (l *Loader) LoadBytes(data []byte, format Format) error {
+	definitions, err := ParseDefinitions(data, format)
+	if err != nil {
+		return err
+	}
+	return l.Load(definitions)
+}
+
+// LoadFile loads role definitions from a YAML or JSON file
+func (l *Loader) LoadFile(path string) error {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return l.LoadBytes(data, format)
+}
+
+// LoadBox loads role definitions from a YAML or JSON file embedded in a rice.Box
+func (l *Loader) LoadBox(box *rice.Box, name string) error {
+	format, err := FormatFromPath(name)
+	if err != nil {
+		return err
+	}
+	data, err := box.Bytes(name)
+	if err != nil {
+		return err
+	}
+	return l.LoadBytes(data, format)
+}
+
+// Watch loads the file and then polls it for changes until the context
+// is cancelled, reloading it whenever it's modified. It's meant for
+// development, errors on reload are passed to onError and the previously
+// loaded roles stay in place, a nil onError ignores them
+func (l *Loader) Watch(ctx context.Context, path string, interval time.Duration, onError func(err error)) error {
+	if onError == nil {
+		onError = func(err error) {}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := l.LoadFile(path); err != nil {
+		return err
+	}
+
+	lastModified := info.ModTime()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if info.ModTime().Equal(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+			if err := l.LoadFile(path); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+var (
+	defaultLoadersMutex sync.Mutex
+	defaultLoaders      = map[string]*Loader{}
+)
+
+// defaultLoader returns the default authorizer's loader for a source, each
+// source gets its own so that reloading one only removes the roles it dropped
+func defaultLoader(source string) *Loader {
+	defaultLoadersMutex.Lock()
+	defer defaultLoadersMutex.Unlock()
+
+	loader, ok := defaultLoaders[source]
+	if !ok {
+		loader = NewLoader(defaultAuthorizer)
+		defaultLoaders[source] = loader
+	}
+	return loader
+}
+
+// LoadRolesFile loads role definitions from a YAML or JSON file into
+// the default authorizer
+func LoadRolesFile(path string) error {
+	return defaultLoader("file:" + path).LoadFile(path)
+}
+
+// LoadRolesBox loads role definitions from a YAML or JSON file embedded
+// in a rice.Box into the default authorizer
+func LoadRolesBox(box *rice.Box, name string) error {
+	return defaultLoader("box:"+box.Name()+"/"+name).LoadBox(box, name)
+}
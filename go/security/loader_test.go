@@ -0,0 +1,230 @@
+package security
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+const yamlDefinitions = `
+actions:
+  - billing:invoice:read
+roles:
+  - name: accountant
+    policies:
+      - resource: invoices/*
+        action: billing:*
+  - name: reader
+    policies:
+      - resource: "*"
+        action: read
+`
+
+const jsonDefinitions = `{
+  "roles": [{
+    "name": "reader",
+    "policies": [{"resource": "projects/:id", "action": "read", "condition": "owner"}]
+  }]
+}`
+
+func TestParseDefinitions(t *testing.T) {
+	definitions, err := ParseDefinitions([]byte(yamlDefinitions), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(definitions.Actions) != 1 || definitions.Actions[0] != "billing:invoice:read" {
+		t.Errorf("unexpected actions %v", definitions.Actions)
+	}
+	if len(definitions.Roles) != 2 || definitions.Roles[0].Policies[0] != (Policy{Resource: "invoices/*", Action: "billing:*"}) {
+		t.Errorf("unexpected roles %v", definitions.Roles)
+	}
+
+	definitions, err = ParseDefinitions([]byte(jsonDefinitions), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Policy{Resource: "projects/:id", Action: ActionRead, Condition: "owner"}); definitions.Roles[0].Policies[0] != want {
+		t.Errorf("unexpected policy %v", definitions.Roles[0].Policies[0])
+	}
+
+	if _, err := ParseDefinitions([]byte("roles: [{nme: reader}]"), FormatYAML); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+	if _, err := ParseDefinitions([]byte(`{"roles": [{"nme": "reader"}]}`), FormatJSON); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+	if _, err := FormatFromPath("roles.toml"); errors.Cause(err) != ErrUnknownFormat {
+		t.Errorf("FormatFromPath() = %v, want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestLoaderReload(t *testing.T) {
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	loader := NewLoader(authorizer)
+	if err := loader.LoadBytes([]byte(yamlDefinitions), FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+	if roles := authorizer.Roles(); len(roles) != 2 {
+		t.Fatalf("expected 2 roles, got %v", roles)
+	}
+
+	// invalid definitions leave the loaded roles in place
+	err := loader.LoadBytes([]byte(`{"roles": [{"name": "writer", "policies": [{"resource": "*", "action": "write"}]}]}`), FormatJSON)
+	if errors.Cause(err) != ErrUnknownAction {
+		t.Errorf("Load() = %v, want %v", err, ErrUnknownAction)
+	}
+	if roles := authorizer.Roles(); len(roles) != 2 {
+		t.Fatalf("expected 2 roles, got %v", roles)
+	}
+
+	// roles missing from new definitions are removed
+	if err := loader.LoadBytes([]byte(jsonDefinitions), FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	roles := authorizer.Roles()
+	if len(roles) != 1 || roles[0].Name != "reader" {
+		t.Fatalf("expected only the reader role, got %v", roles)
+	}
+}
+
+func TestLoadRolesFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	yamlPath := filepath.Join(directory, "roles.yml")
+	jsonPath := filepath.Join(directory, "roles.json")
+	if err := ioutil.WriteFile(yamlPath, []byte(yamlDefinitions), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(jsonPath, []byte(jsonDefinitions), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadRolesFile(yamlPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Default().Role("accountant"); !ok {
+		t.Fatal("expected the accountant role to be loaded")
+	}
+	// loading another source leaves the first source's roles alone
+	if err := LoadRolesFile(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Default().Role("accountant"); !ok {
+		t.Error("expected the accountant role to be kept")
+	}
+	// reloading a source removes the roles it dropped
+	if err := ioutil.WriteFile(yamlPath, []byte("roles: [{name: reader}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRolesFile(yamlPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Default().Role("accountant"); ok {
+		t.Error("expected the accountant role to be removed")
+	}
+	if _, ok := Default().Role("reader"); !ok {
+		t.Error("expected the reader role to be kept")
+	}
+}
+
+func TestLoaderWatch(t *testing.T) {
+	directory, err := ioutil.TempDir("", "roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "roles.yml")
+	if err := ioutil.WriteFile(path, []byte("roles: []"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	done := make(chan error)
+	go func() {
+		done <- NewLoader(authorizer).Watch(ctx, path, 5*time.Millisecond, func(err error) {
+			t.Error(err)
+		})
+	}()
+
+	// make sure the modification time changes
+	modified := time.Now().Add(time.Second)
+	if err := ioutil.WriteFile(path, []byte(yamlDefinitions), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(authorizer.Roles()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("roles were never reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	user := uuid.NewV4()
+	authorizer.SetRole(ctx, Role{Name: "accountant"}, user)
+	allowed, err := authorizer.WithUser(user).Can(ctx, "billing:invoice:read", Resource("invoices/1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected the reloaded role to be applied")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderWatchWithoutOnError(t *testing.T) {
+	directory, err := ioutil.TempDir("", "roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "roles.yml")
+	if err := ioutil.WriteFile(path, []byte(yamlDefinitions), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	done := make(chan error)
+	go func() {
+		done <- NewLoader(authorizer).Watch(ctx, path, 5*time.Millisecond, nil)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(authorizer.Roles()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("roles were never loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// reloading a removed file fails
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// ManifestRule is a policy in a permission manifest, its pattern uses the same
+// grammar as policy resources except that parameters are left unnamed, i.e. ":",
+// and "*" as a resource becomes "**", patterns of rules granted in a namespace
+// are prefixed with the namespace id
+type ManifestRule struct {
+	Pattern string `json:"pattern"`
+	Action  Action `json:"action"`
+	// Conditional rules only apply when their condition passes on the server,
+	// clients should treat them as possibly allowed
+	Conditional bool `json:"conditional,omitempty"`
+}
+
+func (r ManifestRule) key() string {
+	if r.Conditional {
+		return r.Pattern + "|" + r.Action.String() + "|?"
+	}
+	return r.Pattern + "|" + r.Action.String()
+}
+
+// Manifest is a compact description of everything a user can do, suitable for
+// sending to a frontend, the version changes whenever the rules do
+type Manifest struct {
+	Version string    `json:"version"`
+	User    uuid.UUID `json:"user"`
+	// Namespaces are the namespaces other than the global one where the user holds a role
+	Namespaces []uuid.UUID    `json:"namespaces"`
+	Rules      []ManifestRule `json:"rules"`
+}
+
+// Allows checks whether the manifest's unconditional rules permit the action on the
+// resource, it agrees with the server for every policy without a condition
+func (m *Manifest) Allows(action Action, resource Resource) bool {
+	for _, rule := range m.Rules {
+		if rule.Conditional || !rule.Action.Matches(action) {
+			continue
+		}
+		if pathMatch(resource.String(), bindPattern(rule.Pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePattern drops the names of parameters and matches every resource with
+// "**", neither of which change what the pattern matches
+func normalizePattern(resource Resource) string {
+	if resource == ResourceAll {
+		return "**"
+	}
+	parts := strings.Split(resource.String(), "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = ":"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// bindPattern names the anonymous parameters of a normalized pattern so that
+// it can be matched like a policy's pattern
+func bindPattern(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if part == ":" {
+			parts[i] = ":_"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Manifest compiles the user's effective policies across every namespace into a
+// permission manifest
+func (a *Authorizer) Manifest(ctx context.Context, user uuid.UUID) (*Manifest, error) {
+	manifest := &Manifest{
+		User:       user,
+		Namespaces: []uuid.UUID{},
+		Rules:      []ManifestRule{},
+	}
+	if a.namespaceManager != nil {
+		roles, err := a.namespacesFor(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]struct{})
+		for _, policy := range a.roleManager.getPolicies(roles...) {
+			rule := ManifestRule{
+				Pattern:     normalizePattern(policy.Resource),
+				Action:      policy.Action,
+				Conditional: policy.Condition != "",
+			}
+			if _, ok := seen[rule.key()]; ok {
+				continue
+			}
+			seen[rule.key()] = struct{}{}
+			manifest.Rules = append(manifest.Rules, rule)
+		}
+		manifest.Namespaces = namespaceIDs(roles)
+	}
+
+	sort.Slice(manifest.Rules, func(i, j int) bool {
+		return manifest.Rules[i].key() < manifest.Rules[j].key()
+	})
+	sort.Slice(manifest.Namespaces, func(i, j int) bool {
+		return manifest.Namespaces[i].String() < manifest.Namespaces[j].String()
+	})
+
+	hash := sha256.New()
+	hash.Write(user.Bytes())
+	for _, namespace := range manifest.Namespaces {
+		hash.Write(namespace.Bytes())
+	}
+	for _, rule := range manifest.Rules {
+		hash.Write([]byte(rule.key()))
+		hash.Write([]byte{0})
+	}
+	manifest.Version = hex.EncodeToString(hash.Sum(nil)[:16])
+	return manifest, nil
+}
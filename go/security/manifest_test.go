@@ -0,0 +1,96 @@
+package security
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+	auditor := Role{Name: "auditor", Policies: []Policy{
+		{Resource: "*/reports/**", Action: ActionRead},
+		{Resource: "reports", Action: ActionList},
+	}}
+	editor := Role{Name: "editor", Policies: []Policy{
+		{Resource: "projects/:id", Action: ActionUpdate},
+		{Resource: "projects/:id", Action: ActionDelete, Condition: "owner"},
+		{Resource: `\:special`, Action: "billing:*"},
+	}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	if err := authorizer.RegisterActions("billing:invoice:read"); err != nil {
+		t.Fatal(err)
+	}
+	authorizer.MustRegister(auditor, editor)
+	authorizer.RegisterCondition("owner", func(ctx context.Context, evaluation *Evaluation) (bool, error) {
+		return true, nil
+	})
+
+	user, first, second := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	if err := authorizer.SetRole(ctx, auditor, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizer.AddUserToNamespace(ctx, editor, first, user); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := authorizer.Manifest(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Namespaces) != 1 || manifest.Namespaces[0] != first {
+		t.Errorf("expected only the editor namespace, got %+v", manifest.Namespaces)
+	}
+	if len(manifest.Rules) != 5 {
+		t.Fatalf("expected 5 rules, got %+v", manifest.Rules)
+	}
+
+	// the manifest agrees with the server for unconditional policies
+	for _, check := range []struct {
+		namespace uuid.UUID
+		action    Action
+		resource  Resource
+	}{
+		{first, ActionRead, "reports/2020/q1"},
+		{second, ActionRead, "reports"},
+		{globalNamespace, ActionRead, "reports"},
+		{globalNamespace, ActionList, "reports"},
+		{first, ActionUpdate, "projects/1"},
+		{second, ActionUpdate, "projects/1"},
+		{first, ActionUpdate, "projects/1/tasks"},
+		{first, "billing:invoice:read", ":special"},
+		{first, "billing:invoice:read", "special"},
+	} {
+		resource := NamespacedResource(check.namespace, check.resource)
+		allowed, err := authorizer.WithNamespaceAndUser(check.namespace, user).Can(ctx, check.action, resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.Allows(check.action, resource) != allowed {
+			t.Errorf("expected manifest to agree with the server (%v) for %s on %s", allowed, check.action, resource)
+		}
+	}
+	// conditional policies are left to the server
+	if manifest.Allows(ActionDelete, NamespacedResource(first, "projects/1")) {
+		t.Error("expected conditional rules to be skipped")
+	}
+
+	again, err := authorizer.Manifest(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Version != manifest.Version {
+		t.Errorf("expected a stable version, got %q and %q", manifest.Version, again.Version)
+	}
+	if err := authorizer.AddUserToNamespace(ctx, auditor, second, user); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := authorizer.Manifest(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Version == manifest.Version {
+		t.Error("expected the version to change with the user's roles")
+	}
+}
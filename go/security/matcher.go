@@ -2,89 +2,167 @@ package security
 
 import (
 	"context"
-	"regexp"
-	"strings"
-	"sync"
 
 	uuid "github.com/satori/go.uuid"
 )
 
-var (
-	regexCache sync.Map
-	tokenizer  *regexp.Regexp
-)
-
-func init() {
-	tokenizer = regexp.MustCompile(`(.*):[^/]+(.*)`)
-}
-
 // Evaluator implements a permissions evaluation engine
 type Evaluator struct {
-	namespaceManager NamespaceManager
-	namespace        uuid.UUID
-	user             uuid.UUID
+	authorizer *Authorizer
+	namespace  uuid.UUID
+	user       uuid.UUID
+	// kind is recorded in the audit log, it defaults to the actor's
+	// kind when evaluating for the actor and to PrincipalUser otherwise
+	kind PrincipalKind
 }
 
-func newEvaluator(namespaceManager NamespaceManager, namespace uuid.UUID, user uuid.UUID) *Evaluator {
+func newEvaluator(authorizer *Authorizer, namespace uuid.UUID, user uuid.UUID) *Evaluator {
 	return &Evaluator{
-		namespaceManager: namespaceManager,
-		namespace:        namespace,
-		user:             user,
+		authorizer: authorizer,
+		namespace:  namespace,
+		user:       user,
 	}
 }
 
+// WithKind returns an evaluator for a principal of the given kind, e.g. a
+// service account, the kind is only used for the audit log
+func (e *Evaluator) WithKind(kind PrincipalKind) *Evaluator {
+	evaluator := *e
+	evaluator.kind = kind
+	return &evaluator
+}
+
 // Can evaluates whether or not a user has permission to do something
 func (e *Evaluator) Can(ctx context.Context, action Action, resource Resource) (bool, error) {
-	if e.namespaceManager == nil {
-		return false, nil
+	policies, err := e.Policies(ctx)
+	if err != nil {
+		return false, err
 	}
+	return e.decide(ctx, policies, action, resource)
+}
 
-	roles, err := e.namespaceManager.RolesFor(ctx, globalNamespace, e.namespace, e.user)
+// CanAll evaluates whether or not a user has permission to do something
+// to each of the given resources, the user's roles are only fetched once
+func (e *Evaluator) CanAll(ctx context.Context, action Action, resources []Resource) ([]bool, error) {
+	policies, err := e.Policies(ctx)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	policies := globalRoleManager.getPolicies(roles...)
-	for _, policy := range policies {
-		actionMatch := policy.Action == ActionAll || policy.Action == action
-		resourceMatch := policy.Resource == ResourceAll || pathMatch(resource.String(), policy.Resource.String())
+	allowed := make([]bool, len(resources))
+	for i, resource := range resources {
+		if allowed[i], err = e.decide(ctx, policies, action, resource); err != nil {
+			return nil, err
+		}
+	}
+	return allowed, nil
+}
 
-		if actionMatch && resourceMatch {
-			return true, nil
+// Filter returns the indices of the items in a collection of the given
+// length that the user has permission to act on, resourceAt is called with
+// each index to get the resource of the item at that index
+func (e *Evaluator) Filter(ctx context.Context, action Action, length int, resourceAt func(i int) Resource) ([]int, error) {
+	policies, err := e.Policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	indices := []int{}
+	for i := 0; i < length; i++ {
+		allowed, err := e.decide(ctx, policies, action, resourceAt(i))
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			indices = append(indices, i)
 		}
 	}
-	return false, nil
+	return indices, nil
 }
 
 // Policies returns policies for the user
 func (e *Evaluator) Policies(ctx context.Context) ([]Policy, error) {
-	if e.namespaceManager == nil {
+	if e.authorizer.namespaceManager == nil {
 		return []Policy{}, nil
 	}
-	roles, err := e.namespaceManager.RolesFor(ctx, globalNamespace, e.namespace, e.user)
+	roles, err := e.authorizer.rolesFor(ctx, e.namespace, e.user)
 	if err != nil {
 		return nil, err
 	}
-	return globalRoleManager.getPolicies(roles...), nil
+	return e.authorizer.roleManager.getPolicies(roles...), nil
 }
 
-// pathMatch determines whether path matches the pattern, it matches
-// on placeholders
-func pathMatch(path, pattern string) bool {
-	var regex *regexp.Regexp
-	stored, ok := regexCache.Load(pattern)
-	if ok {
-		regex = stored.(*regexp.Regexp)
-	} else {
-		regexPattern := strings.Replace(pattern, "/*", "/.*", -1)
-		for {
-			if !strings.Contains(regexPattern, "/:") {
-				break
-			}
-			regexPattern = tokenizer.ReplaceAllString(regexPattern, "$1[^/]+$2")
+// allows checks whether any of the policies permit the action on the resource
+func (e *Evaluator) allows(ctx context.Context, policies []Policy, action Action, resource Resource) (bool, error) {
+	policy, err := e.match(ctx, policies, action, resource)
+	if err != nil {
+		return false, err
+	}
+	return policy != nil, nil
+}
+
+// decide checks whether any of the policies permit the action on the resource
+// and passes the decision along to the authorizer's audit sink
+func (e *Evaluator) decide(ctx context.Context, policies []Policy, action Action, resource Resource) (bool, error) {
+	policy, err := e.match(ctx, policies, action, resource)
+	if err != nil {
+		return false, err
+	}
+	if err := e.authorizer.auditDecision(ctx, e, action, resource, policy); err != nil {
+		return false, err
+	}
+	return policy != nil, nil
+}
+
+// match returns the first policy that permits the action on the resource, when
+// the context is limited with WithScope the scope must permit it as well
+func (e *Evaluator) match(ctx context.Context, policies []Policy, action Action, resource Resource) (*Policy, error) {
+	policy, err := e.matchPolicies(ctx, policies, action, resource)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	if scope, ok := ScopeFrom(ctx); ok {
+		scoped, err := e.matchPolicies(ctx, scope, action, resource)
+		if err != nil || scoped == nil {
+			return nil, err
 		}
-		regex = regexp.MustCompile("^" + regexPattern + "$")
-		regexCache.Store(pattern, regex)
 	}
+	return policy, nil
+}
 
-	return regex.MatchString(path)
+// matchPolicies returns the first of the policies that permits the action on the
+// resource, policies with a condition only apply when the condition passes
+func (e *Evaluator) matchPolicies(ctx context.Context, policies []Policy, action Action, resource Resource) (*Policy, error) {
+	for i, policy := range policies {
+		if !policy.Action.Matches(action) {
+			continue
+		}
+		if policy.Condition == "" {
+			if policy.Resource == ResourceAll || pathMatch(resource.String(), policy.Resource.String()) {
+				return &policies[i], nil
+			}
+			continue
+		}
+		// only bind parameters when a condition needs them
+		params := Params{}
+		if policy.Resource != ResourceAll {
+			matched, ok := pathParams(resource.String(), policy.Resource.String())
+			if !ok {
+				continue
+			}
+			params = matched
+		}
+		allowed, err := e.authorizer.roleManager.evaluate(ctx, policy.Condition, &Evaluation{
+			User:      e.user,
+			Namespace: e.namespace,
+			Action:    action,
+			Resource:  resource,
+			Params:    params,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			return &policies[i], nil
+		}
+	}
+	return nil, nil
 }
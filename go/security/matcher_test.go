@@ -2,6 +2,8 @@ package security
 
 import (
 	"context"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 
@@ -9,6 +11,9 @@ import (
 )
 
 type testNamespaceManager struct {
+	// group management isn't exercised by the evaluator tests
+	NamespaceManager
+
 	membership sync.Map
 }
 
@@ -60,6 +65,34 @@ func (m *testNamespaceManager) RolesFor(ctx context.Context, globalNamespace, na
 	return roles, nil
 }
 
+func (m *testNamespaceManager) NamespacesFor(ctx context.Context, user uuid.UUID) ([]NamespaceRole, error) {
+	roles := []NamespaceRole{}
+	m.membership.Range(func(key, value interface{}) bool {
+		if strings.HasSuffix(key.(string), "|"+user.String()) {
+			roles = append(roles, value.(*memoryRole))
+		}
+		return true
+	})
+	return roles, nil
+}
+
+func (m *testNamespaceManager) MembersOf(ctx context.Context, namespace uuid.UUID) ([]Membership, error) {
+	members := []Membership{}
+	prefix := namespace.String() + "|"
+	m.membership.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			members = append(members, Membership{
+				Namespace: namespace,
+				Principal: uuid.FromStringOrNil(strings.TrimPrefix(key.(string), prefix)),
+				Kind:      PrincipalUser,
+				Role:      value.(*memoryRole).MemoryName,
+			})
+		}
+		return true
+	})
+	return members, nil
+}
+
 func TestPathMatcher(t *testing.T) {
 	type args struct {
 		path    string
@@ -106,49 +139,49 @@ func TestEvaluator(t *testing.T) {
 	}{
 		{
 			name:     "all foo - create",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionAll}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}},
 			resource: foo,
 			action:   ActionCreate,
 			want:     true,
 		},
 		{
 			name:     "all foo - read",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionAll}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}},
 			resource: foo,
 			action:   ActionRead,
 			want:     true,
 		},
 		{
 			name:     "all foo - update",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionAll}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}},
 			resource: foo,
 			action:   ActionUpdate,
 			want:     true,
 		},
 		{
 			name:     "all foo - delete",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionAll}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}},
 			resource: foo,
 			action:   ActionDelete,
 			want:     true,
 		},
 		{
 			name:     "all foo - list",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionAll}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}},
 			resource: foo,
 			action:   ActionList,
 			want:     true,
 		},
 		{
 			name:     "create foo - list",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionCreate}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionCreate}}},
 			resource: foo,
 			action:   ActionList,
 			want:     false,
 		},
 		{
 			name:     "list foo - list",
-			role:     Role{"admin", []Policy{{ResourceAll, ActionList}}},
+			role:     Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionList}}},
 			resource: foo,
 			action:   ActionList,
 			want:     true,
@@ -162,14 +195,14 @@ func TestEvaluator(t *testing.T) {
 		},
 		{
 			name:     "foo sub resource - list",
-			role:     Role{"admin", []Policy{{foo.Sub("bar"), ActionList}}},
+			role:     Role{"admin", []Policy{{Resource: foo.Sub("bar"), Action: ActionList}}},
 			resource: foo.Sub("bar"),
 			action:   ActionList,
 			want:     true,
 		},
 		{
 			name:     "foo sub resource - list fail",
-			role:     Role{"admin", []Policy{{foo.Sub("bar"), ActionList}}},
+			role:     Role{"admin", []Policy{{Resource: foo.Sub("bar"), Action: ActionList}}},
 			resource: foo.Sub("baz"),
 			action:   ActionList,
 			want:     false,
@@ -177,13 +210,12 @@ func TestEvaluator(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			namespaceManager := newTestNamespaceManager()
-			globalRoleManager = newRoleManager()
-			globalRoleManager.register(tt.role)
+			authorizer := NewAuthorizer(newTestNamespaceManager())
+			authorizer.MustRegister(tt.role)
 			user := uuid.NewV4()
 
-			namespaceManager.AddUserToNamespace(context.Background(), tt.role, globalNamespace, user)
-			have, err := newEvaluator(namespaceManager, globalNamespace, user).Can(context.Background(), tt.action, tt.resource)
+			authorizer.SetRole(context.Background(), tt.role, user)
+			have, err := authorizer.WithUser(user).Can(context.Background(), tt.action, tt.resource)
 			if err != nil {
 				t.Error(err)
 				return
@@ -194,3 +226,72 @@ func TestEvaluator(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluatorBatch(t *testing.T) {
+	ctx := context.Background()
+	role := Role{"reader", []Policy{{Resource: Resource("projects/:id"), Action: ActionRead}}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	authorizer.MustRegister(role)
+	user := uuid.NewV4()
+	authorizer.SetRole(ctx, role, user)
+
+	resources := []Resource{"projects/1", "robots/1", "projects/2", "projects/2/robots"}
+	allowed, err := authorizer.WithUser(user).CanAll(ctx, ActionRead, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false, true, false}; !reflect.DeepEqual(allowed, want) {
+		t.Errorf("CanAll() = %v, want %v", allowed, want)
+	}
+
+	indices, err := authorizer.WithUser(user).Filter(ctx, ActionRead, len(resources), func(i int) Resource {
+		return resources[i]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 2}; !reflect.DeepEqual(indices, want) {
+		t.Errorf("Filter() = %v, want %v", indices, want)
+	}
+}
+
+func TestEvaluatorNamespaces(t *testing.T) {
+	ctx := context.Background()
+	reader := Role{"reader", []Policy{{Resource: Resource("projects"), Action: ActionRead}}}
+	admin := Role{"admin", []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	authorizer.MustRegister(reader, admin)
+
+	user := uuid.NewV4()
+	first, second, third := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	authorizer.AddUserToNamespace(ctx, reader, first, user)
+	authorizer.AddUserToNamespace(ctx, admin, second, user)
+
+	set, err := authorizer.WithUser(user).Namespaces(ctx, ActionRead, Resource("projects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.All {
+		t.Error("expected the namespace set to be limited")
+	}
+	if !set.Contains(first) || !set.Contains(second) || set.Contains(third) {
+		t.Errorf("Namespaces() = %v, want [%v %v]", set.IDs, first, second)
+	}
+
+	set, err = authorizer.WithUser(user).Namespaces(ctx, ActionDelete, Resource("projects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set.IDs, []uuid.UUID{second}) {
+		t.Errorf("Namespaces() = %v, want [%v]", set.IDs, second)
+	}
+
+	authorizer.SetRole(ctx, admin, user)
+	set, err = authorizer.WithUser(user).Namespaces(ctx, ActionDelete, Resource("projects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.All || !set.Contains(third) {
+		t.Error("expected a global admin to act in every namespace")
+	}
+}
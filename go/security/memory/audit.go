@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// AuditLog is an audit sink that keeps
+// every event in memory
+type AuditLog struct {
+	mutex  sync.RWMutex
+	events []security.AuditEvent
+}
+
+// NewAuditLog creates a new audit log that stores
+// everything in memory
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Record stores the audit event
+func (l *AuditLog) Record(ctx context.Context, event *security.AuditEvent) error {
+	l.mutex.Lock()
+	l.events = append(l.events, *event)
+	l.mutex.Unlock()
+	return nil
+}
+
+// History returns events matching the query, newest first
+func (l *AuditLog) History(ctx context.Context, query security.AuditQuery) ([]security.AuditEvent, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	events := []security.AuditEvent{}
+	for i := len(l.events) - 1; i >= 0 && (query.Limit <= 0 || len(events) < query.Limit); i-- {
+		event := l.events[i]
+		if query.Actor != nil && event.Actor != *query.Actor {
+			continue
+		}
+		if query.Subject != nil && event.Subject != *query.Subject {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	admin := security.Role{Name: "admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	log := NewAuditLog()
+	authorizer := security.NewAuthorizer(NewNamespaceManager()).WithAudit(log, security.AuditOptions{Denied: true})
+	require.NoError(t, authorizer.Register(admin))
+
+	actor, user, namespace := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ctx := security.WithActor(context.Background(), actor)
+
+	allowed, err := authorizer.WithUser(user).Can(ctx, security.ActionRead, security.Resource("foo"))
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.NoError(t, authorizer.AddUserToNamespace(ctx, admin, namespace, user))
+	// allowed decisions aren't sampled
+	allowed, err = authorizer.WithNamespaceAndUser(namespace, user).Can(ctx, security.ActionRead, security.NamespacedResource(namespace, "foo"))
+	require.NoError(t, err)
+	require.True(t, allowed)
+	require.NoError(t, authorizer.RemoveUserFromNamespace(ctx, namespace, user))
+	group := uuid.NewV4()
+	require.NoError(t, authorizer.AddUserToGroup(ctx, group, user))
+	require.NoError(t, authorizer.RemoveUserFromGroup(ctx, group, user))
+
+	events, err := authorizer.History(ctx, security.AuditQuery{Subject: &user})
+	require.NoError(t, err)
+	require.Len(t, events, 5)
+	require.Equal(t, security.AuditGroupLeave, events[0].Kind)
+	require.Equal(t, security.AuditGroupJoin, events[1].Kind)
+	require.Equal(t, group, events[1].Group)
+	require.Equal(t, security.AuditRevoke, events[2].Kind)
+	require.Equal(t, security.AuditGrant, events[3].Kind)
+	require.Equal(t, "admin", events[3].Role)
+	require.Equal(t, namespace, events[3].Namespace)
+	require.Equal(t, security.AuditDecision, events[4].Kind)
+	require.False(t, events[4].Allowed)
+	for _, event := range events {
+		require.Equal(t, actor, event.Actor)
+	}
+
+	other := uuid.NewV4()
+	events, err = authorizer.History(ctx, security.AuditQuery{Actor: &other})
+	require.NoError(t, err)
+	require.Len(t, events, 0)
+
+	log = NewAuditLog()
+	authorizer.WithAudit(log, security.AuditOptions{SampleRate: 1})
+	_, err = authorizer.WithUser(user).Can(ctx, security.ActionRead, security.Resource("foo"))
+	require.NoError(t, err)
+	events, err = authorizer.History(ctx, security.AuditQuery{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, security.AuditDecision, events[0].Kind)
+}
+
+func TestAuditLogPrincipalKinds(t *testing.T) {
+	log := NewAuditLog()
+	authorizer := security.NewAuthorizer(NewNamespaceManager()).WithAudit(log, security.AuditOptions{Denied: true})
+
+	account, group := uuid.NewV4(), uuid.NewV4()
+	ctx := security.WithActorKind(context.Background(), account, security.PrincipalServiceAccount)
+	_, err := authorizer.WithUser(account).Can(ctx, security.ActionRead, security.Resource("foo"))
+	require.NoError(t, err)
+	require.NoError(t, authorizer.AddPrincipalToGroup(ctx, group, account, security.PrincipalServiceAccount))
+
+	events, err := authorizer.History(ctx, security.AuditQuery{Subject: &account})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	for _, event := range events {
+		require.Equal(t, security.PrincipalServiceAccount, event.SubjectKind)
+	}
+
+	// evaluators for other principals are users unless told otherwise
+	user := uuid.NewV4()
+	_, err = authorizer.WithUser(user).Can(ctx, security.ActionRead, security.Resource("foo"))
+	require.NoError(t, err)
+	_, err = authorizer.WithUser(group).WithKind(security.PrincipalGroup).Can(ctx, security.ActionRead, security.Resource("foo"))
+	require.NoError(t, err)
+	events, err = authorizer.History(ctx, security.AuditQuery{Subject: &user})
+	require.NoError(t, err)
+	require.Equal(t, security.PrincipalUser, events[0].SubjectKind)
+	events, err = authorizer.History(ctx, security.AuditQuery{Subject: &group})
+	require.NoError(t, err)
+	require.Equal(t, security.PrincipalGroup, events[0].SubjectKind)
+}
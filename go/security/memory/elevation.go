@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// ElevationStore is an elevation store that
+// keeps every request in memory
+type ElevationStore struct {
+	mutex      sync.RWMutex
+	elevations map[uuid.UUID]security.Elevation
+}
+
+// NewElevationStore creates a new elevation store that
+// stores everything in memory
+func NewElevationStore() *ElevationStore {
+	return &ElevationStore{
+		elevations: make(map[uuid.UUID]security.Elevation),
+	}
+}
+
+// CreateElevation stores a new elevation request
+func (s *ElevationStore) CreateElevation(ctx context.Context, elevation *security.Elevation) error {
+	s.mutex.Lock()
+	s.elevations[elevation.ID] = *elevation
+	s.mutex.Unlock()
+	return nil
+}
+
+// GetElevation returns the elevation request with the given id or ErrElevationNotFound
+func (s *ElevationStore) GetElevation(ctx context.Context, id uuid.UUID) (*security.Elevation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	elevation, ok := s.elevations[id]
+	if !ok {
+		return nil, security.ErrElevationNotFound
+	}
+	return &elevation, nil
+}
+
+// DecideElevation records the decision on a pending elevation request, it
+// returns ErrElevationDecided if the request is no longer pending
+func (s *ElevationStore) DecideElevation(ctx context.Context, elevation *security.Elevation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.elevations[elevation.ID]
+	if !ok {
+		return security.ErrElevationNotFound
+	}
+	if stored.Status != security.ElevationPending {
+		return security.ErrElevationDecided
+	}
+	stored.Status = elevation.Status
+	stored.DecidedBy = elevation.DecidedBy
+	stored.DecidedAt = elevation.DecidedAt
+	s.elevations[elevation.ID] = stored
+	return nil
+}
+
+// PendingElevations returns every elevation request awaiting a decision,
+// oldest first
+func (s *ElevationStore) PendingElevations(ctx context.Context) ([]security.Elevation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	elevations := []security.Elevation{}
+	for _, elevation := range s.elevations {
+		if elevation.Status == security.ElevationPending {
+			elevations = append(elevations, elevation)
+		}
+	}
+	sort.Slice(elevations, func(i, j int) bool {
+		return elevations[i].RequestedAt.Before(elevations[j].RequestedAt)
+	})
+	return elevations, nil
+}
@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElevations(t *testing.T) {
+	admin := security.Role{Name: "super_admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	authorizer := security.NewAuthorizer(NewNamespaceManager()).WithElevations(NewElevationStore(), time.Hour)
+	require.NoError(t, authorizer.Register(admin))
+
+	user, approver := uuid.NewV4(), uuid.NewV4()
+	ctx := context.Background()
+
+	_, err := authorizer.RequestElevation(ctx, "unknown", uuid.UUID{}, user, time.Minute, "incident")
+	require.Equal(t, security.ErrInvalidElevation, err)
+	_, err = authorizer.RequestElevation(ctx, admin.Name, uuid.UUID{}, user, 2*time.Hour, "incident")
+	require.Equal(t, security.ErrInvalidElevation, err)
+
+	elevation, err := authorizer.RequestElevation(ctx, admin.Name, uuid.UUID{}, user, time.Minute, "incident")
+	require.NoError(t, err)
+	pending, err := authorizer.PendingElevations(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	allowed, err := authorizer.WithUser(user).Can(ctx, security.ActionDelete, security.Resource("foo"))
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	_, err = authorizer.ApproveElevation(ctx, elevation.ID, user)
+	require.Equal(t, security.ErrSelfApproval, err)
+	approved, err := authorizer.ApproveElevation(ctx, elevation.ID, approver)
+	require.NoError(t, err)
+	require.Equal(t, security.ElevationApproved, approved.Status)
+	require.Equal(t, approver, *approved.DecidedBy)
+	_, err = authorizer.DenyElevation(ctx, elevation.ID, approver)
+	require.Equal(t, security.ErrElevationDecided, err)
+
+	allowed, err = authorizer.WithUser(user).Can(ctx, security.ActionDelete, security.Resource("foo"))
+	require.NoError(t, err)
+	require.True(t, allowed)
+	grants, err := authorizer.GrantsFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	require.Equal(t, "incident", grants[0].Reason)
+
+	pending, err = authorizer.PendingElevations(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 0)
+}
+
+type failingElevationStore struct {
+	*ElevationStore
+}
+
+func (s *failingElevationStore) DecideElevation(ctx context.Context, elevation *security.Elevation) error {
+	return errors.New("failed")
+}
+
+func TestElevationApprovalFailure(t *testing.T) {
+	admin := security.Role{Name: "super_admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	store := &failingElevationStore{NewElevationStore()}
+	authorizer := security.NewAuthorizer(NewNamespaceManager()).WithElevations(store, time.Hour)
+	require.NoError(t, authorizer.Register(admin))
+
+	user, approver := uuid.NewV4(), uuid.NewV4()
+	ctx := context.Background()
+
+	elevation, err := authorizer.RequestElevation(ctx, admin.Name, uuid.UUID{}, user, time.Minute, "incident")
+	require.NoError(t, err)
+	_, err = authorizer.ApproveElevation(ctx, elevation.ID, approver)
+	require.Error(t, err)
+
+	// nothing is granted when the request can't be claimed
+	grants, err := authorizer.GrantsFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, grants, 0)
+	pending, err := authorizer.PendingElevations(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+}
+
+func TestElevationKeepsLongerGrant(t *testing.T) {
+	admin := security.Role{Name: "super_admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	authorizer := security.NewAuthorizer(NewNamespaceManager()).WithElevations(NewElevationStore(), time.Hour)
+	require.NoError(t, authorizer.Register(admin))
+
+	user, approver := uuid.NewV4(), uuid.NewV4()
+	ctx := context.Background()
+	require.NoError(t, authorizer.AddGrant(ctx, admin, uuid.UUID{}, user, nil, "on call"))
+
+	elevation, err := authorizer.RequestElevation(ctx, admin.Name, uuid.UUID{}, user, time.Minute, "incident")
+	require.NoError(t, err)
+	_, err = authorizer.ApproveElevation(ctx, elevation.ID, approver)
+	require.NoError(t, err)
+
+	// the permanent grant isn't replaced with an expiring one
+	grants, err := authorizer.GrantsFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	require.Nil(t, grants[0].ExpiresAt)
+	require.Equal(t, "on call", grants[0].Reason)
+}
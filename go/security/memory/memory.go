@@ -2,7 +2,9 @@ package memory
 
 import (
 	"context"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/andrewstucki/web-app-tools/go/security"
 
@@ -13,7 +15,11 @@ import (
 // that writes and retrieves data from
 // memory
 type NamespaceManager struct {
-	membership sync.Map
+	membership      sync.Map
+	groups          sync.Map
+	groupMembers    sync.Map
+	groupMembership sync.Map
+	grants          sync.Map
 }
 
 // NewNamespaceManager creates a new manager that stores
@@ -22,8 +28,8 @@ func NewNamespaceManager() *NamespaceManager {
 	return &NamespaceManager{}
 }
 
-func compoundKey(namespace, user uuid.UUID) string {
-	return namespace.String() + "|" + user.String()
+func compoundKey(namespace, principal uuid.UUID) string {
+	return namespace.String() + "|" + principal.String()
 }
 
 type memoryRole struct {
@@ -41,35 +47,304 @@ func (r *memoryRole) Name() string {
 	return r.MemoryName
 }
 
+type memoryGroupRole struct {
+	*memoryRole
+	group uuid.UUID
+}
+
+type memoryGroupMember struct {
+	group uuid.UUID
+	user  uuid.UUID
+}
+
 // AddUserToNamespace sets the role of a user in the given namespace
 func (m *NamespaceManager) AddUserToNamespace(ctx context.Context, role security.Role, id, user uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	m.membership.Store(compoundKey(id, user), &memoryRole{role.Name, id})
 	return nil
 }
 
 // RemoveUserFromNamespace removes the role of a user in the given namespace
 func (m *NamespaceManager) RemoveUserFromNamespace(ctx context.Context, id, user uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	m.membership.Delete(compoundKey(id, user))
 	return nil
 }
 
+// CreateGroup creates a group or renames it if it already exists
+func (m *NamespaceManager) CreateGroup(ctx context.Context, group security.Group) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.groups.Store(group.ID, group)
+	return nil
+}
+
+// DeleteGroup removes a group along with its members and roles
+func (m *NamespaceManager) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.groups.Delete(id)
+	m.groupMembers.Range(func(key, value interface{}) bool {
+		if value.(*memoryGroupMember).group == id {
+			m.groupMembers.Delete(key)
+		}
+		return true
+	})
+	m.groupMembership.Range(func(key, value interface{}) bool {
+		if value.(*memoryGroupRole).group == id {
+			m.groupMembership.Delete(key)
+		}
+		return true
+	})
+	return nil
+}
+
+// GroupsFor returns all of the groups a user is a member of
+func (m *NamespaceManager) GroupsFor(ctx context.Context, user uuid.UUID) ([]security.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	groups := []security.Group{}
+	m.groups.Range(func(key, value interface{}) bool {
+		if _, ok := m.groupMembers.Load(compoundKey(key.(uuid.UUID), user)); ok {
+			groups = append(groups, value.(security.Group))
+		}
+		return true
+	})
+	return groups, nil
+}
+
+// AddUserToGroup adds a user as a member of a group
+func (m *NamespaceManager) AddUserToGroup(ctx context.Context, group, user uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.groupMembers.Store(compoundKey(group, user), &memoryGroupMember{group, user})
+	return nil
+}
+
+// RemoveUserFromGroup removes a user from a group
+func (m *NamespaceManager) RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.groupMembers.Delete(compoundKey(group, user))
+	return nil
+}
+
+// AddGroupToNamespace sets the role of a group in the given namespace
+func (m *NamespaceManager) AddGroupToNamespace(ctx context.Context, role security.Role, id, group uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.groupMembership.Store(compoundKey(id, group), &memoryGroupRole{&memoryRole{role.Name, id}, group})
+	return nil
+}
+
+// RemoveGroupFromNamespace removes the role of a group in the given namespace
+func (m *NamespaceManager) RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.groupMembership.Delete(compoundKey(id, group))
+	return nil
+}
+
+// AddGrant stores a grant, replacing any grant of the same role
+// to the user in the namespace
+func (m *NamespaceManager) AddGrant(ctx context.Context, grant security.Grant) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.grants.Store(compoundKey(grant.Namespace, grant.User)+"|"+grant.Role, &grant)
+	return nil
+}
+
+// RemoveGrant removes the grant of a role to a user in the given namespace
+func (m *NamespaceManager) RemoveGrant(ctx context.Context, id, user uuid.UUID, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.grants.Delete(compoundKey(id, user) + "|" + role)
+	return nil
+}
+
+// GrantsFor returns the unexpired grants of a user across every namespace
+func (m *NamespaceManager) GrantsFor(ctx context.Context, user uuid.UUID) ([]security.Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	grants := []security.Grant{}
+	now := time.Now()
+	m.grants.Range(func(key, value interface{}) bool {
+		if grant := value.(*security.Grant); grant.User == user && !grant.Expired(now) {
+			grants = append(grants, *grant)
+		}
+		return true
+	})
+	return grants, nil
+}
+
+// PurgeExpiredGrants deletes every grant that expired by the given time
+// and returns the number of grants deleted
+func (m *NamespaceManager) PurgeExpiredGrants(ctx context.Context, now time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	purged := int64(0)
+	m.grants.Range(func(key, value interface{}) bool {
+		if value.(*security.Grant).Expired(now) {
+			m.grants.Delete(key)
+			purged++
+		}
+		return true
+	})
+	return purged, nil
+}
+
+// grantRoles returns the roles of the user's unexpired grants in the given
+// namespaces, or in every namespace if none are given
+func (m *NamespaceManager) grantRoles(user uuid.UUID, namespaces ...uuid.UUID) []*memoryRole {
+	memoryRoles := []*memoryRole{}
+	now := time.Now()
+	m.grants.Range(func(key, value interface{}) bool {
+		grant := value.(*security.Grant)
+		if grant.User != user || grant.Expired(now) {
+			return true
+		}
+		if len(namespaces) == 0 {
+			memoryRoles = append(memoryRoles, &memoryRole{grant.Role, grant.Namespace})
+			return true
+		}
+		for _, namespace := range namespaces {
+			if grant.Namespace == namespace {
+				memoryRoles = append(memoryRoles, &memoryRole{grant.Role, grant.Namespace})
+			}
+		}
+		return true
+	})
+	return memoryRoles
+}
+
+func (m *NamespaceManager) rolesIn(namespace, user uuid.UUID, groups []security.Group) []*memoryRole {
+	memoryRoles := []*memoryRole{}
+	if role, ok := m.membership.Load(compoundKey(namespace, user)); ok {
+		memoryRoles = append(memoryRoles, role.(*memoryRole))
+	}
+	for _, group := range groups {
+		if role, ok := m.groupMembership.Load(compoundKey(namespace, group.ID)); ok {
+			memoryRoles = append(memoryRoles, role.(*memoryGroupRole).memoryRole)
+		}
+	}
+	return memoryRoles
+}
+
 // RolesFor is used in gathering all of the roles for both the global and given namespace for
-// a given user
+// a given user, including any roles granted through the groups the user is a member of and
+// any unexpired grants
 func (m *NamespaceManager) RolesFor(ctx context.Context, globalNamespace, namespace, user uuid.UUID) ([]security.NamespaceRole, error) {
-	memoryRoles := []*memoryRole{}
-	globalRole, ok := m.membership.Load(compoundKey(globalNamespace, user))
-	if ok {
-		memoryRoles = append(memoryRoles, globalRole.(*memoryRole))
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	groups, err := m.GroupsFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := []uuid.UUID{globalNamespace}
+	memoryRoles := m.rolesIn(globalNamespace, user, groups)
 	if namespace != globalNamespace {
-		role, ok := m.membership.Load(compoundKey(namespace, user))
-		if ok {
-			memoryRoles = append(memoryRoles, role.(*memoryRole))
-		}
+		namespaces = append(namespaces, namespace)
+		memoryRoles = append(memoryRoles, m.rolesIn(namespace, user, groups)...)
 	}
+	memoryRoles = append(memoryRoles, m.grantRoles(user, namespaces...)...)
 	roles := make([]security.NamespaceRole, len(memoryRoles))
 	for i, memoryRole := range memoryRoles {
 		roles[i] = memoryRole
 	}
 	return roles, nil
 }
+
+// NamespacesFor is used in gathering all of the roles a user holds across every namespace,
+// including any roles granted through the groups the user is a member of and any unexpired
+// grants
+func (m *NamespaceManager) NamespacesFor(ctx context.Context, user uuid.UUID) ([]security.NamespaceRole, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	groups, err := m.GroupsFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	roles := []security.NamespaceRole{}
+	m.membership.Range(func(key, value interface{}) bool {
+		if strings.HasSuffix(key.(string), "|"+user.String()) {
+			roles = append(roles, value.(*memoryRole))
+		}
+		return true
+	})
+	for _, group := range groups {
+		m.groupMembership.Range(func(key, value interface{}) bool {
+			if role := value.(*memoryGroupRole); role.group == group.ID {
+				roles = append(roles, role.memoryRole)
+			}
+			return true
+		})
+	}
+	for _, role := range m.grantRoles(user) {
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// MembersOf returns the memberships of every user and group that holds a role
+// directly in the given namespace, including any unexpired grants
+func (m *NamespaceManager) MembersOf(ctx context.Context, namespace uuid.UUID) ([]security.Membership, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	members := []security.Membership{}
+	prefix := namespace.String() + "|"
+	m.membership.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			members = append(members, security.Membership{
+				Namespace: namespace,
+				Principal: uuid.FromStringOrNil(strings.TrimPrefix(key.(string), prefix)),
+				Kind:      security.PrincipalUser,
+				Role:      value.(*memoryRole).MemoryName,
+			})
+		}
+		return true
+	})
+	m.groupMembership.Range(func(key, value interface{}) bool {
+		if role := value.(*memoryGroupRole); role.MemoryNamespace == namespace {
+			members = append(members, security.Membership{
+				Namespace: namespace,
+				Principal: role.group,
+				Kind:      security.PrincipalGroup,
+				Role:      role.MemoryName,
+			})
+		}
+		return true
+	})
+	now := time.Now()
+	m.grants.Range(func(key, value interface{}) bool {
+		if grant := value.(*security.Grant); grant.Namespace == namespace && !grant.Expired(now) {
+			members = append(members, security.Membership{
+				Namespace: namespace,
+				Principal: grant.User,
+				Kind:      security.PrincipalUser,
+				Role:      grant.Role,
+				ExpiresAt: grant.ExpiresAt,
+			})
+		}
+		return true
+	})
+	return members, nil
+}
@@ -3,9 +3,16 @@ package memory
 import (
 	"testing"
 
+	"github.com/andrewstucki/web-app-tools/go/security"
 	managerTest "github.com/andrewstucki/web-app-tools/go/security/testing"
 )
 
 func TestMemoryManager(t *testing.T) {
 	managerTest.ManagerTest(t, NewNamespaceManager())
 }
+
+func TestMemoryManagerConformance(t *testing.T) {
+	managerTest.ConformanceTest(t, func() security.NamespaceManager {
+		return NewNamespaceManager()
+	})
+}
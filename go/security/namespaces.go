@@ -0,0 +1,118 @@
+package security
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// anyNamespace stands in for a namespace id when checking whether
+// a global policy applies to every namespace, it can never be
+// matched by a literal pattern segment
+const anyNamespace = "\x00namespace"
+
+// NamespaceSet is the set of namespaces a user can act in
+type NamespaceSet struct {
+	// All is set when a global role grants access in every namespace
+	All bool
+	// IDs are the namespaces that the user's roles grant access in
+	IDs []uuid.UUID
+}
+
+// Contains checks whether the set includes the given namespace
+func (s *NamespaceSet) Contains(namespace uuid.UUID) bool {
+	if s.All {
+		return true
+	}
+	for _, id := range s.IDs {
+		if id == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Strings returns the namespace ids as strings, wrap them with
+// pq.Array to pass them as a postgres array in queries such as
+// "namespace_id = ANY($1)"
+func (s *NamespaceSet) Strings() []string {
+	ids := make([]string, len(s.IDs))
+	for i, id := range s.IDs {
+		ids[i] = id.String()
+	}
+	return ids
+}
+
+// Namespaces returns the set of namespaces where the user's policies permit
+// the action on the resource, the resource is relative to each namespace
+func (e *Evaluator) Namespaces(ctx context.Context, action Action, resource Resource) (*NamespaceSet, error) {
+	set := &NamespaceSet{IDs: []uuid.UUID{}}
+	if e.authorizer.namespaceManager == nil {
+		return set, nil
+	}
+	roles, err := e.authorizer.namespacesFor(ctx, e.user)
+	if err != nil {
+		return nil, err
+	}
+
+	globalRoles := []NamespaceRole{}
+	namespaces := []uuid.UUID{}
+	rolesByNamespace := make(map[uuid.UUID][]NamespaceRole)
+	for _, role := range roles {
+		namespace := role.Namespace()
+		if namespace == globalNamespace {
+			globalRoles = append(globalRoles, role)
+			continue
+		}
+		if _, ok := rolesByNamespace[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		rolesByNamespace[namespace] = append(rolesByNamespace[namespace], role)
+	}
+
+	globalPolicies := e.authorizer.roleManager.getPolicies(globalRoles...)
+	all, err := e.allows(ctx, globalPolicies, action, Resource(anyNamespace).Sub(resource))
+	if err != nil {
+		return nil, err
+	}
+	set.All = all
+	for _, namespace := range namespaces {
+		policies := append(e.authorizer.roleManager.getPolicies(rolesByNamespace[namespace]...), globalPolicies...)
+		allowed, err := newEvaluator(e.authorizer, namespace, e.user).allows(ctx, policies, action, NamespacedResource(namespace, resource))
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			set.IDs = append(set.IDs, namespace)
+		}
+	}
+	return set, nil
+}
+
+// NamespaceIDs returns every namespace other than the global one where the user
+// holds a role, whether directly, through a group, or through an unexpired grant
+func (a *Authorizer) NamespaceIDs(ctx context.Context, user uuid.UUID) ([]uuid.UUID, error) {
+	if a.namespaceManager == nil {
+		return []uuid.UUID{}, nil
+	}
+	roles, err := a.namespacesFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return namespaceIDs(roles), nil
+}
+
+// namespaceIDs returns the distinct namespaces other than the global one of the roles
+func namespaceIDs(roles []NamespaceRole) []uuid.UUID {
+	ids := []uuid.UUID{}
+	seen := make(map[uuid.UUID]struct{})
+	for _, role := range roles {
+		namespace := role.Namespace()
+		if _, ok := seen[namespace]; ok || namespace == globalNamespace {
+			continue
+		}
+		seen[namespace] = struct{}{}
+		ids = append(ids, namespace)
+	}
+	return ids
+}
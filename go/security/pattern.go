@@ -0,0 +1,209 @@
+package security
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	patternCache sync.Map
+
+	// ErrInvalidPattern occurs when a resource pattern can't be compiled
+	ErrInvalidPattern = errors.New("invalid resource pattern")
+)
+
+type segmentKind int
+
+const (
+	// literalSegment matches a segment exactly
+	literalSegment segmentKind = iota
+	// paramSegment matches any single segment and binds it to a name
+	paramSegment
+	// wildcardSegment matches any single segment, or everything
+	// that remains when it's the last segment of a pattern
+	wildcardSegment
+	// globSegment matches zero or more segments
+	globSegment
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+// resourcePattern is a compiled resource pattern, patterns are made up of
+// "/" separated segments where each segment is one of:
+//
+//	literal - matches the segment exactly, a leading ":" or "*" can be escaped with "\"
+//	:param  - matches any single segment and binds its value to "param"
+//	*       - matches any single segment, or the rest of the path at the end of a pattern
+//	**      - matches zero or more segments
+type resourcePattern struct {
+	segments  []segment
+	hasParams bool
+}
+
+// Params holds the values bound to the ":param" segments of a pattern
+type Params map[string]string
+
+func compilePattern(pattern string) (*resourcePattern, error) {
+	if stored, ok := patternCache.Load(pattern); ok {
+		return stored.(*resourcePattern), nil
+	}
+
+	compiled := &resourcePattern{}
+	parts := strings.Split(pattern, "/")
+	segments := make([]segment, len(parts))
+	for i, part := range parts {
+		switch {
+		case part == "**":
+			segments[i] = segment{kind: globSegment}
+		case part == "*":
+			segments[i] = segment{kind: wildcardSegment}
+		case strings.HasPrefix(part, ":"):
+			if len(part) == 1 {
+				return nil, ErrInvalidPattern
+			}
+			segments[i] = segment{kind: paramSegment, value: part[1:]}
+			compiled.hasParams = true
+		default:
+			literal, err := unescape(part)
+			if err != nil {
+				return nil, err
+			}
+			segments[i] = segment{kind: literalSegment, value: literal}
+		}
+	}
+
+	compiled.segments = segments
+	patternCache.Store(pattern, compiled)
+	return compiled, nil
+}
+
+func unescape(part string) (string, error) {
+	if !strings.Contains(part, `\`) {
+		return part, nil
+	}
+	var builder strings.Builder
+	escaped := false
+	for _, r := range part {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		builder.WriteRune(r)
+	}
+	if escaped {
+		return "", ErrInvalidPattern
+	}
+	return builder.String(), nil
+}
+
+// match determines whether the path matches the pattern and returns
+// the values bound to any parameters
+func (p *resourcePattern) match(path string) (Params, bool) {
+	var params Params
+	if p.hasParams {
+		params = Params{}
+	}
+	if !matchSegments(p.segments, path, false, params) {
+		return nil, false
+	}
+	return params, true
+}
+
+// cut splits off the first segment of a path, more is false when
+// the segment returned was the last one
+func cut(path string) (part, rest string, more bool) {
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i+1:], true
+	}
+	return path, "", false
+}
+
+// matchSegments walks the path one segment at a time without allocating,
+// done is set once every segment of the path has been consumed
+func matchSegments(segments []segment, path string, done bool, params Params) bool {
+	for i, segment := range segments {
+		if segment.kind == globSegment {
+			rest := segments[i+1:]
+			for {
+				if matchGlobBranch(rest, path, done, params) {
+					return true
+				}
+				if done {
+					return false
+				}
+				_, remaining, more := cut(path)
+				path, done = remaining, !more
+			}
+		}
+
+		if done {
+			return false
+		}
+		part, remaining, more := cut(path)
+		path, done = remaining, !more
+
+		switch segment.kind {
+		case wildcardSegment:
+			if i == len(segments)-1 {
+				return true
+			}
+		case paramSegment:
+			if part == "" {
+				return false
+			}
+			if params != nil {
+				params[segment.value] = part
+			}
+		case literalSegment:
+			if part != segment.value {
+				return false
+			}
+		}
+	}
+	return done
+}
+
+// matchGlobBranch tries one of the ways a glob can consume the path, the
+// parameters are bound to a copy so a failed branch doesn't leave its
+// bindings behind
+func matchGlobBranch(segments []segment, path string, done bool, params Params) bool {
+	if params == nil {
+		return matchSegments(segments, path, done, nil)
+	}
+	branch := make(Params, len(params))
+	for name, value := range params {
+		branch[name] = value
+	}
+	if !matchSegments(segments, path, done, branch) {
+		return false
+	}
+	for name, value := range branch {
+		params[name] = value
+	}
+	return true
+}
+
+// pathMatch determines whether path matches the pattern, it matches
+// on placeholders
+func pathMatch(path, pattern string) bool {
+	compiled, err := compilePattern(pattern)
+	if err != nil {
+		return false
+	}
+	return matchSegments(compiled.segments, path, false, nil)
+}
+
+// pathParams matches the path against the pattern and returns the
+// values bound to any placeholders
+func pathParams(path, pattern string) (Params, bool) {
+	compiled, err := compilePattern(pattern)
+	if err != nil {
+		return nil, false
+	}
+	return compiled.match(path)
+}
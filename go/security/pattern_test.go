@@ -0,0 +1,150 @@
+package security
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		path    string
+		pattern string
+		want    bool
+	}{
+		{"/project/1/robot", "/project/1/robot", true},
+		{"/project/1/robot", "/project/*/robot", true},
+		{"/project/1/2/robot", "/project/*/robot", false},
+		{"/project/1/robot/2", "/project/1/*", true},
+		{"/project/1", "/project/1/*", false},
+		{"/project/1/robot", "/project/**/robot", true},
+		{"/project/robot", "/project/**/robot", true},
+		{"/project/1/2/3/robot", "/project/**/robot", true},
+		{"/project/1/2/3/robots", "/project/**/robot", false},
+		{"/project/1/robot", "**", true},
+		{"/project/1/robot", "/project/**", true},
+		{"/project/:id", `/project/\:id`, true},
+		{"/project/1", `/project/\:id`, false},
+		{"/project.1", "/project.*", false},
+		{"/project//robot", "/project/:id/robot", false},
+	}
+	for _, tt := range tests {
+		t.Run("match-"+tt.path+"-"+tt.pattern, func(t *testing.T) {
+			if have := pathMatch(tt.path, tt.pattern); have != tt.want {
+				t.Errorf("pathMatch() = %v, want %v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternParams(t *testing.T) {
+	params, ok := pathParams("/project/1/robot/2", "/project/:project/**/:robot")
+	if !ok {
+		t.Fatal("expected the path to match")
+	}
+	if want := (Params{"project": "1", "robot": "2"}); !reflect.DeepEqual(params, want) {
+		t.Errorf("pathParams() = %v, want %v", params, want)
+	}
+
+	// only the bindings from the branch that matched are kept
+	params, ok = pathParams("/a/b/edit", "/**/:id/edit")
+	if !ok {
+		t.Fatal("expected the path to match")
+	}
+	if want := (Params{"id": "b"}); !reflect.DeepEqual(params, want) {
+		t.Errorf("pathParams() = %v, want %v", params, want)
+	}
+}
+
+func TestPatternInvalid(t *testing.T) {
+	for _, pattern := range []string{"/project/:", `/project/\`} {
+		if _, err := compilePattern(pattern); err != ErrInvalidPattern {
+			t.Errorf("compilePattern(%q) = %v, want %v", pattern, err, ErrInvalidPattern)
+		}
+	}
+}
+
+func TestConditions(t *testing.T) {
+	ctx := context.Background()
+	owner := uuid.NewV4()
+	role := Role{"owner", []Policy{{Resource: "projects/:owner", Action: ActionUpdate, Condition: "is-owner"}}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	authorizer.MustRegister(role)
+	authorizer.RegisterCondition("is-owner", func(ctx context.Context, evaluation *Evaluation) (bool, error) {
+		return evaluation.Params["owner"] == evaluation.User.String(), nil
+	})
+	authorizer.SetRole(ctx, role, owner)
+
+	allowed, err := authorizer.WithUser(owner).Can(ctx, ActionUpdate, Resource("projects").Sub(Resource(owner.String())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected the owner to be allowed")
+	}
+	allowed, err = authorizer.WithUser(owner).Can(ctx, ActionUpdate, Resource("projects").Sub(Resource(uuid.NewV4().String())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("expected a non-owner to be denied")
+	}
+}
+
+// regexPathMatch is the regex based matcher that the segment matcher
+// replaced, it's kept around to benchmark against
+var (
+	regexCache sync.Map
+	tokenizer  = regexp.MustCompile(`(.*):[^/]+(.*)`)
+)
+
+func regexPathMatch(path, pattern string) bool {
+	var regex *regexp.Regexp
+	stored, ok := regexCache.Load(pattern)
+	if ok {
+		regex = stored.(*regexp.Regexp)
+	} else {
+		regexPattern := strings.Replace(pattern, "/*", "/.*", -1)
+		for {
+			if !strings.Contains(regexPattern, "/:") {
+				break
+			}
+			regexPattern = tokenizer.ReplaceAllString(regexPattern, "$1[^/]+$2")
+		}
+		regex = regexp.MustCompile("^" + regexPattern + "$")
+		regexCache.Store(pattern, regex)
+	}
+
+	return regex.MatchString(path)
+}
+
+var benchmarkPatterns = []struct {
+	path    string
+	pattern string
+}{
+	{"/project/1/robot", "/project/1/robot"},
+	{"/project/1/robot", "/project/:pid/robot"},
+	{"/project/1/robot/2/arm", "/project/1/*"},
+	{"/project/1/robot/2/arm", "/project/:pid/robot/:rid/leg"},
+}
+
+func BenchmarkSegmentMatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, bench := range benchmarkPatterns {
+			pathMatch(bench.path, bench.pattern)
+		}
+	}
+}
+
+func BenchmarkRegexMatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, bench := range benchmarkPatterns {
+			regexPathMatch(bench.path, bench.pattern)
+		}
+	}
+}
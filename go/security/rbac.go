@@ -1,16 +1,19 @@
 package security
 
 import (
+	"context"
+	"sort"
 	"strings"
 	"sync"
 
+	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 )
 
 // Role is an association of a name and a set of policies
 type Role struct {
-	Name     string
-	Policies []Policy
+	Name     string   `json:"name" yaml:"name"`
+	Policies []Policy `json:"policies" yaml:"policies"`
 }
 
 func (r Role) policiesFor(namespace uuid.UUID) []Policy {
@@ -19,23 +22,46 @@ func (r Role) policiesFor(namespace uuid.UUID) []Policy {
 	}
 	policies := make([]Policy, len(r.Policies))
 	for i, policy := range r.Policies {
-		sub := Resource(namespace.String()).Sub(policy.Resource)
-		policies[i] = Policy{sub, policy.Action}
+		policies[i] = Policy{
+			Resource:  NamespacedResource(namespace, policy.Resource),
+			Action:    policy.Action,
+			Condition: policy.Condition,
+		}
 	}
 	return policies
 }
 
-// Policy associates a resource with an action
+// Policy associates a resource with an action, if a condition is
+// given the policy only applies when the registered condition
+// of the same name passes
 type Policy struct {
-	Resource `json:"resource"`
-	Action   `json:"action"`
+	Resource  `json:"resource" yaml:"resource"`
+	Action    `json:"action" yaml:"action"`
+	Condition string `json:"condition,omitempty" yaml:"condition,omitempty"`
 }
 
 // String returns the representation of a policy as a string
 func (p Policy) String() string {
+	if p.Condition != "" {
+		return p.Resource.String() + "|" + p.Action.String() + "|" + p.Condition
+	}
 	return p.Resource.String() + "|" + p.Action.String()
 }
 
+// Evaluation describes the check a condition is being asked about
+type Evaluation struct {
+	User      uuid.UUID
+	Namespace uuid.UUID
+	Action    Action
+	Resource  Resource
+	// Params holds the values bound to the ":param" segments
+	// of the policy's resource pattern
+	Params Params
+}
+
+// Condition further restricts a policy once its resource and action match
+type Condition func(ctx context.Context, evaluation *Evaluation) (bool, error)
+
 // Resource represents what is trying to be accessed
 type Resource string
 
@@ -55,6 +81,15 @@ func (r Resource) Sub(resources ...Resource) Resource {
 	return Resource(strings.Join(elements, "/"))
 }
 
+// NamespacedResource returns the resource as it is addressed inside of
+// the given namespace, resources in the global namespace are left as is
+func NamespacedResource(namespace uuid.UUID, resource Resource) Resource {
+	if namespace == globalNamespace {
+		return resource
+	}
+	return Resource(namespace.String()).Sub(resource)
+}
+
 // Action represents what the user is trying to do
 type Action string
 
@@ -64,22 +99,126 @@ func (a Action) String() string {
 }
 
 type roleManager struct {
-	mutex sync.RWMutex
-	roles map[string]Role
+	mutex      sync.RWMutex
+	roles      map[string]Role
+	conditions map[string]Condition
+	actions    map[Action]struct{}
 }
 
 func newRoleManager() *roleManager {
+	actions := make(map[Action]struct{})
+	for _, action := range DefaultActions {
+		actions[action] = struct{}{}
+	}
 	return &roleManager{
-		roles: make(map[string]Role),
+		roles:      make(map[string]Role),
+		conditions: make(map[string]Condition),
+		actions:    actions,
+	}
+}
+
+func (m *roleManager) registerCondition(name string, condition Condition) {
+	m.mutex.Lock()
+	m.conditions[name] = condition
+	m.mutex.Unlock()
+}
+
+// evaluate runs the named condition, unknown conditions never pass
+func (m *roleManager) evaluate(ctx context.Context, name string, evaluation *Evaluation) (bool, error) {
+	m.mutex.RLock()
+	condition, ok := m.conditions[name]
+	m.mutex.RUnlock()
+	if !ok {
+		return false, nil
 	}
+	return condition(ctx, evaluation)
 }
 
-func (m *roleManager) register(roles ...Role) {
+// register validates and adds the roles, if any role references an
+// unknown action or an invalid resource pattern none of them are added
+func (m *roleManager) register(roles ...Role) error {
 	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.validate(roles...); err != nil {
+		return err
+	}
 	for _, role := range roles {
 		m.roles[role.Name] = role
 	}
-	m.mutex.Unlock()
+	return nil
+}
+
+// validate checks the roles against the action catalog, it must
+// be called with the mutex held
+func (m *roleManager) validate(roles ...Role) error {
+	for _, role := range roles {
+		for _, policy := range role.Policies {
+			if !m.knownAction(policy.Action) {
+				return errors.Wrapf(ErrUnknownAction, "role %q references %q", role.Name, policy.Action)
+			}
+			if _, err := compilePattern(policy.Resource.String()); err != nil {
+				return errors.Wrapf(err, "role %q references %q", role.Name, policy.Resource)
+			}
+		}
+	}
+	return nil
+}
+
+// replace atomically registers the actions and roles and removes the
+// stale roles, if anything fails validation nothing is changed
+func (m *roleManager) replace(actions []Action, roles []Role, stale []string) error {
+	for _, action := range actions {
+		if err := validateActionName(action); err != nil {
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	added := []Action{}
+	for _, action := range actions {
+		if _, ok := m.actions[action]; !ok {
+			m.actions[action] = struct{}{}
+			added = append(added, action)
+		}
+	}
+	if err := m.validate(roles...); err != nil {
+		for _, action := range added {
+			delete(m.actions, action)
+		}
+		return err
+	}
+	for _, name := range stale {
+		delete(m.roles, name)
+	}
+	for _, role := range roles {
+		m.roles[role.Name] = role
+	}
+	return nil
+}
+
+func (m *roleManager) getRole(name string) (Role, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	role, ok := m.roles[name]
+	return role, ok
+}
+
+func (m *roleManager) getRoles() []Role {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	roles := make([]Role, 0, len(m.roles))
+	for _, role := range m.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles
 }
 
 func (m *roleManager) getPolicies(roles ...NamespaceRole) []Policy {
@@ -0,0 +1,78 @@
+package security
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	scopeKey = "scope-context-key"
+)
+
+// WithScope returns a context that limits every decision made with it to what the
+// scope's policies permit, a user's policies only apply where the scope allows them,
+// e.g. for requests made with an api token that was given a subset of its owner's policies
+func WithScope(ctx context.Context, scope []Policy) context.Context {
+	return context.WithValue(ctx, &scopeKey, scope)
+}
+
+// ScopeFrom returns the scope set on the context with WithScope
+func ScopeFrom(ctx context.Context) ([]Policy, bool) {
+	scope := ctx.Value(&scopeKey)
+	if scope == nil {
+		return nil, false
+	}
+	return scope.([]Policy), true
+}
+
+// PoliciesFor returns the policies of every role the user holds across all namespaces,
+// the resources of namespaced policies are scoped to their namespace
+func (a *Authorizer) PoliciesFor(ctx context.Context, user uuid.UUID) ([]Policy, error) {
+	if a.namespaceManager == nil {
+		return []Policy{}, nil
+	}
+	roles, err := a.namespacesFor(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return a.roleManager.getPolicies(roles...), nil
+}
+
+// CanDelegate checks whether every policy in the scope is one the user already
+// holds, a held policy covers a scoped one with the same resource, or any resource
+// for ResourceAll, when its action matches the scoped action and it either has no
+// condition or the same condition
+func (a *Authorizer) CanDelegate(ctx context.Context, user uuid.UUID, scope []Policy) (bool, error) {
+	policies, err := a.PoliciesFor(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	for _, scoped := range scope {
+		if !covers(policies, scoped) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CanGrant checks whether the user can delegate every policy of the role as it
+// applies in the namespace, so that nobody can grant more than they hold
+func (a *Authorizer) CanGrant(ctx context.Context, user uuid.UUID, role Role, namespace uuid.UUID) (bool, error) {
+	return a.CanDelegate(ctx, user, role.policiesFor(namespace))
+}
+
+func covers(policies []Policy, scoped Policy) bool {
+	for _, policy := range policies {
+		if policy.Resource != ResourceAll && policy.Resource != scoped.Resource {
+			continue
+		}
+		if !policy.Action.Matches(scoped.Action) {
+			continue
+		}
+		if policy.Condition == "" || policy.Condition == scoped.Condition {
+			return true
+		}
+	}
+	return false
+}
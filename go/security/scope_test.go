@@ -0,0 +1,74 @@
+package security
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestScope(t *testing.T) {
+	ctx := context.Background()
+	editor := Role{Name: "editor", Policies: []Policy{
+		{Resource: "projects/*", Action: ActionAll},
+		{Resource: "reports", Action: ActionRead},
+	}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	authorizer.MustRegister(editor)
+
+	user, namespace := uuid.NewV4(), uuid.NewV4()
+	if err := authorizer.SetRole(ctx, editor, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizer.AddUserToNamespace(ctx, editor, namespace, user); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		scope    []Policy
+		expected bool
+	}{
+		{[]Policy{{Resource: "projects/*", Action: ActionRead}}, true},
+		{[]Policy{{Resource: "projects/*", Action: ActionRead}, {Resource: "reports", Action: ActionRead}}, true},
+		{[]Policy{{Resource: NamespacedResource(namespace, "reports"), Action: ActionRead}}, true},
+		{[]Policy{{Resource: "reports", Action: ActionUpdate}}, false},
+		{[]Policy{{Resource: ResourceAll, Action: ActionRead}}, false},
+		{[]Policy{{Resource: NamespacedResource(uuid.NewV4(), "reports"), Action: ActionRead}}, false},
+	} {
+		delegated, err := authorizer.CanDelegate(ctx, user, test.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delegated != test.expected {
+			t.Errorf("expected delegating %v to be %v", test.scope, test.expected)
+		}
+	}
+
+	scoped := WithScope(ctx, []Policy{{Resource: "projects/*", Action: ActionRead}})
+	evaluator := authorizer.WithUser(user)
+	for _, test := range []struct {
+		ctx      context.Context
+		action   Action
+		resource Resource
+		expected bool
+	}{
+		{ctx, ActionUpdate, "projects/1", true},
+		{scoped, ActionRead, "projects/1", true},
+		{scoped, ActionUpdate, "projects/1", false},
+		{scoped, ActionRead, "reports", false},
+	} {
+		allowed, err := evaluator.Can(test.ctx, test.action, test.resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != test.expected {
+			t.Errorf("expected %s on %s to be %v", test.action, test.resource, test.expected)
+		}
+	}
+
+	// the scope can only narrow what the user's own policies allow
+	broad := WithScope(ctx, []Policy{{Resource: ResourceAll, Action: ActionAll}})
+	if allowed, err := evaluator.Can(broad, ActionDelete, "users/1"); err != nil || allowed {
+		t.Errorf("expected a broad scope not to grant anything, got %v %v", allowed, err)
+	}
+}
@@ -0,0 +1,195 @@
+package security
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrSimulation is returned when trying to change role assignments through a simulation
+	ErrSimulation = errors.New("role assignments can't be changed through a simulation")
+)
+
+// Assignment is a hypothetical role held by a user in a namespace
+type Assignment struct {
+	Namespace uuid.UUID
+	User      uuid.UUID
+	Role      Role
+}
+
+// Simulate returns an authorizer that evaluates permissions as if the given
+// assignments were held on top of the stored ones, the simulation shares the
+// authorizer's roles and conditions but never persists, audits, or reads
+// roles memoized in the request
+func (a *Authorizer) Simulate(assignments ...Assignment) *Authorizer {
+	return &Authorizer{
+		namespaceManager: &simulatedManager{
+			base:        a.namespaceManager,
+			assignments: assignments,
+		},
+		roleManager: a.roleManager,
+		simulated:   true,
+	}
+}
+
+// simulatedManager layers hypothetical assignments over a namespace manager
+type simulatedManager struct {
+	base        NamespaceManager
+	assignments []Assignment
+}
+
+// assigned returns the user's roles in the simulation in the given namespaces,
+// or in every namespace if none are given
+func (m *simulatedManager) assigned(user uuid.UUID, namespaces ...uuid.UUID) []NamespaceRole {
+	roles := []NamespaceRole{}
+	for _, assignment := range m.assignments {
+		if assignment.User != user {
+			continue
+		}
+		role := &membershipRole{&Membership{
+			Namespace: assignment.Namespace,
+			Principal: assignment.User,
+			Kind:      PrincipalUser,
+			Role:      assignment.Role.Name,
+		}}
+		if len(namespaces) == 0 {
+			roles = append(roles, role)
+			continue
+		}
+		for _, namespace := range namespaces {
+			if assignment.Namespace == namespace {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// RolesFor returns the stored roles of the user along with any assigned in the simulation
+func (m *simulatedManager) RolesFor(ctx context.Context, globalNamespace, namespace, user uuid.UUID) ([]NamespaceRole, error) {
+	roles := []NamespaceRole{}
+	if m.base != nil {
+		stored, err := m.base.RolesFor(ctx, globalNamespace, namespace, user)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, stored...)
+	}
+	namespaces := []uuid.UUID{globalNamespace}
+	if namespace != globalNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	return append(roles, m.assigned(user, namespaces...)...), nil
+}
+
+// NamespacesFor returns the stored roles of the user across every namespace along with
+// any assigned in the simulation
+func (m *simulatedManager) NamespacesFor(ctx context.Context, user uuid.UUID) ([]NamespaceRole, error) {
+	roles := []NamespaceRole{}
+	if m.base != nil {
+		stored, err := m.base.NamespacesFor(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, stored...)
+	}
+	return append(roles, m.assigned(user)...), nil
+}
+
+// MembersOf returns the stored memberships of the namespace along with any assigned in
+// the simulation
+func (m *simulatedManager) MembersOf(ctx context.Context, namespace uuid.UUID) ([]Membership, error) {
+	members := []Membership{}
+	if m.base != nil {
+		stored, err := m.base.MembersOf(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, stored...)
+	}
+	for _, assignment := range m.assignments {
+		if assignment.Namespace == namespace {
+			members = append(members, Membership{
+				Namespace: namespace,
+				Principal: assignment.User,
+				Kind:      PrincipalUser,
+				Role:      assignment.Role.Name,
+			})
+		}
+	}
+	return members, nil
+}
+
+// GroupsFor returns the stored groups of the user
+func (m *simulatedManager) GroupsFor(ctx context.Context, user uuid.UUID) ([]Group, error) {
+	if m.base != nil {
+		return m.base.GroupsFor(ctx, user)
+	}
+	return []Group{}, nil
+}
+
+// GrantsFor returns the stored grants of the user
+func (m *simulatedManager) GrantsFor(ctx context.Context, user uuid.UUID) ([]Grant, error) {
+	if m.base != nil {
+		return m.base.GrantsFor(ctx, user)
+	}
+	return []Grant{}, nil
+}
+
+// AddUserToNamespace always fails with ErrSimulation
+func (m *simulatedManager) AddUserToNamespace(ctx context.Context, role Role, id, user uuid.UUID) error {
+	return ErrSimulation
+}
+
+// RemoveUserFromNamespace always fails with ErrSimulation
+func (m *simulatedManager) RemoveUserFromNamespace(ctx context.Context, id, user uuid.UUID) error {
+	return ErrSimulation
+}
+
+// CreateGroup always fails with ErrSimulation
+func (m *simulatedManager) CreateGroup(ctx context.Context, group Group) error {
+	return ErrSimulation
+}
+
+// DeleteGroup always fails with ErrSimulation
+func (m *simulatedManager) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	return ErrSimulation
+}
+
+// AddUserToGroup always fails with ErrSimulation
+func (m *simulatedManager) AddUserToGroup(ctx context.Context, group, user uuid.UUID) error {
+	return ErrSimulation
+}
+
+// RemoveUserFromGroup always fails with ErrSimulation
+func (m *simulatedManager) RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error {
+	return ErrSimulation
+}
+
+// AddGroupToNamespace always fails with ErrSimulation
+func (m *simulatedManager) AddGroupToNamespace(ctx context.Context, role Role, id, group uuid.UUID) error {
+	return ErrSimulation
+}
+
+// RemoveGroupFromNamespace always fails with ErrSimulation
+func (m *simulatedManager) RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error {
+	return ErrSimulation
+}
+
+// AddGrant always fails with ErrSimulation
+func (m *simulatedManager) AddGrant(ctx context.Context, grant Grant) error {
+	return ErrSimulation
+}
+
+// RemoveGrant always fails with ErrSimulation
+func (m *simulatedManager) RemoveGrant(ctx context.Context, id, user uuid.UUID, role string) error {
+	return ErrSimulation
+}
+
+// PurgeExpiredGrants always fails with ErrSimulation
+func (m *simulatedManager) PurgeExpiredGrants(ctx context.Context, now time.Time) (int64, error) {
+	return 0, ErrSimulation
+}
@@ -0,0 +1,54 @@
+package security
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestSimulate(t *testing.T) {
+	ctx := WithRoleCache(context.Background())
+	admin := Role{Name: "admin", Policies: []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	authorizer.MustRegister(admin)
+
+	namespace, user := uuid.NewV4(), uuid.NewV4()
+	resource := NamespacedResource(namespace, "foo")
+
+	// warm the request cache so the simulation has to skip it
+	allowed, err := authorizer.WithNamespaceAndUser(namespace, user).Can(ctx, ActionRead, resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("expected the user to be denied access")
+	}
+
+	simulation := authorizer.Simulate(Assignment{Namespace: namespace, User: user, Role: admin})
+	allowed, err = simulation.WithNamespaceAndUser(namespace, user).Can(ctx, ActionRead, resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected the simulated assignment to allow access")
+	}
+	members, err := simulation.WhoCan(ctx, namespace, ActionRead, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Principal != user {
+		t.Errorf("expected the simulated member to be returned, got %+v", members)
+	}
+
+	if err := simulation.SetRole(ctx, admin, user); err != ErrSimulation {
+		t.Errorf("expected changes through a simulation to fail, got %v", err)
+	}
+	allowed, err = authorizer.WithNamespaceAndUser(namespace, user).Can(ctx, ActionRead, resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("expected the simulation not to persist")
+	}
+}
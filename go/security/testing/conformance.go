@@ -0,0 +1,324 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	conformanceGlobal = uuid.UUID{}
+	conformanceAdmin  = security.Role{Name: "admin"}
+	conformanceViewer = security.Role{Name: "viewer"}
+)
+
+// ConformanceCase is a single behavior that every manager must implement
+type ConformanceCase struct {
+	Name string
+	Test func(t *testing.T, manager security.NamespaceManager)
+}
+
+// ConformanceCases is the specification that NamespaceManager
+// implementations are checked against
+var ConformanceCases = []ConformanceCase{
+	{"membership upserts", testMembershipUpserts},
+	{"idempotent removal", testIdempotentRemoval},
+	{"namespace isolation", testNamespaceIsolation},
+	{"group upserts", testGroupUpserts},
+	{"group isolation", testGroupIsolation},
+	{"grant expiry", testGrantExpiry},
+	{"many users", testManyUsers},
+	{"concurrent writes", testConcurrentWrites},
+	{"context cancellation", testContextCancellation},
+}
+
+// ConformanceTest runs every conformance case as a subtest, factory
+// is called once per case and must return a manager with no stored
+// memberships, groups or grants
+func ConformanceTest(t *testing.T, factory func() security.NamespaceManager) {
+	for _, conformanceCase := range ConformanceCases {
+		conformanceCase := conformanceCase
+		t.Run(conformanceCase.Name, func(t *testing.T) {
+			conformanceCase.Test(t, factory())
+		})
+	}
+}
+
+func roleNames(roles []security.NamespaceRole) []string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = role.Namespace().String() + "|" + role.Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+func roleName(namespace uuid.UUID, role security.Role) string {
+	return namespace.String() + "|" + role.Name
+}
+
+func testMembershipUpserts(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace, user := uuid.NewV4(), uuid.NewV4()
+
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceViewer, namespace, user))
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceAdmin, namespace, user))
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceAdmin, namespace, user))
+
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(namespace, conformanceAdmin)}, roleNames(roles))
+	members, err := manager.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	require.Equal(t, conformanceAdmin.Name, members[0].Role)
+}
+
+func testIdempotentRemoval(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace, user, group := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+
+	// removing things that were never added succeeds
+	require.NoError(t, manager.RemoveUserFromNamespace(ctx, namespace, user))
+	require.NoError(t, manager.RemoveUserFromGroup(ctx, group, user))
+	require.NoError(t, manager.RemoveGroupFromNamespace(ctx, namespace, group))
+	require.NoError(t, manager.RemoveGrant(ctx, namespace, user, conformanceAdmin.Name))
+	require.NoError(t, manager.DeleteGroup(ctx, group))
+
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceAdmin, namespace, user))
+	require.NoError(t, manager.RemoveUserFromNamespace(ctx, namespace, user))
+	require.NoError(t, manager.RemoveUserFromNamespace(ctx, namespace, user))
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+
+	purged, err := manager.PurgeExpiredGrants(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), purged)
+}
+
+func testNamespaceIsolation(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	first, second, user, other := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceAdmin, first, user))
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceViewer, conformanceGlobal, user))
+	require.NoError(t, manager.AddUserToNamespace(ctx, conformanceAdmin, second, other))
+
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, first, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(conformanceGlobal, conformanceViewer), roleName(first, conformanceAdmin)}, roleNames(roles))
+
+	// global roles apply everywhere, namespaced roles only in their namespace
+	roles, err = manager.RolesFor(ctx, conformanceGlobal, second, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(conformanceGlobal, conformanceViewer)}, roleNames(roles))
+
+	// asking for the global namespace doesn't return global roles twice
+	roles, err = manager.RolesFor(ctx, conformanceGlobal, conformanceGlobal, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(conformanceGlobal, conformanceViewer)}, roleNames(roles))
+
+	roles, err = manager.NamespacesFor(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(conformanceGlobal, conformanceViewer), roleName(first, conformanceAdmin)}, roleNames(roles))
+
+	members, err := manager.MembersOf(ctx, second)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	require.Equal(t, other, members[0].Principal)
+
+	// removing a namespaced role leaves the global one alone
+	require.NoError(t, manager.RemoveUserFromNamespace(ctx, first, user))
+	roles, err = manager.NamespacesFor(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(conformanceGlobal, conformanceViewer)}, roleNames(roles))
+}
+
+func testGroupUpserts(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace, user := uuid.NewV4(), uuid.NewV4()
+	group := security.Group{ID: uuid.NewV4(), Name: "engineering"}
+
+	require.NoError(t, manager.CreateGroup(ctx, group))
+	group.Name = "platform"
+	require.NoError(t, manager.CreateGroup(ctx, group))
+	require.NoError(t, manager.AddUserToGroup(ctx, group.ID, user))
+	require.NoError(t, manager.AddUserToGroup(ctx, group.ID, user))
+	require.NoError(t, manager.AddGroupToNamespace(ctx, conformanceViewer, namespace, group.ID))
+	require.NoError(t, manager.AddGroupToNamespace(ctx, conformanceAdmin, namespace, group.ID))
+
+	groups, err := manager.GroupsFor(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, []security.Group{group}, groups)
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(namespace, conformanceAdmin)}, roleNames(roles))
+}
+
+func testGroupIsolation(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace, member, outsider := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	group, other := uuid.NewV4(), uuid.NewV4()
+
+	require.NoError(t, manager.CreateGroup(ctx, security.Group{ID: group, Name: "group"}))
+	require.NoError(t, manager.CreateGroup(ctx, security.Group{ID: other, Name: "other"}))
+	require.NoError(t, manager.AddUserToGroup(ctx, group, member))
+	require.NoError(t, manager.AddUserToGroup(ctx, other, outsider))
+	require.NoError(t, manager.AddGroupToNamespace(ctx, conformanceAdmin, namespace, group))
+
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, outsider)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+
+	// deleting one group leaves the other group's members alone
+	require.NoError(t, manager.DeleteGroup(ctx, group))
+	roles, err = manager.RolesFor(ctx, conformanceGlobal, namespace, member)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+	groups, err := manager.GroupsFor(ctx, outsider)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+}
+
+func testGrantExpiry(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace, user := uuid.NewV4(), uuid.NewV4()
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+
+	require.NoError(t, manager.AddGrant(ctx, security.Grant{Namespace: namespace, User: user, Role: conformanceAdmin.Name, ExpiresAt: &past}))
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+
+	// re-granting an expired grant replaces it
+	require.NoError(t, manager.AddGrant(ctx, security.Grant{Namespace: namespace, User: user, Role: conformanceAdmin.Name, ExpiresAt: &future}))
+	roles, err = manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+	require.NoError(t, err)
+	require.Equal(t, []string{roleName(namespace, conformanceAdmin)}, roleNames(roles))
+	purged, err := manager.PurgeExpiredGrants(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), purged)
+
+	// grants without an expiry are never purged
+	require.NoError(t, manager.AddGrant(ctx, security.Grant{Namespace: conformanceGlobal, User: user, Role: conformanceViewer.Name}))
+	purged, err = manager.PurgeExpiredGrants(ctx, future.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), purged)
+	grants, err := manager.GrantsFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	require.Nil(t, grants[0].ExpiresAt)
+}
+
+func testManyUsers(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace := uuid.NewV4()
+	users := make([]uuid.UUID, 250)
+	for i := range users {
+		users[i] = uuid.NewV4()
+		role := conformanceViewer
+		if i%2 == 0 {
+			role = conformanceAdmin
+		}
+		require.NoError(t, manager.AddUserToNamespace(ctx, role, namespace, users[i]))
+	}
+
+	members, err := manager.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Len(t, members, len(users))
+	for i, user := range users {
+		role := conformanceViewer
+		if i%2 == 0 {
+			role = conformanceAdmin
+		}
+		roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+		require.NoError(t, err)
+		require.Equal(t, []string{roleName(namespace, role)}, roleNames(roles))
+	}
+}
+
+func testConcurrentWrites(t *testing.T, manager security.NamespaceManager) {
+	ctx := context.Background()
+	namespace, contended := uuid.NewV4(), uuid.NewV4()
+	users := make([]uuid.UUID, 50)
+	for i := range users {
+		users[i] = uuid.NewV4()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*len(users))
+	for i := range users {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			role := conformanceViewer
+			if i%2 == 0 {
+				role = conformanceAdmin
+			}
+			errs <- manager.AddUserToNamespace(ctx, role, namespace, users[i])
+			errs <- manager.AddUserToNamespace(ctx, role, namespace, contended)
+			_, err := manager.RolesFor(ctx, conformanceGlobal, namespace, users[i])
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	members, err := manager.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Len(t, members, len(users)+1)
+	// the last write to the contended user wins, but only one role is kept
+	roles, err := manager.RolesFor(ctx, conformanceGlobal, namespace, contended)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+}
+
+func testContextCancellation(t *testing.T, manager security.NamespaceManager) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	namespace, user := uuid.NewV4(), uuid.NewV4()
+
+	calls := map[string]func() error{
+		"AddUserToNamespace": func() error {
+			return manager.AddUserToNamespace(ctx, conformanceAdmin, namespace, user)
+		},
+		"AddGrant": func() error {
+			return manager.AddGrant(ctx, security.Grant{Namespace: namespace, User: user, Role: conformanceAdmin.Name})
+		},
+		"CreateGroup": func() error {
+			return manager.CreateGroup(ctx, security.Group{ID: uuid.NewV4(), Name: "group"})
+		},
+		"RolesFor": func() error {
+			_, err := manager.RolesFor(ctx, conformanceGlobal, namespace, user)
+			return err
+		},
+		"NamespacesFor": func() error {
+			_, err := manager.NamespacesFor(ctx, user)
+			return err
+		},
+		"MembersOf": func() error {
+			_, err := manager.MembersOf(ctx, namespace)
+			return err
+		},
+	}
+	for name, call := range calls {
+		require.Error(t, call(), fmt.Sprintf("%s should fail with a cancelled context", name))
+	}
+
+	// nothing was written with the cancelled context
+	roles, err := manager.RolesFor(context.Background(), conformanceGlobal, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+}
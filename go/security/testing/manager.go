@@ -3,6 +3,7 @@ package testing
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/andrewstucki/web-app-tools/go/security"
 
@@ -11,14 +12,13 @@ import (
 )
 
 // ManagerTest is a simple smoke test to make
-// sure that a manager actually works
+// sure that a manager actually works, see
+// ConformanceTest for the full specification
 func ManagerTest(t *testing.T, manager security.NamespaceManager) {
-	adminRole := security.Role{"admin", []security.Policy{
-		{security.ResourceAll, security.ActionAll},
+	adminRole := security.Role{Name: "admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
 	}}
-	security.Register([]security.Role{
-		adminRole,
-	}...)
+	require.NoError(t, security.Register(adminRole))
 
 	globalNamespace := uuid.UUID{}
 	namespace := uuid.NewV4()
@@ -38,6 +38,17 @@ func ManagerTest(t *testing.T, manager security.NamespaceManager) {
 	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
 	require.NoError(t, err)
 	require.Len(t, roles, 2)
+	roles, err = manager.NamespacesFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	members, err := manager.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Equal(t, []security.Membership{{
+		Namespace: namespace,
+		Principal: user,
+		Kind:      security.PrincipalUser,
+		Role:      "admin",
+	}}, members)
 
 	err = manager.RemoveUserFromNamespace(ctx, namespace, user)
 	require.NoError(t, err)
@@ -50,5 +61,156 @@ func ManagerTest(t *testing.T, manager security.NamespaceManager) {
 	err = manager.RemoveUserFromNamespace(ctx, globalNamespace, user)
 	require.NoError(t, err)
 	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+
+	groupTest(t, manager, adminRole)
+	grantTest(t, manager)
+}
+
+func groupTest(t *testing.T, manager security.NamespaceManager, adminRole security.Role) {
+	globalNamespace := uuid.UUID{}
+	namespace := uuid.NewV4()
+	user := uuid.NewV4()
+	group := security.Group{ID: uuid.NewV4(), Name: "operators"}
+	ctx := context.Background()
+
+	err := manager.CreateGroup(ctx, group)
+	require.NoError(t, err)
+	err = manager.AddGroupToNamespace(ctx, adminRole, namespace, group.ID)
+	require.NoError(t, err)
+
+	// roles of a group don't apply until the user is a member
+	roles, err := manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+
+	err = manager.AddUserToGroup(ctx, group.ID, user)
+	require.NoError(t, err)
+	groups, err := manager.GroupsFor(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, []security.Group{group}, groups)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	require.Equal(t, "admin", roles[0].Name())
+	require.Equal(t, namespace, roles[0].Namespace())
+	roles, err = manager.NamespacesFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	require.Equal(t, namespace, roles[0].Namespace())
+
+	members, err := manager.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Equal(t, []security.Membership{{
+		Namespace: namespace,
+		Principal: group.ID,
+		Kind:      security.PrincipalGroup,
+		Role:      "admin",
+	}}, members)
+
+	// group roles are merged with the user's own roles
+	err = manager.AddUserToNamespace(ctx, adminRole, globalNamespace, user)
+	require.NoError(t, err)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	err = manager.RemoveUserFromNamespace(ctx, globalNamespace, user)
+	require.NoError(t, err)
+
+	group.Name = "renamed"
+	err = manager.CreateGroup(ctx, group)
+	require.NoError(t, err)
+	groups, err = manager.GroupsFor(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, []security.Group{group}, groups)
+
+	err = manager.RemoveGroupFromNamespace(ctx, namespace, group.ID)
+	require.NoError(t, err)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
 	require.Len(t, roles, 0)
+
+	err = manager.AddGroupToNamespace(ctx, adminRole, globalNamespace, group.ID)
+	require.NoError(t, err)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	require.Equal(t, globalNamespace, roles[0].Namespace())
+
+	err = manager.RemoveUserFromGroup(ctx, group.ID, user)
+	require.NoError(t, err)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+
+	err = manager.AddUserToGroup(ctx, group.ID, user)
+	require.NoError(t, err)
+	err = manager.DeleteGroup(ctx, group.ID)
+	require.NoError(t, err)
+	groups, err = manager.GroupsFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, groups, 0)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 0)
+}
+
+func grantTest(t *testing.T, manager security.NamespaceManager) {
+	globalNamespace := uuid.UUID{}
+	namespace := uuid.NewV4()
+	user := uuid.NewV4()
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	err := manager.AddUserToNamespace(ctx, security.Role{Name: "viewer"}, namespace, user)
+	require.NoError(t, err)
+	err = manager.AddGrant(ctx, security.Grant{
+		Namespace: namespace,
+		User:      user,
+		Role:      "admin",
+		Reason:    "incident",
+		ExpiresAt: &future,
+	})
+	require.NoError(t, err)
+	err = manager.AddGrant(ctx, security.Grant{
+		Namespace: globalNamespace,
+		User:      user,
+		Role:      "admin",
+		ExpiresAt: &past,
+	})
+	require.NoError(t, err)
+
+	// grants stack on top of the membership and expired grants are ignored
+	roles, err := manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	roles, err = manager.NamespacesFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	members, err := manager.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	for _, member := range members {
+		if member.Role == "admin" {
+			require.NotNil(t, member.ExpiresAt)
+		}
+	}
+	grants, err := manager.GrantsFor(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	require.Equal(t, "incident", grants[0].Reason)
+	require.WithinDuration(t, future, *grants[0].ExpiresAt, time.Second)
+
+	purged, err := manager.PurgeExpiredGrants(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), purged)
+
+	err = manager.RemoveGrant(ctx, namespace, user, "admin")
+	require.NoError(t, err)
+	roles, err = manager.RolesFor(ctx, globalNamespace, namespace, user)
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	require.Equal(t, "viewer", roles[0].Name())
 }
@@ -0,0 +1,55 @@
+package security
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// membershipRole adapts a membership to the NamespaceRole interface
+type membershipRole struct {
+	membership *Membership
+}
+
+// Namespace is the uuid of the namespace the role is associated with
+func (r *membershipRole) Namespace() uuid.UUID {
+	return r.membership.Namespace
+}
+
+// Name is the name of the role initially registered with the global manager
+func (r *membershipRole) Name() string {
+	return r.membership.Role
+}
+
+// WhoCan returns the memberships in the given namespace and in the global namespace
+// whose role permits the action on the resource, the resource is relative to the
+// namespace, conditions are evaluated with the member as the user
+func (a *Authorizer) WhoCan(ctx context.Context, namespace uuid.UUID, action Action, resource Resource) ([]Membership, error) {
+	allowed := []Membership{}
+	if a.namespaceManager == nil {
+		return allowed, nil
+	}
+
+	namespaces := []uuid.UUID{globalNamespace}
+	if namespace != globalNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	resource = NamespacedResource(namespace, resource)
+	for _, id := range namespaces {
+		members, err := a.namespaceManager.MembersOf(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		for i := range members {
+			policies := a.roleManager.getPolicies(&membershipRole{&members[i]})
+			ok, err := newEvaluator(a, namespace, members[i].Principal).allows(ctx, policies, action, resource)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				allowed = append(allowed, members[i])
+			}
+		}
+	}
+	return allowed, nil
+}
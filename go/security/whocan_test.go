@@ -0,0 +1,44 @@
+package security
+
+import (
+	"context"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestWhoCan(t *testing.T) {
+	ctx := context.Background()
+	admin := Role{Name: "admin", Policies: []Policy{{Resource: ResourceAll, Action: ActionAll}}}
+	viewer := Role{Name: "viewer", Policies: []Policy{{Resource: "projects/*", Action: ActionRead}}}
+	authorizer := NewAuthorizer(newTestNamespaceManager())
+	authorizer.MustRegister(admin, viewer)
+
+	namespace := uuid.NewV4()
+	superuser, reader, other := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	if err := authorizer.SetRole(ctx, admin, superuser); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizer.AddUserToNamespace(ctx, viewer, namespace, reader); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorizer.AddUserToNamespace(ctx, viewer, uuid.NewV4(), other); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := authorizer.WhoCan(ctx, namespace, ActionRead, Resource("projects/123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].Principal != superuser || members[1].Principal != reader {
+		t.Errorf("expected the admin and the viewer to be able to read, got %+v", members)
+	}
+
+	members, err = authorizer.WhoCan(ctx, namespace, ActionDelete, Resource("projects/123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Principal != superuser || members[0].Namespace != globalNamespace {
+		t.Errorf("expected only the global admin to be able to delete, got %+v", members)
+	}
+}
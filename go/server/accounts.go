@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+)
+
+// serviceAccountUser is a middleware that authenticates service account assertions
+// given as bearer tokens, it's skipped for requests already authenticated by a
+// google id token or an api token
+func serviceAccountUser(handler *oauth.Handler, manager *accounts.Manager, render common.Renderer, logger zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if manager == nil {
+			return next
+		}
+		authenticated := accounts.Middleware(manager, render, logger)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if handler.Claims(ctx) != nil || tokens.FromContext(ctx) != nil || getToken(ctx) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+const (
+	// ResourceRoles is the resource checked before listing roles
+	ResourceRoles = security.Resource("roles")
+	// ResourceMemberships is the resource checked before listing, granting or
+	// revoking memberships, it is scoped to the namespace being managed
+	ResourceMemberships = security.Resource("memberships")
+	// ResourceElevations is the resource checked before requesting, listing,
+	// approving or denying elevations, it is scoped to the elevation's namespace
+	ResourceElevations = security.Resource("elevations")
+)
+
+// AdminConfig provides the configuration for the admin router
+type AdminConfig struct {
+	// Authorizer defaults to the server's authorizer
+	Authorizer *security.Authorizer
+	Render     common.Renderer
+	Logger     zerolog.Logger
+	// Actor returns the user making the request, it defaults to the
+	// user set on the request context with security.WithActor, which
+	// the server sets for logged in users when Config.UserID is set
+	Actor func(r *http.Request) (uuid.UUID, error)
+}
+
+// MembershipRequest is the body used to grant a role to a user
+type MembershipRequest struct {
+	Role string `json:"role"`
+}
+
+// ElevationRequest is the body used to request an elevation for the current user
+type ElevationRequest struct {
+	Namespace uuid.UUID `json:"namespace"`
+	Role      string    `json:"role"`
+	// Duration is parsed with time.ParseDuration, e.g. "30m"
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type adminHandler struct {
+	authorizer *security.Authorizer
+	render     common.Renderer
+	logger     zerolog.Logger
+	actor      func(r *http.Request) (uuid.UUID, error)
+}
+
+// NewAdminRouter returns a router with endpoints for listing the registered roles,
+// managing the members of a namespace and deciding elevation requests, the global
+// namespace is addressed with the nil uuid, roles can only be granted, replaced or
+// revoked by users that hold every policy of the role in the namespace
+func NewAdminRouter(config AdminConfig) chi.Router {
+	handler := &adminHandler{
+		authorizer: config.Authorizer,
+		render:     config.Render,
+		logger:     config.Logger,
+		actor:      config.Actor,
+	}
+	if handler.actor == nil {
+		handler.actor = security.ActorFromRequest
+	}
+
+	router := chi.NewRouter()
+	router.Get("/roles", handler.roles)
+	router.Route("/namespaces/{namespace}/members", func(router chi.Router) {
+		router.Get("/", handler.members)
+		router.Get("/can", handler.whoCan)
+		router.Put("/{user}", handler.grant)
+		router.Delete("/{user}", handler.revoke)
+	})
+	router.Route("/elevations", func(router chi.Router) {
+		router.Get("/", handler.elevations)
+		router.Post("/", handler.requestElevation)
+		router.Post("/{elevation}/approve", handler.decideElevation(true))
+		router.Post("/{elevation}/deny", handler.decideElevation(false))
+	})
+	return router
+}
+
+func (h *adminHandler) roles(w http.ResponseWriter, r *http.Request) {
+	h.authorized(w, r, uuid.UUID{}, security.ActionList, ResourceRoles, func(r *http.Request) {
+		h.render.Render(w, http.StatusOK, h.authorizerFor(r).Roles())
+	})
+}
+
+func (h *adminHandler) members(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := h.uuidParam(w, r, "namespace")
+	if !ok {
+		return
+	}
+	h.authorized(w, r, namespace, security.ActionList, ResourceMemberships, func(r *http.Request) {
+		members, err := h.authorizerFor(r).MembersOf(r.Context(), namespace)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("error listing namespace members")
+			h.render.InternalError(w)
+			return
+		}
+		h.render.Render(w, http.StatusOK, members)
+	})
+}
+
+// whoCan lists the members that can perform the action given in the "action"
+// query parameter on the resource given in the "resource" query parameter
+func (h *adminHandler) whoCan(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := h.uuidParam(w, r, "namespace")
+	if !ok {
+		return
+	}
+	action := security.Action(r.URL.Query().Get("action"))
+	resource := security.Resource(r.URL.Query().Get("resource"))
+	if action == "" || resource == "" {
+		h.render.Error(w, http.StatusBadRequest, "action and resource are required")
+		return
+	}
+	h.authorized(w, r, namespace, security.ActionList, ResourceMemberships, func(r *http.Request) {
+		members, err := h.authorizerFor(r).WhoCan(r.Context(), namespace, action, resource)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("error listing namespace members")
+			h.render.InternalError(w)
+			return
+		}
+		h.render.Render(w, http.StatusOK, members)
+	})
+}
+
+func (h *adminHandler) grant(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := h.uuidParam(w, r, "namespace")
+	if !ok {
+		return
+	}
+	user, ok := h.uuidParam(w, r, "user")
+	if !ok {
+		return
+	}
+	h.authorized(w, r, namespace, security.ActionUpdate, ResourceMemberships, func(r *http.Request) {
+		request := MembershipRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.render.Error(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		role, ok := h.authorizerFor(r).Role(request.Role)
+		if !ok {
+			h.render.Error(w, http.StatusBadRequest, "unknown role")
+			return
+		}
+		actor, _ := security.ActorFrom(r.Context())
+		if !h.canGrant(w, r, actor, role, namespace) || !h.canReplace(w, r, actor, user, namespace) {
+			return
+		}
+		if err := h.authorizerFor(r).AddUserToNamespace(r.Context(), role, namespace, user); err != nil {
+			h.logger.Error().Err(err).Msg("error granting role")
+			h.render.InternalError(w)
+			return
+		}
+		h.render.Render(w, http.StatusOK, &security.Membership{
+			Namespace: namespace,
+			Principal: user,
+			Kind:      security.PrincipalUser,
+			Role:      role.Name,
+		})
+	})
+}
+
+func (h *adminHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := h.uuidParam(w, r, "namespace")
+	if !ok {
+		return
+	}
+	user, ok := h.uuidParam(w, r, "user")
+	if !ok {
+		return
+	}
+	h.authorized(w, r, namespace, security.ActionDelete, ResourceMemberships, func(r *http.Request) {
+		actor, _ := security.ActorFrom(r.Context())
+		if !h.canReplace(w, r, actor, user, namespace) {
+			return
+		}
+		if err := h.authorizerFor(r).RemoveUserFromNamespace(r.Context(), namespace, user); err != nil {
+			h.logger.Error().Err(err).Msg("error revoking role")
+			h.render.InternalError(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (h *adminHandler) elevations(w http.ResponseWriter, r *http.Request) {
+	h.authorized(w, r, uuid.UUID{}, security.ActionList, ResourceElevations, func(r *http.Request) {
+		elevations, err := h.authorizerFor(r).PendingElevations(r.Context())
+		if err != nil {
+			h.elevationError(w, err)
+			return
+		}
+		h.render.Render(w, http.StatusOK, elevations)
+	})
+}
+
+func (h *adminHandler) requestElevation(w http.ResponseWriter, r *http.Request) {
+	request := ElevationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.render.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil {
+		h.render.Error(w, http.StatusBadRequest, "invalid duration")
+		return
+	}
+	h.authorized(w, r, request.Namespace, security.ActionCreate, ResourceElevations, func(r *http.Request) {
+		actor, _ := security.ActorFrom(r.Context())
+		elevation, err := h.authorizerFor(r).RequestElevation(r.Context(), request.Role, request.Namespace, actor, duration, request.Reason)
+		if err != nil {
+			h.elevationError(w, err)
+			return
+		}
+		h.render.Render(w, http.StatusCreated, elevation)
+	})
+}
+
+func (h *adminHandler) decideElevation(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := h.uuidParam(w, r, "elevation")
+		if !ok {
+			return
+		}
+		elevation, err := h.authorizerFor(r).Elevation(r.Context(), id)
+		if err != nil {
+			h.elevationError(w, err)
+			return
+		}
+		h.authorized(w, r, elevation.Namespace, security.ActionUpdate, ResourceElevations, func(r *http.Request) {
+			actor, _ := security.ActorFrom(r.Context())
+			decide := h.authorizerFor(r).DenyElevation
+			if approve {
+				// approving grants the role so it's held to the same rule as grant
+				role, ok := h.authorizerFor(r).Role(elevation.Role)
+				if !ok {
+					h.elevationError(w, security.ErrInvalidElevation)
+					return
+				}
+				if !h.canGrant(w, r, actor, role, elevation.Namespace) {
+					return
+				}
+				decide = h.authorizerFor(r).ApproveElevation
+			}
+			elevation, err := decide(r.Context(), id, actor)
+			if err != nil {
+				h.elevationError(w, err)
+				return
+			}
+			h.render.Render(w, http.StatusOK, elevation)
+		})
+	}
+}
+
+func (h *adminHandler) elevationError(w http.ResponseWriter, err error) {
+	switch err {
+	case security.ErrElevationsUnsupported, security.ErrElevationNotFound:
+		h.render.Error(w, http.StatusNotFound, err.Error())
+	case security.ErrInvalidElevation:
+		h.render.Error(w, http.StatusBadRequest, err.Error())
+	case security.ErrSelfApproval:
+		h.render.Error(w, http.StatusForbidden, err.Error())
+	case security.ErrElevationDecided:
+		h.render.Error(w, http.StatusConflict, err.Error())
+	default:
+		h.logger.Error().Err(err).Msg("error handling elevation request")
+		h.render.InternalError(w)
+	}
+}
+
+// canGrant checks that the actor holds every policy of the role in the namespace,
+// it writes the error response when they don't
+func (h *adminHandler) canGrant(w http.ResponseWriter, r *http.Request, actor uuid.UUID, role security.Role, namespace uuid.UUID) bool {
+	allowed, err := h.authorizerFor(r).CanGrant(r.Context(), actor, role, namespace)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("error getting policies for user")
+		h.render.InternalError(w)
+		return false
+	}
+	if !allowed {
+		h.render.Error(w, http.StatusForbidden, "roles can only be granted by users that hold all of their policies")
+		return false
+	}
+	return true
+}
+
+// canReplace checks that the actor could grant every role the user holds in the
+// namespace so that users can't revoke or overwrite the roles of more privileged
+// users, it writes the error response when they can't
+func (h *adminHandler) canReplace(w http.ResponseWriter, r *http.Request, actor, user, namespace uuid.UUID) bool {
+	authorizer := h.authorizerFor(r)
+	members, err := authorizer.MembersOf(r.Context(), namespace)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("error listing namespace members")
+		h.render.InternalError(w)
+		return false
+	}
+	for _, member := range members {
+		if member.Kind != security.PrincipalUser || !uuid.Equal(member.Principal, user) {
+			continue
+		}
+		role, ok := authorizer.Role(member.Role)
+		if !ok {
+			continue
+		}
+		allowed, err := authorizer.CanGrant(r.Context(), actor, role, namespace)
+		if err != nil {
+			h.logger.Error().Err(err).Msg("error getting policies for user")
+			h.render.InternalError(w)
+			return false
+		}
+		if !allowed {
+			h.render.Error(w, http.StatusForbidden, "roles can only be changed by users that hold all of their policies")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *adminHandler) authorizerFor(r *http.Request) *security.Authorizer {
+	return requestAuthorizer(h.authorizer, r)
+}
+
+func (h *adminHandler) uuidParam(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.FromString(chi.URLParam(r, name))
+	if err != nil {
+		h.render.Error(w, http.StatusBadRequest, "invalid "+name)
+		return uuid.UUID{}, false
+	}
+	return id, true
+}
+
+// authorized checks that the actor can act on the resource in the given namespace,
+// the actor is set on the request context passed to inner so that any changes
+// are attributed to them in the audit log
+func (h *adminHandler) authorized(w http.ResponseWriter, r *http.Request, namespace uuid.UUID, action security.Action, resource security.Resource, inner func(r *http.Request)) {
+	actor, err := h.actor(r)
+	if err != nil && err != security.ErrNoActor {
+		h.logger.Error().Err(err).Msg("error getting current user")
+		h.render.InternalError(w)
+		return
+	}
+	if err == security.ErrNoActor || uuid.Equal(actor, uuid.UUID{}) {
+		h.render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+	ctx := security.WithActor(r.Context(), actor)
+	allowed, err := h.authorizerFor(r).WithNamespaceAndUser(namespace, actor).Can(ctx, action, security.NamespacedResource(namespace, resource))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("error getting policies for user")
+		h.render.InternalError(w)
+		return
+	}
+	if !allowed {
+		h.render.Error(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+		return
+	}
+	inner(r.Clone(ctx))
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/security/memory"
+)
+
+func TestAdminRouter(t *testing.T) {
+	admin := security.Role{Name: "admin", Policies: []security.Policy{
+		{Resource: ResourceMemberships, Action: security.ActionAll},
+	}}
+	viewer := security.Role{Name: "viewer", Policies: []security.Policy{
+		{Resource: ResourceMemberships, Action: security.ActionList},
+		{Resource: ResourceRoles, Action: security.ActionList},
+	}}
+	superAdmin := security.Role{Name: "super_admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(admin, viewer, superAdmin))
+
+	actor, user, owner, namespace := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ctx := context.Background()
+	require.NoError(t, authorizer.AddUserToNamespace(ctx, admin, namespace, actor))
+	require.NoError(t, authorizer.AddUserToNamespace(ctx, superAdmin, namespace, owner))
+	require.NoError(t, authorizer.SetRole(ctx, viewer, user))
+
+	router := NewAdminRouter(AdminConfig{
+		Authorizer: authorizer,
+		Render:     common.NewJSONRenderer(),
+		Logger:     zerolog.Nop(),
+	})
+	request := func(as uuid.UUID, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if !uuid.Equal(as, uuid.UUID{}) {
+			r = r.WithContext(security.WithActor(r.Context(), as))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	members := "/namespaces/" + namespace.String() + "/members/"
+
+	require.Equal(t, http.StatusUnauthorized, request(uuid.UUID{}, "GET", "/roles", "").Code)
+	require.Equal(t, http.StatusForbidden, request(actor, "GET", "/roles", "").Code)
+	response := request(user, "GET", "/roles", "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), `"name":"viewer"`)
+
+	require.Equal(t, http.StatusBadRequest, request(actor, "GET", "/namespaces/invalid/members/", "").Code)
+	require.Equal(t, http.StatusForbidden, request(user, "PUT", members+user.String(), `{"role":"admin"}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(actor, "PUT", members+user.String(), `{"role":"unknown"}`).Code)
+	// namespace admins can't grant roles with policies they don't hold, themselves included
+	require.Equal(t, http.StatusForbidden, request(actor, "PUT", members+user.String(), `{"role":"super_admin"}`).Code)
+	require.Equal(t, http.StatusForbidden, request(actor, "PUT", members+actor.String(), `{"role":"super_admin"}`).Code)
+	require.Equal(t, http.StatusOK, request(actor, "PUT", members+user.String(), `{"role":"admin"}`).Code)
+	// nor overwrite or revoke the roles of users holding policies they don't
+	require.Equal(t, http.StatusForbidden, request(actor, "PUT", members+owner.String(), `{"role":"admin"}`).Code)
+	require.Equal(t, http.StatusForbidden, request(actor, "DELETE", members+owner.String(), "").Code)
+
+	// global viewers can list the members of any namespace
+	response = request(user, "GET", members, "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), user.String())
+
+	require.Equal(t, http.StatusBadRequest, request(user, "GET", members+"can", "").Code)
+	response = request(user, "GET", members+"can?action=update&resource=memberships", "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), actor.String())
+
+	require.Equal(t, http.StatusNoContent, request(actor, "DELETE", members+user.String(), "").Code)
+	roles, err := authorizer.MembersOf(ctx, namespace)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	for _, role := range roles {
+		require.NotEqual(t, user, role.Principal)
+	}
+}
+
+func TestAdminRouterElevations(t *testing.T) {
+	superAdmin := security.Role{Name: "super_admin", Policies: []security.Policy{
+		{Resource: security.ResourceAll, Action: security.ActionAll},
+	}}
+	engineer := security.Role{Name: "engineer", Policies: []security.Policy{
+		{Resource: ResourceElevations, Action: security.ActionCreate},
+	}}
+	reviewer := security.Role{Name: "reviewer", Policies: []security.Policy{
+		{Resource: ResourceElevations, Action: security.ActionAll},
+	}}
+	authorizer := security.NewAuthorizer(memory.NewNamespaceManager()).WithElevations(memory.NewElevationStore(), time.Hour)
+	require.NoError(t, authorizer.Register(superAdmin, engineer, reviewer))
+
+	approver, user, other := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	ctx := context.Background()
+	require.NoError(t, authorizer.SetRole(ctx, superAdmin, approver))
+	require.NoError(t, authorizer.SetRole(ctx, engineer, user))
+	require.NoError(t, authorizer.SetRole(ctx, reviewer, other))
+
+	router := NewAdminRouter(AdminConfig{
+		Authorizer: authorizer,
+		Render:     common.NewJSONRenderer(),
+		Logger:     zerolog.Nop(),
+	})
+	request := func(as uuid.UUID, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r = r.WithContext(security.WithActor(r.Context(), as))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusBadRequest, request(user, "POST", "/elevations", `{"role":"super_admin","duration":"forever"}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(user, "POST", "/elevations", `{"role":"super_admin","duration":"2h"}`).Code)
+	require.Equal(t, http.StatusCreated, request(user, "POST", "/elevations", `{"role":"super_admin","duration":"30m","reason":"incident"}`).Code)
+	require.Equal(t, http.StatusForbidden, request(user, "GET", "/elevations", "").Code)
+
+	pending, err := authorizer.PendingElevations(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	approve := "/elevations/" + pending[0].ID.String() + "/approve"
+
+	require.Equal(t, http.StatusForbidden, request(user, "POST", approve, "").Code)
+	// reviewers can't approve roles with policies they don't hold
+	require.Equal(t, http.StatusForbidden, request(other, "POST", approve, "").Code)
+	require.Equal(t, http.StatusNotFound, request(approver, "POST", "/elevations/"+uuid.NewV4().String()+"/approve", "").Code)
+	require.Equal(t, http.StatusOK, request(approver, "POST", approve, "").Code)
+	require.Equal(t, http.StatusConflict, request(approver, "POST", approve, "").Code)
+
+	// the elevated user can now list pending elevations
+	require.Equal(t, http.StatusOK, request(user, "GET", "/elevations", "").Code)
+}
+
+func TestAdminRouterRequestAuthorizer(t *testing.T) {
+	viewer := security.Role{Name: "viewer", Policies: []security.Policy{
+		{Resource: ResourceRoles, Action: security.ActionList},
+	}}
+	authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(viewer))
+	user := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), viewer, user))
+
+	// without an authorizer the router checks against the server's one
+	router := NewAdminRouter(AdminConfig{
+		Render: common.NewJSONRenderer(),
+		Logger: zerolog.Nop(),
+	})
+	r := httptest.NewRequest("GET", "/roles", nil)
+	r = r.WithContext(security.WithActor(WithAuthorizer(r.Context(), authorizer), user))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"name":"viewer"`)
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// DefaultRouteActions maps http methods to the actions checked by a RouteAuthorizer
+var DefaultRouteActions = map[string]security.Action{
+	http.MethodGet:    security.ActionRead,
+	http.MethodHead:   security.ActionRead,
+	http.MethodPost:   security.ActionCreate,
+	http.MethodPut:    security.ActionUpdate,
+	http.MethodPatch:  security.ActionUpdate,
+	http.MethodDelete: security.ActionDelete,
+}
+
+type routeOverride struct {
+	action   security.Action
+	resource security.Resource
+	public   bool
+}
+
+// RouteAuthorizer is a chi middleware that authorizes requests based on the
+// route they match, the resource is derived from the route pattern with its
+// url parameters filled in, e.g. "/projects/{id}" becomes "projects/<id>",
+// and the action is derived from the request method
+type RouteAuthorizer struct {
+	authorizer *security.Authorizer
+	render     common.Renderer
+	logger     zerolog.Logger
+	prefix     string
+	actions    map[string]security.Action
+	overrides  map[string]routeOverride
+	user       func(r *http.Request) (uuid.UUID, error)
+	namespace  func(r *http.Request) (uuid.UUID, error)
+}
+
+// NewRouteAuthorizer returns a route authorizer that checks requests against the
+// server's authorizer as the user returned by the given function, e.g. one that
+// gets the id of CurrentUser, a nil uuid or security.ErrNoActor is treated as an
+// unauthenticated request
+func NewRouteAuthorizer(render common.Renderer, logger zerolog.Logger, user func(r *http.Request) (uuid.UUID, error)) *RouteAuthorizer {
+	actions := make(map[string]security.Action, len(DefaultRouteActions))
+	for method, action := range DefaultRouteActions {
+		actions[method] = action
+	}
+	return &RouteAuthorizer{
+		render:    render,
+		logger:    logger,
+		actions:   actions,
+		overrides: make(map[string]routeOverride),
+		user:      user,
+		namespace: func(r *http.Request) (uuid.UUID, error) { return uuid.UUID{}, nil },
+	}
+}
+
+// WithAuthorizer checks requests against the given authorizer rather than the server's one
+func (a *RouteAuthorizer) WithAuthorizer(authorizer *security.Authorizer) *RouteAuthorizer {
+	a.authorizer = authorizer
+	return a
+}
+
+// WithPrefix strips the prefix from route patterns before deriving resources, e.g.
+// with the prefix "/api/v1" the pattern "/api/v1/projects" becomes "projects"
+func (a *RouteAuthorizer) WithPrefix(prefix string) *RouteAuthorizer {
+	a.prefix = prefix
+	return a
+}
+
+// WithAction changes the action checked for the given http method
+func (a *RouteAuthorizer) WithAction(method string, action security.Action) *RouteAuthorizer {
+	a.actions[strings.ToUpper(method)] = action
+	return a
+}
+
+// WithNamespace changes how the namespace of a request is looked up, derived
+// resources are scoped to the namespace, it defaults to the global namespace
+func (a *RouteAuthorizer) WithNamespace(namespace func(r *http.Request) (uuid.UUID, error)) *RouteAuthorizer {
+	a.namespace = namespace
+	return a
+}
+
+// Override changes the action or resource checked for the route with the given method and
+// full pattern, "*" matches any method, empty values keep the derived action or resource
+func (a *RouteAuthorizer) Override(method, pattern string, action security.Action, resource security.Resource) *RouteAuthorizer {
+	a.overrides[routeKey(method, pattern)] = routeOverride{action: action, resource: resource}
+	return a
+}
+
+// Public skips authorization for the route with the given method and full pattern,
+// "*" matches any method
+func (a *RouteAuthorizer) Public(method, pattern string) *RouteAuthorizer {
+	a.overrides[routeKey(method, pattern)] = routeOverride{public: true}
+	return a
+}
+
+func routeKey(method, pattern string) string {
+	return strings.ToUpper(method) + " " + pattern
+}
+
+func (a *RouteAuthorizer) override(method, pattern string) (routeOverride, bool) {
+	if override, ok := a.overrides[routeKey(method, pattern)]; ok {
+		return override, true
+	}
+	override, ok := a.overrides[routeKey("*", pattern)]
+	return override, ok
+}
+
+// Handler is the middleware, it can be used at any level of a chi router since
+// the route is resolved from the root router, requests that don't match a route
+// are passed through so that chi can respond to them
+func (a *RouteAuthorizer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern, resource, ok := a.resolve(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		action, ok := a.actions[r.Method]
+		if !ok {
+			a.render.Error(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		if override, ok := a.override(r.Method, pattern); ok {
+			if override.public {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if override.action != "" {
+				action = override.action
+			}
+			if override.resource != "" {
+				resource = override.resource
+			}
+		}
+
+		user, err := a.user(r)
+		if err != nil && err != security.ErrNoActor {
+			a.logger.Error().Err(err).Msg("error getting current user")
+			a.render.InternalError(w)
+			return
+		}
+		if err == security.ErrNoActor || uuid.Equal(user, uuid.UUID{}) {
+			a.render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		namespace, err := a.namespace(r)
+		if err != nil {
+			a.logger.Error().Err(err).Msg("error getting request namespace")
+			a.render.InternalError(w)
+			return
+		}
+
+		authorizer := requestAuthorizer(a.authorizer, r)
+		ctx := security.WithActor(r.Context(), user)
+		allowed, err := authorizer.WithNamespaceAndUser(namespace, user).Can(ctx, action, security.NamespacedResource(namespace, resource))
+		if err != nil {
+			a.logger.Error().Err(err).Msg("error getting policies for user")
+			a.render.InternalError(w)
+			return
+		}
+		if !allowed {
+			a.render.Error(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			return
+		}
+		next.ServeHTTP(w, r.Clone(ctx))
+	})
+}
+
+// resolve matches the request against the root router, middleware runs before
+// the routers below it so the route context doesn't hold the full pattern yet
+func (a *RouteAuthorizer) resolve(r *http.Request) (string, security.Resource, bool) {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return "", "", false
+	}
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	match := chi.NewRouteContext()
+	if !rctx.Routes.Match(match, r.Method, path) {
+		return "", "", false
+	}
+	pattern := match.RoutePattern()
+	return pattern, routeResource(strings.TrimPrefix(pattern, a.prefix), match), true
+}
+
+// routeResource converts a route pattern to a resource, replacing url
+// parameters and wildcards with their values
+func routeResource(pattern string, match *chi.Context) security.Resource {
+	segments := []string{}
+	for _, segment := range strings.Split(pattern, "/") {
+		switch {
+		case segment == "":
+			continue
+		case segment == "*":
+			if value := strings.Trim(match.URLParam("*"), "/"); value != "" {
+				segments = append(segments, value)
+			}
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name := strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")
+			if i := strings.Index(name, ":"); i >= 0 {
+				name = name[:i]
+			}
+			segments = append(segments, match.URLParam(name))
+		default:
+			segments = append(segments, segment)
+		}
+	}
+	return security.Resource(strings.Join(segments, "/"))
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/security/memory"
+)
+
+func TestRouteResource(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		path     string
+		expected security.Resource
+	}{
+		{"/projects", "/projects", "projects"},
+		{"/projects/", "/projects/", "projects"},
+		{"/projects/{id}", "/projects/1", "projects/1"},
+		{"/projects/{id:[0-9]+}/tasks/{task}", "/projects/1/tasks/2", "projects/1/tasks/2"},
+		{"/files/*", "/files/a/b", "files/a/b"},
+	} {
+		router := chi.NewRouter()
+		router.Get(test.pattern, func(w http.ResponseWriter, r *http.Request) {})
+		match := chi.NewRouteContext()
+		require.True(t, router.Match(match, http.MethodGet, test.path), test.pattern)
+		require.Equal(t, test.expected, routeResource(match.RoutePattern(), match), test.pattern)
+	}
+}
+
+func TestRouteAuthorizer(t *testing.T) {
+	editor := security.Role{Name: "editor", Policies: []security.Policy{
+		{Resource: "projects/*", Action: security.ActionRead},
+		{Resource: "projects/*", Action: security.ActionUpdate},
+		{Resource: "projects", Action: security.ActionList},
+	}}
+	authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(editor))
+	user := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), editor, user))
+
+	middleware := NewRouteAuthorizer(common.NewJSONRenderer(), zerolog.Nop(), security.ActorFromRequest).
+		WithAuthorizer(authorizer).
+		WithPrefix("/api").
+		Override(http.MethodGet, "/api/projects/", security.ActionList, "").
+		Public("*", "/api/health")
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		actor, _ := security.ActorFrom(r.Context())
+		w.Write([]byte(actor.String()))
+	}
+	router := chi.NewRouter()
+	router.Route("/api", func(router chi.Router) {
+		router.Use(middleware.Handler)
+		router.Get("/health", ok)
+		router.Route("/projects", func(router chi.Router) {
+			router.Get("/", ok)
+			router.Get("/{id}", ok)
+			router.Put("/{id}", ok)
+			router.Delete("/{id}", ok)
+		})
+	})
+	request := func(as uuid.UUID, method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if !uuid.Equal(as, uuid.UUID{}) {
+			r = r.WithContext(security.WithActor(r.Context(), as))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, request(uuid.UUID{}, http.MethodGet, "/api/health").Code)
+	require.Equal(t, http.StatusUnauthorized, request(uuid.UUID{}, http.MethodGet, "/api/projects/1").Code)
+	require.Equal(t, http.StatusNotFound, request(user, http.MethodGet, "/api/unknown").Code)
+
+	response := request(user, http.MethodGet, "/api/projects/1")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, user.String(), response.Body.String())
+	require.Equal(t, http.StatusOK, request(user, http.MethodPut, "/api/projects/1").Code)
+	require.Equal(t, http.StatusOK, request(user, http.MethodGet, "/api/projects/").Code)
+	require.Equal(t, http.StatusForbidden, request(user, http.MethodDelete, "/api/projects/1").Code)
+	require.Equal(t, http.StatusForbidden, request(uuid.NewV4(), http.MethodGet, "/api/projects/1").Code)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+var authorizerKey = "authorizer-context-key"
+
+// WithAuthorizer returns a context with the authorizer injected
+func WithAuthorizer(ctx context.Context, authorizer *security.Authorizer) context.Context {
+	return context.WithValue(ctx, &authorizerKey, authorizer)
+}
+
+// Authorizer returns the server's authorizer, it's on the context of every api
+// request and login hook, it MUST be called only after WithAuthorizer
+func Authorizer(ctx context.Context) *security.Authorizer {
+	authorizer := ctx.Value(&authorizerKey)
+	if authorizer == nil {
+		panic("authorizer not found on context")
+	}
+	return authorizer.(*security.Authorizer)
+}
+
+// requestAuthorizer returns the given authorizer, falling back to the server's
+// authorizer on the request context and then to security.Default()
+func requestAuthorizer(authorizer *security.Authorizer, r *http.Request) *security.Authorizer {
+	if authorizer != nil {
+		return authorizer
+	}
+	if authorizer, ok := r.Context().Value(&authorizerKey).(*security.Authorizer); ok {
+		return authorizer
+	}
+	return security.Default()
+}
+
+// injectAuthorizer puts the authorizer on the context of every request
+func injectAuthorizer(authorizer *security.Authorizer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.Clone(WithAuthorizer(r.Context(), authorizer)))
+		})
+	}
+}
+
+func defaultAuthorizer(config Config, db *sqlx.DB, manager security.NamespaceManager) *security.Authorizer {
+	if config.Authorizer != nil {
+		return config.Authorizer(db)
+	}
+	return security.NewAuthorizer(manager)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
+)
+
+// SetupRequest is the body used to redeem the setup token
+type SetupRequest struct {
+	Token string `json:"token"`
+}
+
+// logSetupToken is a start hook that logs the setup token so that whoever
+// deploys the site can use it to set the site up
+func logSetupToken(manager *bootstrap.Manager, logger zerolog.Logger) Hook {
+	return func(ctx context.Context) error {
+		token, err := manager.SetupToken(ctx)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			logger.Warn().Str("setup_token", token).Msg("the site hasn't been set up, post the setup token to /api/setup once logged in")
+		}
+		return nil
+	}
+}
+
+// setupHandler redeems the setup token for the logged in user and runs the first
+// user hook with their claims in the request's transaction, a denial from the hook
+// leaves the site as it was
+func setupHandler(handler *oauth.Handler, manager *bootstrap.Manager, render common.Renderer, logger zerolog.Logger, hook oauth.LoginHook) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := handler.Claims(r.Context())
+		if claims == nil {
+			render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		request := SetupRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			render.Error(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		decision := oauth.Allow()
+		err := manager.Redeem(r.Context(), request.Token, func(ctx context.Context) (err error) {
+			decision, err = hook(ctx, &oauth.LoginEvent{
+				Request:   r,
+				Claims:    claims,
+				Provider:  oauth.ProviderGoogle,
+				ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+			})
+			if err == nil && decision.Denied {
+				return errLoginDenied
+			}
+			return err
+		})
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errLoginDenied:
+			reason := decision.Reason
+			if reason == "" {
+				reason = http.StatusText(http.StatusForbidden)
+			}
+			render.Error(w, http.StatusForbidden, reason)
+		case bootstrap.ErrInvalidSetupToken:
+			render.Error(w, http.StatusForbidden, err.Error())
+		case bootstrap.ErrAlreadyInitialized:
+			render.Error(w, http.StatusConflict, err.Error())
+		default:
+			logger.Error().Err(err).Msg("error setting up the site")
+			render.InternalError(w)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
+)
+
+const (
+	redacted = "[REDACTED]"
+	// redactedPassword is url safe so that redacted urls stay readable
+	redactedPassword = "xxxxx"
+)
+
+// ConfigFileEnv names the environment variable that DefaultConfigSources
+// reads the path of the config file from
+const ConfigFileEnv = "CONFIG_FILE"
+
+// ConfigError reports every missing or invalid setting of a config at once
+type ConfigError struct {
+	Problems []string
+}
+
+// Error returns all of the problems found with the config
+func (e *ConfigError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// ConfigSources are where LoadConfig looks for the settings of a config that
+// aren't set explicitly, in order of precedence the environment, then the
+// dotenv file, then the config file
+type ConfigSources struct {
+	// File is an optional yaml or json config file, files ending in ".json" are
+	// parsed as json, the keys are the camel cased names of the Config fields
+	File string
+	// EnvFile is an optional dotenv file, it's ignored if it doesn't exist
+	EnvFile string
+	// Lookup looks up environment variables, it defaults to os.LookupEnv
+	Lookup func(key string) (string, bool)
+}
+
+// DefaultConfigSources reads the environment, a ".env" file in the working directory
+// and the config file named by the CONFIG_FILE environment variable
+func DefaultConfigSources() ConfigSources {
+	return ConfigSources{
+		File:    os.Getenv(ConfigFileEnv),
+		EnvFile: ".env",
+		Lookup:  os.LookupEnv,
+	}
+}
+
+// setting describes a field of Config that can be loaded, get returns
+// an empty string when the field isn't set
+type setting struct {
+	env    string
+	secret bool
+	get    func(config *Config) string
+	set    func(config *Config, value string) error
+}
+
+func stringSetting(env string, secret bool, field func(config *Config) *string) setting {
+	return setting{
+		env:    env,
+		secret: secret,
+		get:    func(config *Config) string { return *field(config) },
+		set: func(config *Config, value string) error {
+			*field(config) = value
+			return nil
+		},
+	}
+}
+
+func durationSetting(env string, field func(config *Config) *time.Duration) setting {
+	return setting{
+		env: env,
+		get: func(config *Config) string {
+			if *field(config) == 0 {
+				return ""
+			}
+			return field(config).String()
+		},
+		set: func(config *Config, value string) error {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration such as \"30s\"", env)
+			}
+			*field(config) = duration
+			return nil
+		},
+	}
+}
+
+func boolSetting(env string, field func(config *Config) *bool) setting {
+	return setting{
+		env: env,
+		get: func(config *Config) string {
+			if !*field(config) {
+				return ""
+			}
+			return "true"
+		},
+		set: func(config *Config, value string) error {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false", env)
+			}
+			*field(config) = enabled
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("HOST_PORT", false, func(c *Config) *string { return &c.HostPort }),
+	stringSetting("POSTGRES_URL", true, func(c *Config) *string { return &c.DatabaseURL }),
+	stringSetting("GOOGLE_CLIENT_ID", false, func(c *Config) *string { return &c.ClientID }),
+	stringSetting("GOOGLE_CLIENT_SECRET", true, func(c *Config) *string { return &c.ClientSecret }),
+	stringSetting("BASE_URL", false, func(c *Config) *string { return &c.BaseURL }),
+	stringSetting("JWT_SECRET", true, func(c *Config) *string { return &c.SecretKey }),
+	{
+		env: "GOOGLE_DOMAINS",
+		get: func(c *Config) string { return strings.Join(c.Domains, ",") },
+		set: func(c *Config, value string) error {
+			c.Domains = splitList(value)
+			return nil
+		},
+	},
+	stringSetting("TLS_CERT_FILE", false, func(c *Config) *string { return &c.TLSCertFile }),
+	stringSetting("TLS_KEY_FILE", false, func(c *Config) *string { return &c.TLSKeyFile }),
+	stringSetting("TLS_CLIENT_CA_FILE", false, func(c *Config) *string { return &c.ClientCAFile }),
+	boolSetting("TLS_REQUIRE_CLIENT_CERT", func(c *Config) *bool { return &c.RequireClientCert }),
+	durationSetting("READ_TIMEOUT", func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationSetting("READ_HEADER_TIMEOUT", func(c *Config) *time.Duration { return &c.ReadHeaderTimeout }),
+	durationSetting("WRITE_TIMEOUT", func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationSetting("IDLE_TIMEOUT", func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	durationSetting("SHUTDOWN_TIMEOUT", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	{
+		env: "SETUP_MODE",
+		get: func(c *Config) string { return string(c.SetupMode) },
+		set: func(c *Config, value string) error {
+			c.SetupMode = bootstrap.Mode(value)
+			return nil
+		},
+	},
+	{
+		env: "SETUP_EMAILS",
+		get: func(c *Config) string { return strings.Join(c.SetupEmails, ",") },
+		set: func(c *Config, value string) error {
+			c.SetupEmails = splitList(value)
+			return nil
+		},
+	},
+}
+
+// fileConfig is the format of a config file
+type fileConfig struct {
+	HostPort          string   `json:"hostPort" yaml:"hostPort"`
+	DatabaseURL       string   `json:"databaseURL" yaml:"databaseURL"`
+	ClientID          string   `json:"clientID" yaml:"clientID"`
+	ClientSecret      string   `json:"clientSecret" yaml:"clientSecret"`
+	BaseURL           string   `json:"baseURL" yaml:"baseURL"`
+	SecretKey         string   `json:"secretKey" yaml:"secretKey"`
+	Domains           []string `json:"domains" yaml:"domains"`
+	TLSCertFile       string   `json:"tlsCertFile" yaml:"tlsCertFile"`
+	TLSKeyFile        string   `json:"tlsKeyFile" yaml:"tlsKeyFile"`
+	ClientCAFile      string   `json:"clientCAFile" yaml:"clientCAFile"`
+	RequireClientCert bool     `json:"requireClientCert" yaml:"requireClientCert"`
+	ReadTimeout       string   `json:"readTimeout" yaml:"readTimeout"`
+	ReadHeaderTimeout string   `json:"readHeaderTimeout" yaml:"readHeaderTimeout"`
+	WriteTimeout      string   `json:"writeTimeout" yaml:"writeTimeout"`
+	IdleTimeout       string   `json:"idleTimeout" yaml:"idleTimeout"`
+	ShutdownTimeout   string   `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	SetupMode         string   `json:"setupMode" yaml:"setupMode"`
+	SetupEmails       []string `json:"setupEmails" yaml:"setupEmails"`
+}
+
+// values returns the file's settings keyed by their environment variables
+func (f *fileConfig) values() map[string]string {
+	requireClientCert := ""
+	if f.RequireClientCert {
+		requireClientCert = "true"
+	}
+	return map[string]string{
+		"HOST_PORT":               f.HostPort,
+		"POSTGRES_URL":            f.DatabaseURL,
+		"GOOGLE_CLIENT_ID":        f.ClientID,
+		"GOOGLE_CLIENT_SECRET":    f.ClientSecret,
+		"BASE_URL":                f.BaseURL,
+		"JWT_SECRET":              f.SecretKey,
+		"GOOGLE_DOMAINS":          strings.Join(f.Domains, ","),
+		"TLS_CERT_FILE":           f.TLSCertFile,
+		"TLS_KEY_FILE":            f.TLSKeyFile,
+		"TLS_CLIENT_CA_FILE":      f.ClientCAFile,
+		"TLS_REQUIRE_CLIENT_CERT": requireClientCert,
+		"READ_TIMEOUT":            f.ReadTimeout,
+		"READ_HEADER_TIMEOUT":     f.ReadHeaderTimeout,
+		"WRITE_TIMEOUT":           f.WriteTimeout,
+		"IDLE_TIMEOUT":            f.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":        f.ShutdownTimeout,
+		"SETUP_MODE":              f.SetupMode,
+		"SETUP_EMAILS":            strings.Join(f.SetupEmails, ","),
+	}
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &fileConfig{}
+	if strings.HasSuffix(path, ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(file)
+	} else {
+		err = yaml.UnmarshalStrict(data, file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+	return file.values(), nil
+}
+
+func readEnvFile(path string) (map[string]string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	return godotenv.Read(path)
+}
+
+// LoadConfig fills in the settings of the config that aren't set explicitly from the
+// sources, nothing is read into the process environment, values that can't be parsed
+// are reported together in a *ConfigError, the loaded config isn't validated
+func LoadConfig(config Config, sources ConfigSources) (Config, error) {
+	values := map[string]string{}
+	overlay := func(layer map[string]string) {
+		for key, value := range layer {
+			if value = strings.TrimSpace(value); value != "" {
+				values[key] = value
+			}
+		}
+	}
+
+	if sources.File != "" {
+		file, err := readConfigFile(sources.File)
+		if err != nil {
+			return config, err
+		}
+		overlay(file)
+	}
+	if sources.EnvFile != "" {
+		env, err := readEnvFile(sources.EnvFile)
+		if err != nil {
+			return config, fmt.Errorf("failed to parse env file %q: %v", sources.EnvFile, err)
+		}
+		overlay(env)
+	}
+	lookup := sources.Lookup
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	for _, setting := range settings {
+		if value, ok := lookup(setting.env); ok {
+			overlay(map[string]string{setting.env: value})
+		}
+	}
+
+	problems := []string{}
+	for _, setting := range settings {
+		value, ok := values[setting.env]
+		if !ok || setting.get(&config) != "" {
+			continue
+		}
+		if err := setting.set(&config, value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return config, &ConfigError{Problems: problems}
+	}
+	return config, nil
+}
+
+// splitList splits a comma separated setting such as GOOGLE_DOMAINS
+func splitList(value string) []string {
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// Validate checks every setting of the config and returns a *ConfigError
+// listing all of the problems found
+func (c Config) Validate() error {
+	return c.validate(true)
+}
+
+// validate checks the config, the database settings are only needed when
+// the server connects to the database itself
+func (c Config) validate(database bool) error {
+	problems := []string{}
+	required := func(value, env string) bool {
+		if value == "" {
+			problems = append(problems, env+" is required")
+			return false
+		}
+		return true
+	}
+
+	if database {
+		if c.Migrations == nil {
+			problems = append(problems, "migrations are required")
+		}
+		if required(c.DatabaseURL, "POSTGRES_URL") {
+			if parsed, err := url.Parse(c.DatabaseURL); err != nil || (parsed.Scheme != "postgres" && parsed.Scheme != "postgresql") {
+				problems = append(problems, "POSTGRES_URL must be a postgres:// url")
+			}
+		}
+	}
+	if c.HostPort != "" {
+		if _, _, err := net.SplitHostPort(c.HostPort); err != nil {
+			problems = append(problems, "HOST_PORT must be of the form host:port")
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be given together")
+	}
+	if c.ClientCAFile != "" && c.TLSCertFile == "" {
+		problems = append(problems, "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if c.SetupMode != "" && !c.SetupMode.Valid() {
+		problems = append(problems, "SETUP_MODE must be one of first_user, email or setup_token")
+	}
+	if c.SetupMode == bootstrap.ModeEmail && len(c.SetupEmails) == 0 {
+		problems = append(problems, "SETUP_EMAILS is required when SETUP_MODE is email")
+	}
+	if c.Impersonation != nil && c.UserID == nil {
+		problems = append(problems, "UserID is required for impersonation")
+	}
+	if c.RequireClientCert && c.ClientCAFile == "" {
+		problems = append(problems, "TLS_REQUIRE_CLIENT_CERT requires TLS_CLIENT_CA_FILE")
+	}
+	for _, file := range []struct{ path, env string }{
+		{c.TLSCertFile, "TLS_CERT_FILE"},
+		{c.TLSKeyFile, "TLS_KEY_FILE"},
+		{c.ClientCAFile, "TLS_CLIENT_CA_FILE"},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q can't be read", file.env, file.path))
+		}
+	}
+	for _, timeout := range []struct {
+		value time.Duration
+		env   string
+	}{
+		{c.ReadTimeout, "READ_TIMEOUT"},
+		{c.ReadHeaderTimeout, "READ_HEADER_TIMEOUT"},
+		{c.WriteTimeout, "WRITE_TIMEOUT"},
+		{c.IdleTimeout, "IDLE_TIMEOUT"},
+		{c.ShutdownTimeout, "SHUTDOWN_TIMEOUT"},
+	} {
+		if timeout.value < 0 {
+			problems = append(problems, timeout.env+" can't be negative")
+		}
+	}
+	required(c.ClientID, "GOOGLE_CLIENT_ID")
+	required(c.ClientSecret, "GOOGLE_CLIENT_SECRET")
+	required(c.SecretKey, "JWT_SECRET")
+	if required(c.BaseURL, "BASE_URL") {
+		if parsed, err := url.Parse(c.BaseURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			problems = append(problems, "BASE_URL must be an absolute http(s) url")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// Redacted returns the loadable settings of the config keyed by their
+// environment variables with any secrets redacted, it's safe to log
+func (c Config) Redacted() map[string]string {
+	values := map[string]string{}
+	for _, setting := range settings {
+		value := setting.get(&c)
+		if value != "" && setting.secret {
+			value = redact(value)
+		}
+		values[setting.env] = value
+	}
+	return values
+}
+
+// redactedParams are the query parameters of urls that hold secrets
+var redactedParams = []string{"password", "sslkey"}
+
+// redact hides secrets, urls only have their passwords hidden
+func redact(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return redacted
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), redactedPassword)
+	}
+	query := parsed.Query()
+	changed := false
+	for _, param := range redactedParams {
+		if _, ok := query[param]; ok {
+			query.Set(param, redactedPassword)
+			changed = true
+		}
+	}
+	if changed {
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.String()
+}
+
+// String returns the redacted settings of the config
+func (c Config) String() string {
+	values := c.Redacted()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + values[key]
+	}
+	return strings.Join(pairs, " ")
+}
@@ -4,48 +4,137 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"reflect"
+	"sync"
 
 	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
 
+	"github.com/andrewstucki/web-app-tools/go/accounts"
 	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
 	"github.com/andrewstucki/web-app-tools/go/oauth"
 	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
 )
 
 var (
 	currentUserKey = "current-user-context-key"
+
+	// ErrUserType is returned by typed current user accessors when the
+	// current user isn't of the expected type
+	ErrUserType = errors.New("the current user has an unexpected type")
 )
 
-// CurrentUser gets the current user or errors
+// currentUserResolver memoizes the current user of a request, the user is
+// looked up again on the next call if the lookup fails
+type currentUserResolver struct {
+	fn func(ctx context.Context) (interface{}, error)
+
+	mutex    sync.Mutex
+	resolved bool
+	user     interface{}
+}
+
+func (r *currentUserResolver) resolve(ctx context.Context) (interface{}, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.resolved {
+		return r.user, nil
+	}
+	user, err := r.fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if isNil(user) {
+		// don't hand out typed nils, e.g. a nil *User, as a non-nil interface
+		user = nil
+	}
+	r.user, r.resolved = user, true
+	return user, nil
+}
+
+// CurrentUser gets the current user or errors, the user is only looked up once
+// per request, it's nil if there is no current user, see cmd/usergen for a typed
+// wrapper around it
 func CurrentUser(ctx context.Context) (interface{}, error) {
-	ctxFn := ctx.Value(&currentUserKey)
-	if ctxFn == nil {
+	resolver := ctx.Value(&currentUserKey)
+	if resolver == nil {
 		return nil, errors.New("the callback must be injected")
 	}
-	return ctxFn.(func(ctx context.Context) (interface{}, error))(ctx)
+	return resolver.(*currentUserResolver).resolve(ctx)
 }
 
 // SetCurrentUserFn sets the context with the given current user resolver, it shouldn't
 // be used directly and is only exported for testing
 func SetCurrentUserFn(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) context.Context {
-	return context.WithValue(ctx, &currentUserKey, fn)
+	return context.WithValue(ctx, &currentUserKey, &currentUserResolver{fn: fn})
+}
+
+// RequireUser is a middleware that responds with a 401 unless the request has a current user
+func RequireUser(renderer common.Renderer, logger zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			current, err := CurrentUser(r.Context())
+			if err != nil {
+				logger.Error().Err(err).Msg("error getting current user")
+				renderer.InternalError(w)
+				return
+			}
+			if current == nil {
+				renderer.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	switch reflected := reflect.ValueOf(value); reflected.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return reflected.IsNil()
+	}
+	return false
 }
 
 // ClaimsOrToken represents either claims found
 // or and API token found
 type ClaimsOrToken struct {
+	// Kind is the kind of principal making the request, it's PrincipalServiceAccount
+	// for service accounts and for api tokens owned by them
+	Kind   security.PrincipalKind
 	Claims *verifier.StandardClaims
 	Token  string
+	// APIToken is the authenticated token when the config has a token store,
+	// Token is left empty in that case
+	APIToken *tokens.Token
+	// ServiceAccount is set when a service account authenticated with an assertion
+	ServiceAccount *accounts.ServiceAccount
+	// Impersonation is set during an impersonation session, the current user
+	// should be looked up from its User rather than from the other fields
+	Impersonation *impersonation.Session
 }
 
 // currentUser is a middleware that injects the current user into the context
 func currentUser(handler *oauth.Handler, renderer common.Renderer, logger zerolog.Logger, getter func(ctx context.Context, claims *ClaimsOrToken) (interface{}, error)) func(next http.Handler) http.Handler {
 	fn := func(ctx context.Context) (interface{}, error) {
 		if claims := handler.Claims(ctx); claims != nil {
-			return getter(ctx, &ClaimsOrToken{Claims: claims})
+			return getter(ctx, &ClaimsOrToken{Kind: security.PrincipalUser, Claims: claims})
+		}
+		if token := tokens.FromContext(ctx); token != nil {
+			return getter(ctx, &ClaimsOrToken{Kind: token.Kind, APIToken: token})
+		}
+		if account := accounts.FromContext(ctx); account != nil {
+			return getter(ctx, &ClaimsOrToken{Kind: security.PrincipalServiceAccount, ServiceAccount: account})
 		}
 		if token := getToken(ctx); token != "" {
-			return getter(ctx, &ClaimsOrToken{Token: token})
+			return getter(ctx, &ClaimsOrToken{Kind: security.PrincipalUser, Token: token})
 		}
 		return nil, nil
 	}
@@ -56,3 +145,32 @@ func currentUser(handler *oauth.Handler, renderer common.Renderer, logger zerolo
 		})
 	}
 }
+
+// userActor sets the logged in user as the security actor so that handlers which
+// default to security.ActorFromRequest, like the admin router, see them, the
+// token, service account and impersonation middleware set their own actors
+func userActor(handler *oauth.Handler, renderer common.Renderer, logger zerolog.Logger, userID func(user interface{}) uuid.UUID) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if userID == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if _, ok := security.ActorFrom(ctx); ok || handler.Claims(ctx) == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			current, err := CurrentUser(ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("error getting current user")
+				renderer.InternalError(w)
+				return
+			}
+			if current == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.Clone(security.WithActor(ctx, userID(current))))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+)
+
+type testUser struct {
+	Name string `json:"name"`
+}
+
+func TestCurrentUserMemoized(t *testing.T) {
+	calls := 0
+	failures := 1
+	ctx := SetCurrentUserFn(context.Background(), func(ctx context.Context) (interface{}, error) {
+		calls++
+		if failures > 0 {
+			failures--
+			return nil, errors.New("boom")
+		}
+		return &testUser{Name: "user"}, nil
+	})
+
+	// failed lookups aren't memoized
+	_, err := CurrentUser(ctx)
+	require.Error(t, err)
+	for i := 0; i < 3; i++ {
+		current, err := CurrentUser(ctx)
+		require.NoError(t, err)
+		require.Equal(t, &testUser{Name: "user"}, current)
+	}
+	require.Equal(t, 2, calls)
+
+	// typed nils are returned as nil
+	ctx = SetCurrentUserFn(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return (*testUser)(nil), nil
+	})
+	current, err := CurrentUser(ctx)
+	require.NoError(t, err)
+	require.Nil(t, current)
+
+	_, err = CurrentUser(context.Background())
+	require.Error(t, err)
+}
+
+func TestRequireUser(t *testing.T) {
+	handler := RequireUser(common.NewJSONRenderer(), zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(user interface{}, err error) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(SetCurrentUserFn(r.Context(), func(ctx context.Context) (interface{}, error) {
+			return user, err
+		}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusNoContent, request(&testUser{}, nil))
+	require.Equal(t, http.StatusUnauthorized, request(nil, nil))
+	require.Equal(t, http.StatusUnauthorized, request((*testUser)(nil), nil))
+	require.Equal(t, http.StatusInternalServerError, request(nil, errors.New("boom")))
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+)
+
+// impersonatedUser is a middleware that authenticates impersonation sessions against
+// the real current user, during a session CurrentUser returns the impersonated user
+// while the real user is kept as the security actor, it needs to run inside the
+// transaction since the real user is looked up to check the session
+func impersonatedUser(manager *impersonation.Manager, render common.Renderer, logger zerolog.Logger, userID func(user interface{}) uuid.UUID, getter func(ctx context.Context, claims *ClaimsOrToken) (interface{}, error)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if manager == nil {
+			return next
+		}
+		realUser := func(r *http.Request) (uuid.UUID, error) {
+			current, err := CurrentUser(r.Context())
+			if err != nil || current == nil {
+				return uuid.UUID{}, err
+			}
+			return userID(current), nil
+		}
+		impersonated := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := impersonation.FromContext(r.Context())
+			next.ServeHTTP(w, r.Clone(SetCurrentUserFn(r.Context(), func(ctx context.Context) (interface{}, error) {
+				return getter(ctx, &ClaimsOrToken{Kind: security.PrincipalUser, Impersonation: session})
+			})))
+		})
+		authenticated := impersonation.Middleware(manager, render, logger, realUser)(impersonated)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(impersonation.Header) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			if tokens.FromContext(ctx) != nil || accounts.FromContext(ctx) != nil || getToken(ctx) != "" {
+				render.Error(w, http.StatusForbidden, "only users can impersonate other users")
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi"
+	migrate "github.com/golang-migrate/migrate/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/server/middleware"
+	"github.com/andrewstucki/web-app-tools/go/sql"
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+	"github.com/andrewstucki/web-app-tools/go/sql/migrator"
+	sqlSecurity "github.com/andrewstucki/web-app-tools/go/sql/security"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+)
+
+const defaultShutdownTimeout = 15 * time.Second
+
+var (
+	// ErrServerStarted is returned when starting a server more than once
+	ErrServerStarted = errors.New("server already started")
+)
+
+// Hook is a lifecycle hook, start hooks are given a context that is canceled
+// once the server begins shutting down
+type Hook func(ctx context.Context) error
+
+// Server runs the api, oauth and asset routes described by a Config and drains
+// them on shutdown
+type Server struct {
+	config Config
+	logger zerolog.Logger
+
+	sources  ConfigSources
+	db       *sqlx.DB
+	verifier oauth.Verifier
+	clock    func() time.Time
+	handler  http.Handler
+	http     *http.Server
+	// certificates is set when serving tls
+	certificates *certificateReloader
+	closers      []io.Closer
+
+	onStart []Hook
+	onStop  []Hook
+
+	// transactions counts the transactions started by the server
+	// so that shutdown can wait for them to finish
+	transactions sync.WaitGroup
+
+	mutex    sync.Mutex
+	started  bool
+	cancel   context.CancelFunc
+	shutdown sync.Once
+	err      error
+}
+
+// New loads and validates the config, migrates and connects to the database and
+// wires up the oauth, api and asset routes, nothing is listened on until the server
+// is started so it can be used directly as an http.Handler
+func New(config Config, options ...Option) (*Server, error) {
+	s := &Server{
+		config:  config,
+		sources: DefaultConfigSources(),
+		logger:  zerolog.New(os.Stdout),
+		clock:   time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	loaded, err := LoadConfig(s.config, s.sources)
+	if err != nil {
+		return nil, err
+	}
+	// the database settings aren't needed when a database is given
+	if err := loaded.validate(s.db == nil); err != nil {
+		return nil, err
+	}
+	s.config = loaded
+	s.logger.Debug().Interface("config", loaded.Redacted()).Msg("loaded config")
+
+	if err := s.setup(); err != nil {
+		s.closeResources()
+		return nil, err
+	}
+	return s, nil
+}
+
+// ServeHTTP serves a request with the server's router
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// OnStart registers a hook that runs after setup and before the server starts
+// listening, if a hook fails the server is shut down and the error is returned
+func (s *Server) OnStart(hook Hook) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onStart = append(s.onStart, hook)
+	return s
+}
+
+// OnStop registers a hook that runs once requests and transactions are drained,
+// hooks run in the reverse order of their registration before the database closes
+func (s *Server) OnStop(hook Hook) *Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onStop = append(s.onStop, hook)
+	return s
+}
+
+// Start runs the server, it blocks until the context is canceled,
+// the process receives SIGINT or SIGTERM, or the server fails, and then shuts
+// the server down
+func (s *Server) Start(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.started {
+		s.mutex.Unlock()
+		return ErrServerStarted
+	}
+	s.started = true
+	s.mutex.Unlock()
+
+	listener, err := net.Listen("tcp", s.config.HostPort)
+	if err != nil {
+		s.Shutdown(context.Background())
+		return errors.Wrap(err, "failed to listen")
+	}
+	return s.serve(ctx, listener)
+}
+
+// serve runs the start hooks and serves on the listener until the server is told to stop
+func (s *Server) serve(ctx context.Context, listener net.Listener) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	s.mutex.Lock()
+	s.cancel = cancel
+	s.mutex.Unlock()
+
+	for _, hook := range s.onStart {
+		if err := hook(runCtx); err != nil {
+			listener.Close()
+			s.Shutdown(context.Background())
+			return errors.Wrap(err, "failed to run start hook")
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() {
+		if s.http.TLSConfig != nil {
+			// the certificate comes from the tls config's GetCertificate
+			served <- s.http.ServeTLS(listener, "", "")
+			return
+		}
+		served <- s.http.Serve(listener)
+	}()
+	if s.certificates != nil {
+		go s.certificates.watch(runCtx, certificateCheckInterval, func(err error) {
+			if err != nil {
+				s.logger.Error().Err(err).Msg("error reloading certificate")
+				return
+			}
+			s.logger.Info().Msg("reloaded certificate")
+		})
+	}
+	s.logger.Info().Str("address", listener.Addr().String()).Msg("server started")
+
+	var serveErr error
+	select {
+	case <-runCtx.Done():
+	case received := <-signals:
+		s.logger.Info().Str("signal", received.String()).Msg("shutting down")
+	case serveErr = <-served:
+	}
+
+	timeout := s.config.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+	err := s.Shutdown(shutdownCtx)
+	if serveErr != nil && serveErr != http.ErrServerClosed {
+		return errors.Wrap(serveErr, "failed to run server")
+	}
+	return err
+}
+
+// Shutdown stops accepting requests, waits for in-flight requests and transactions
+// until the context is done, runs the stop hooks and closes the database, it's
+// safe to call more than once and returns the first shutdown's error
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdown.Do(func() {
+		s.mutex.Lock()
+		cancel := s.cancel
+		s.mutex.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+
+		var errs []error
+		if s.http != nil {
+			if err := s.http.Shutdown(ctx); err != nil {
+				errs = append(errs, errors.Wrap(err, "failed to drain requests"))
+			}
+		}
+		if err := s.waitForTransactions(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		for i := len(s.onStop) - 1; i >= 0; i-- {
+			if err := s.onStop[i](ctx); err != nil {
+				errs = append(errs, errors.Wrap(err, "failed to run stop hook"))
+			}
+		}
+		if err := s.closeResources(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, err := range errs {
+			s.logger.Error().Err(err).Msg("error shutting down")
+		}
+		if len(errs) > 0 {
+			s.err = errs[0]
+		}
+	})
+	return s.err
+}
+
+func (s *Server) waitForTransactions(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.transactions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to wait for transactions")
+	}
+}
+
+// closeResources closes the token stores and then the database
+func (s *Server) closeResources() error {
+	var first error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i].Close(); err != nil && first == nil {
+			first = errors.Wrap(err, "failed to close resource")
+		}
+	}
+	s.closers = nil
+	return first
+}
+
+// track wraps a handler in the transaction count
+func (s *Server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.transactions.Add(1)
+		defer s.transactions.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setup connects to the database unless one was given and builds the router
+func (s *Server) setup() error {
+	config := s.config
+	logger := s.logger
+
+	if s.db == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	db := s.db
+
+	manager := sqlSecurity.NewNamespaceManager(db)
+	// apps still using the package level security functions store
+	// their roles in the server's database
+	security.RegisterManager(manager)
+	authorizer := defaultAuthorizer(config, db, manager)
+
+	render := common.NewJSONRenderer()
+	router := chi.NewRouter()
+	setupConfig := &SetupConfig{
+		Render: render,
+		DB:     db,
+		Logger: logger,
+		Clock:  s.clock,
+		Server: s,
+		// every server has its own roles rather than sharing security.Default()
+		Authorizer: authorizer,
+	}
+	if config.Tokens != nil {
+		setupConfig.Tokens = tokens.NewManager(config.Tokens(db)).WithAuthorizer(authorizer).WithClock(s.clock)
+	}
+	if config.ServiceAccounts != nil {
+		setupConfig.ServiceAccounts = accounts.NewManager(config.ServiceAccounts(db), config.BaseURL).WithClock(s.clock)
+	}
+	setupConfig.Bootstrap = bootstrap.NewManager(defaultBootstrapper(config, db)).
+		WithMode(config.SetupMode).
+		WithEmails(config.SetupEmails...).
+		WithOnCommit(sqlContext.OnCommit)
+	setupToken := config.OnFirstUser != nil && setupConfig.Bootstrap.Mode() == bootstrap.ModeSetupToken
+	if setupToken {
+		s.OnStart(logSetupToken(setupConfig.Bootstrap, logger))
+	}
+	if config.Impersonation != nil {
+		setupConfig.Impersonation = impersonation.NewManager(config.Impersonation(db)).WithAuthorizer(authorizer).WithClock(s.clock)
+	}
+
+	handler, err := initializeOAuth(setupConfig, config, s.verifier)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize oauth handler")
+	}
+	s.closers = append(s.closers, handler)
+
+	router.Mount("/oauth", handler)
+	router.Route("/api", func(router chi.Router) {
+		router.Use(
+			middleware.RequestLogger(logger),
+			middleware.Recoverer(render, logger),
+			injectAuthorizer(authorizer),
+			handler.AuthenticationMiddleware(false, func(w http.ResponseWriter) {
+				render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			}),
+			tokenUser(handler, setupConfig.Tokens, render, logger),
+			serviceAccountUser(handler, setupConfig.ServiceAccounts, render, logger),
+			// the current user is looked up lazily, so it can be injected ahead
+			// of the transaction that the lookup runs in
+			currentUser(handler, render, logger, config.GetCurrentUser),
+			s.track,
+			transaction(db, render, logger, config.Session),
+			impersonatedUser(setupConfig.Impersonation, render, logger, config.UserID, config.GetCurrentUser),
+			userActor(handler, render, logger, config.UserID),
+			security.RoleCacheMiddleware,
+		)
+		if setupToken {
+			router.Post("/setup", setupHandler(handler, setupConfig.Bootstrap, render, logger, config.OnFirstUser))
+		}
+		setupConfig.Router = router
+		setupConfig.Handler = handler
+		if config.Setup != nil {
+			config.Setup(setupConfig)
+		}
+	})
+	if config.Assets != nil {
+		router.Handle("/*", http.FileServer(newAssetServer(config.Assets)))
+	}
+
+	s.handler = router
+	s.http = httpServer(config, router)
+	s.http.TLSConfig, s.certificates, err = tlsConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure tls")
+	}
+	return nil
+}
+
+// connect migrates and connects to the configured database
+func (s *Server) connect() error {
+	dbURL := s.config.DatabaseURL
+	migrator, err := migrator.NewBoxMigrator(s.config.Migrations, dbURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to initialize migrator")
+	}
+	err = migrator.Up()
+	// the migrator holds its own connection which is only needed for migrating
+	migrator.Close()
+	if err != nil && err != migrate.ErrNoChange {
+		return errors.Wrap(err, "failed to run migrations")
+	}
+
+	db, err := sql.Connect(dbURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to database")
+	}
+	s.db = db
+	s.closers = append(s.closers, db)
+	return nil
+}
+
+// DB returns the server's database once it has been set up
+func (s *Server) DB() *sqlx.DB {
+	return s.db
+}
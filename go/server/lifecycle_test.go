@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func testServer(t *testing.T, handler http.Handler) (*Server, net.Listener) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &Server{config: Config{ShutdownTimeout: time.Second}, logger: zerolog.Nop()}
+	server.http = &http.Server{Handler: server.track(handler)}
+	return server, listener
+}
+
+func TestServerDrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	server, listener := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+
+	events := []string{}
+	server.closers = append(server.closers, closerFunc(func() error {
+		events = append(events, "close")
+		return nil
+	}))
+	server.OnStart(func(ctx context.Context) error {
+		events = append(events, "start")
+		return nil
+	})
+	server.OnStop(func(ctx context.Context) error {
+		events = append(events, "first stop")
+		return nil
+	})
+	server.OnStop(func(ctx context.Context) error {
+		events = append(events, "second stop")
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.serve(ctx, listener)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		responses <- string(body)
+	}()
+	<-started
+	cancel()
+
+	require.NoError(t, <-stopped)
+	require.Equal(t, "done", <-responses)
+	require.Equal(t, []string{"start", "second stop", "first stop", "close"}, events)
+	// shutting down again is a no-op
+	require.NoError(t, server.Shutdown(context.Background()))
+	require.Len(t, events, 4)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	server, listener := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer close(release)
+	go server.http.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Error(t, server.Shutdown(ctx))
+}
+
+func TestServerStartHookFailure(t *testing.T) {
+	server, listener := testServer(t, http.NotFoundHandler())
+	closed := false
+	server.closers = append(server.closers, closerFunc(func() error {
+		closed = true
+		return nil
+	}))
+	server.OnStart(func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	require.Error(t, server.serve(context.Background(), listener))
+	require.True(t, closed)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// ManifestHandler returns a handler that renders the permission manifest of the
+// user making the request, the manifest's version is used as its ETag so that
+// clients can revalidate cheaply with If-None-Match, the user defaults to the one
+// set on the request context with security.WithActor and a nil authorizer uses
+// the server's one
+func ManifestHandler(authorizer *security.Authorizer, render common.Renderer, logger zerolog.Logger, user func(r *http.Request) (uuid.UUID, error)) http.HandlerFunc {
+	if user == nil {
+		user = security.ActorFromRequest
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := user(r)
+		if err != nil && err != security.ErrNoActor {
+			logger.Error().Err(err).Msg("error getting current user")
+			render.InternalError(w)
+			return
+		}
+		if err == security.ErrNoActor || uuid.Equal(id, uuid.UUID{}) {
+			render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		manifest, err := requestAuthorizer(authorizer, r).Manifest(r.Context(), id)
+		if err != nil {
+			logger.Error().Err(err).Msg("error compiling permission manifest")
+			render.InternalError(w)
+			return
+		}
+
+		etag := `"` + manifest.Version + `"`
+		w.Header().Set("ETag", etag)
+		// the manifest changes whenever the user's roles do, so it has to be revalidated
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		render.Render(w, http.StatusOK, manifest)
+	}
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
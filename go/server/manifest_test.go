@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	"github.com/andrewstucki/web-app-tools/go/security/memory"
+)
+
+func TestManifestHandler(t *testing.T) {
+	viewer := security.Role{Name: "viewer", Policies: []security.Policy{
+		{Resource: "projects/:id", Action: security.ActionRead},
+	}}
+	editor := security.Role{Name: "editor", Policies: []security.Policy{
+		{Resource: "projects/:id", Action: security.ActionUpdate},
+	}}
+	authorizer := security.NewAuthorizer(memory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(viewer, editor))
+	user := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), viewer, user))
+
+	handler := ManifestHandler(authorizer, common.NewJSONRenderer(), zerolog.Nop(), nil)
+	request := func(as uuid.UUID, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/permissions", nil)
+		if !uuid.Equal(as, uuid.UUID{}) {
+			r = r.WithContext(security.WithActor(r.Context(), as))
+		}
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, request(uuid.UUID{}, "").Code)
+	response := request(user, "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), `"pattern":"projects/:"`)
+	etag := response.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	response = request(user, `W/"stale", `+etag)
+	require.Equal(t, http.StatusNotModified, response.Code)
+	require.Empty(t, response.Body.String())
+
+	require.NoError(t, authorizer.SetRole(context.Background(), editor, user))
+	response = request(user, etag)
+	require.Equal(t, http.StatusOK, response.Code)
+	require.NotEqual(t, etag, response.Header().Get("ETag"))
+}
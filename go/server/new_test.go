@@ -0,0 +1,468 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	accountsMemory "github.com/andrewstucki/web-app-tools/go/accounts/memory"
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
+	bootstrapMemory "github.com/andrewstucki/web-app-tools/go/bootstrap/memory"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	impersonationMemory "github.com/andrewstucki/web-app-tools/go/impersonation/memory"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
+	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	securityMemory "github.com/andrewstucki/web-app-tools/go/security/memory"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+	tokensMemory "github.com/andrewstucki/web-app-tools/go/tokens/memory"
+)
+
+// fakeDriver supports transactions that never touch a database
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeConn{}, nil }
+func (fakeConn) Commit() error                             { return nil }
+func (fakeConn) Rollback() error                           { return nil }
+
+func init() {
+	sql.Register("server-test", fakeDriver{})
+}
+
+type fakeVerifier struct {
+	now func() time.Time
+}
+
+func (v *fakeVerifier) VerifyIDToken(token string, claims verifier.GoogleClaims) error {
+	if token != "valid" {
+		return verifier.ErrInvalidToken
+	}
+	standard := claims.(*verifier.StandardClaims)
+	standard.Subject = "subject"
+	standard.Email = "user@example.com"
+	standard.ExpiresAt = v.now().Add(time.Hour).Unix()
+	return nil
+}
+
+// newTestServer fills in the required settings of the config and builds a server
+// on the fake database, it returns a function that makes requests against the
+// server and one that closes the database
+func newTestServer(t *testing.T, config Config, options ...Option) (*Server, func(method, path, body string, header http.Header) *httptest.ResponseRecorder, func()) {
+	db := sqlx.MustOpen("server-test", "")
+	options = append([]Option{WithDB(db), WithLogger(zerolog.Nop())}, options...)
+	handler, err := New(testConfig(config), append(options, WithConfigSources(ConfigSources{Lookup: noEnvironment}))...)
+	require.NoError(t, err)
+
+	request := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for key, values := range header {
+			for _, value := range values {
+				r.Header.Add(key, value)
+			}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	return handler, request, func() {
+		db.Close()
+	}
+}
+
+// testConfig fills in the settings that every config needs
+func testConfig(config Config) Config {
+	config.BaseURL = "http://localhost"
+	config.ClientID = "client"
+	config.ClientSecret = "secret"
+	config.SecretKey = "key"
+	return config
+}
+
+// renderCurrentUser renders the current user or a 401 if there isn't one
+func renderCurrentUser(config *SetupConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := CurrentUser(r.Context())
+		if err != nil || current == nil {
+			config.Render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
+		config.Render.Render(w, http.StatusOK, current)
+	}
+}
+
+func TestNew(t *testing.T) {
+	now := func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	db := sqlx.MustOpen("server-test", "")
+	defer db.Close()
+
+	var clock func() time.Time
+	handler, err := New(Config{
+		BaseURL:      "http://localhost",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		SecretKey:    "key",
+		Setup: func(config *SetupConfig) {
+			clock = config.Clock
+			// every server gets its own authorizer
+			require.NotNil(t, config.Authorizer)
+			require.True(t, config.Authorizer != security.Default())
+			config.Router.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+				require.True(t, config.Authorizer == Authorizer(r.Context()))
+				renderCurrentUser(config)(w, r)
+			})
+		},
+		GetCurrentUser: func(ctx context.Context, claimsOrToken *ClaimsOrToken) (interface{}, error) {
+			if claimsOrToken.Claims == nil {
+				return nil, nil
+			}
+			return claimsOrToken.Claims.Email, nil
+		},
+	}, WithDB(db), WithConfigSources(ConfigSources{Lookup: noEnvironment}), WithVerifier(&fakeVerifier{now: now}), WithClock(now), WithLogger(zerolog.Nop()))
+	require.NoError(t, err)
+	require.Equal(t, now(), clock())
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	response := request("GET", "/api/me", "valid")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `"user@example.com"`, response.Body.String())
+	require.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", "invalid").Code)
+	require.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", "").Code)
+	require.Equal(t, http.StatusFound, request("GET", "/oauth", "").Code)
+	require.Equal(t, http.StatusNotFound, request("GET", "/unknown", "").Code)
+
+	// the injected database is left open
+	require.NoError(t, handler.Shutdown(context.Background()))
+	require.NoError(t, db.Ping())
+
+	_, err = New(Config{}, WithConfigSources(ConfigSources{Lookup: noEnvironment}))
+	require.IsType(t, &ConfigError{}, err)
+}
+
+func TestNewWithTokens(t *testing.T) {
+	reader := security.Role{Name: "reader", Policies: []security.Policy{
+		{Resource: "projects/*", Action: security.ActionRead},
+	}}
+	authorizer := security.NewAuthorizer(securityMemory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(reader))
+	user := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), reader, user))
+
+	var secret string
+	_, request, cleanup := newTestServer(t, Config{
+		Authorizer: func(db *sqlx.DB) *security.Authorizer {
+			return authorizer
+		},
+		Tokens: func(db *sqlx.DB) tokens.Store {
+			return tokensMemory.NewStore()
+		},
+		Setup: func(config *SetupConfig) {
+			_, created, err := config.Tokens.Create(context.Background(), user, "ci", reader.Policies, nil)
+			require.NoError(t, err)
+			secret = created
+			config.Router.Get("/me", renderCurrentUser(config))
+		},
+		GetCurrentUser: func(ctx context.Context, claimsOrToken *ClaimsOrToken) (interface{}, error) {
+			require.Empty(t, claimsOrToken.Token)
+			if claimsOrToken.APIToken == nil {
+				return nil, nil
+			}
+			return claimsOrToken.APIToken.User, nil
+		},
+	})
+	defer cleanup()
+
+	response := request("GET", "/api/me", "", http.Header{tokens.Header: {secret}})
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `"`+user.String()+`"`, response.Body.String())
+	require.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", "", http.Header{tokens.Header: {user.String()}}).Code)
+}
+
+func TestNewWithServiceAccounts(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	reader := security.Role{Name: "reader", Policies: []security.Policy{
+		{Resource: "projects/*", Action: security.ActionRead},
+	}}
+	authorizer := security.NewAuthorizer(securityMemory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(reader))
+
+	var account *accounts.ServiceAccount
+	var key *accounts.Key
+	var secret string
+	_, request, cleanup := newTestServer(t, Config{
+		Authorizer: func(db *sqlx.DB) *security.Authorizer {
+			return authorizer
+		},
+		Tokens: func(db *sqlx.DB) tokens.Store {
+			return tokensMemory.NewStore()
+		},
+		ServiceAccounts: func(db *sqlx.DB) accounts.Store {
+			return accountsMemory.NewStore()
+		},
+		Setup: func(config *SetupConfig) {
+			ctx := context.Background()
+			created, err := config.ServiceAccounts.Create(ctx, uuid.NewV4(), "deployer")
+			require.NoError(t, err)
+			account = created
+			key, err = config.ServiceAccounts.RegisterKey(ctx, account.ID, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+			require.NoError(t, err)
+			// service accounts hold roles like users do and can have api tokens
+			require.NoError(t, authorizer.AddUserToNamespace(ctx, reader, account.Namespace, account.ID))
+			_, secret, err = config.Tokens.CreateFor(ctx, security.PrincipalServiceAccount, account.ID, "ci", []security.Policy{
+				{Resource: security.NamespacedResource(account.Namespace, "projects/*"), Action: security.ActionRead},
+			}, nil)
+			require.NoError(t, err)
+			config.Router.Get("/me", renderCurrentUser(config))
+		},
+		GetCurrentUser: func(ctx context.Context, claimsOrToken *ClaimsOrToken) (interface{}, error) {
+			switch {
+			case claimsOrToken.ServiceAccount != nil:
+				return string(claimsOrToken.Kind) + ":" + claimsOrToken.ServiceAccount.Name, nil
+			case claimsOrToken.APIToken != nil:
+				return string(claimsOrToken.Kind) + ":" + claimsOrToken.APIToken.User.String(), nil
+			}
+			return nil, nil
+		},
+	})
+	defer cleanup()
+
+	assertion, err := accounts.SignAssertion(privateKey, key.ID, account.ID, "http://localhost", time.Now(), time.Minute)
+	require.NoError(t, err)
+	response := request("GET", "/api/me", "", http.Header{"Authorization": {"Bearer " + assertion}})
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `"service_account:deployer"`, response.Body.String())
+
+	assertion, err = accounts.SignAssertion(privateKey, key.ID, account.ID, "http://elsewhere", time.Now(), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", "", http.Header{"Authorization": {"Bearer " + assertion}}).Code)
+
+	response = request("GET", "/api/me", "", http.Header{tokens.Header: {secret}})
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `"service_account:`+account.ID.String()+`"`, response.Body.String())
+}
+
+func TestNewWithImpersonation(t *testing.T) {
+	now := func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	support := security.Role{Name: "support", Policies: []security.Policy{
+		{Resource: "impersonation/*", Action: security.ActionCreate},
+	}}
+	authorizer := security.NewAuthorizer(securityMemory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(support))
+	actor, user := uuid.NewV4(), uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), support, actor))
+
+	config := Config{
+		Authorizer: func(db *sqlx.DB) *security.Authorizer {
+			return authorizer
+		},
+		Impersonation: func(db *sqlx.DB) impersonation.Store {
+			return impersonationMemory.NewStore()
+		},
+		Setup: func(config *SetupConfig) {
+			config.Router.Mount("/impersonation", impersonation.NewRouter(impersonation.RouterConfig{
+				Manager: config.Impersonation,
+				Render:  config.Render,
+				Logger:  config.Logger,
+				Actor: func(r *http.Request) (uuid.UUID, error) {
+					// the real user since sessions can't be nested
+					return actor, nil
+				},
+			}))
+			config.Router.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+				current, err := CurrentUser(r.Context())
+				if err != nil || current == nil {
+					config.Render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+					return
+				}
+				real, _ := security.ActorFrom(r.Context())
+				config.Render.Render(w, http.StatusOK, []interface{}{current, real})
+			})
+		},
+		GetCurrentUser: func(ctx context.Context, claimsOrToken *ClaimsOrToken) (interface{}, error) {
+			switch {
+			case claimsOrToken.Impersonation != nil:
+				return claimsOrToken.Impersonation.User.String(), nil
+			case claimsOrToken.Claims != nil:
+				return actor.String(), nil
+			case claimsOrToken.Token != "":
+				return user.String(), nil
+			}
+			return nil, nil
+		},
+	}
+	_, err := New(testConfig(config), WithConfigSources(ConfigSources{Lookup: noEnvironment}))
+	require.IsType(t, &ConfigError{}, err)
+
+	config.UserID = func(user interface{}) uuid.UUID {
+		return uuid.FromStringOrNil(user.(string))
+	}
+	_, serve, cleanup := newTestServer(t, config, WithVerifier(&fakeVerifier{now: now}), WithClock(now))
+	defer cleanup()
+
+	request := func(method, path, session, body string) *httptest.ResponseRecorder {
+		header := http.Header{"Authorization": {"Bearer valid"}}
+		if session != "" {
+			header.Set(impersonation.Header, session)
+		}
+		return serve(method, path, body, header)
+	}
+	response := request("POST", "/api/impersonation", "", `{"user":"`+user.String()+`","duration":"30m"}`)
+	require.Equal(t, http.StatusCreated, response.Code)
+	session := &impersonation.Session{}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), session))
+
+	// logged in users are their own actor
+	response = request("GET", "/api/me", "", "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `["`+actor.String()+`","`+actor.String()+`"]`, response.Body.String())
+
+	// the impersonated user is the current user and the real user is the actor
+	response = request("GET", "/api/me", session.ID.String(), "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Equal(t, `["`+user.String()+`","`+actor.String()+`"]`, response.Body.String())
+	require.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", uuid.NewV4().String(), "").Code)
+
+	// requests authenticated with api tokens rather than as a user can't impersonate
+	require.Equal(t, http.StatusForbidden, serve("GET", "/api/me", "", http.Header{
+		tokens.Header:        {"legacy"},
+		impersonation.Header: {session.ID.String()},
+	}).Code)
+
+	require.Equal(t, http.StatusNoContent, request("DELETE", "/api/impersonation/"+session.ID.String(), "", "").Code)
+	require.Equal(t, http.StatusUnauthorized, request("GET", "/api/me", session.ID.String(), "").Code)
+}
+
+func TestNewWithSetupToken(t *testing.T) {
+	now := func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	var manager *bootstrap.Manager
+	var firstUsers []string
+	_, serve, cleanup := newTestServer(t, Config{
+		SetupMode: bootstrap.ModeSetupToken,
+		Bootstrap: func(db *sqlx.DB) bootstrap.Bootstrapper {
+			return bootstrapMemory.NewBootstrapper()
+		},
+		Setup: func(config *SetupConfig) {
+			manager = config.Bootstrap
+		},
+		OnFirstUser: func(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, error) {
+			// the hook runs in the request's transaction
+			require.NotNil(t, Tx(ctx))
+			if event.Request.Header.Get("User-Agent") == "blocked" {
+				return oauth.Deny("blocked"), nil
+			}
+			firstUsers = append(firstUsers, event.Claims.Email)
+			return oauth.Allow(), nil
+		},
+	}, WithVerifier(&fakeVerifier{now: now}), WithClock(now))
+	defer cleanup()
+	require.Equal(t, bootstrap.ModeSetupToken, manager.Mode())
+
+	// the token is logged by a start hook
+	token, err := manager.SetupToken(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+
+	request := func(authorization, body string) *httptest.ResponseRecorder {
+		header := http.Header{}
+		if authorization != "" {
+			header.Set("Authorization", "Bearer "+authorization)
+		}
+		if strings.Contains(body, "blocked") {
+			header.Set("User-Agent", "blocked")
+		}
+		return serve("POST", "/api/setup", body, header)
+	}
+	require.Equal(t, http.StatusUnauthorized, request("", `{"token":"`+token+`"}`).Code)
+	require.Equal(t, http.StatusForbidden, request("valid", `{"token":"wrong"}`).Code)
+	// a denial leaves the token usable
+	response := request("valid", `{"token":"`+token+`","blocked":true}`)
+	require.Equal(t, http.StatusForbidden, response.Code)
+	require.Contains(t, response.Body.String(), "blocked")
+	require.Empty(t, firstUsers)
+	require.Equal(t, http.StatusNoContent, request("valid", `{"token":"`+token+`"}`).Code)
+	require.Equal(t, []string{"user@example.com"}, firstUsers)
+	// the token is cleared once the setup's transaction commits
+	initialized, err := manager.Initialized(context.Background())
+	require.NoError(t, err)
+	require.True(t, initialized)
+	cleared, err := manager.SetupToken(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, cleared)
+	require.Equal(t, http.StatusForbidden, request("valid", `{"token":"`+token+`"}`).Code)
+	require.Len(t, firstUsers, 1)
+}
+
+func TestNewAdminRouterActor(t *testing.T) {
+	now := func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	viewer := security.Role{Name: "viewer", Policies: []security.Policy{
+		{Resource: ResourceRoles, Action: security.ActionList},
+	}}
+	authorizer := security.NewAuthorizer(securityMemory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(viewer))
+	user := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), viewer, user))
+
+	_, serve, cleanup := newTestServer(t, Config{
+		Authorizer: func(db *sqlx.DB) *security.Authorizer {
+			return authorizer
+		},
+		Setup: func(config *SetupConfig) {
+			// the router's actor defaults to the logged in user
+			config.Router.Mount("/admin", NewAdminRouter(AdminConfig{
+				Render: config.Render,
+				Logger: config.Logger,
+			}))
+		},
+		GetCurrentUser: func(ctx context.Context, claimsOrToken *ClaimsOrToken) (interface{}, error) {
+			if claimsOrToken.Claims != nil {
+				return user.String(), nil
+			}
+			return nil, nil
+		},
+		UserID: func(user interface{}) uuid.UUID {
+			return uuid.FromStringOrNil(user.(string))
+		},
+	}, WithVerifier(&fakeVerifier{now: now}), WithClock(now))
+	defer cleanup()
+
+	response := serve("GET", "/api/admin/roles", "", http.Header{"Authorization": {"Bearer valid"}})
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), `"name":"viewer"`)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/oauth"
+)
+
+// Option customizes a server built with New
+type Option func(s *Server)
+
+// WithDB uses the given database rather than connecting to the configured one,
+// migrations aren't run and the database is left open when the server shuts down
+func WithDB(db *sqlx.DB) Option {
+	return func(s *Server) {
+		s.db = db
+	}
+}
+
+// WithVerifier verifies id tokens with the given verifier rather than
+// with google's certificates
+func WithVerifier(verifier oauth.Verifier) Option {
+	return func(s *Server) {
+		s.verifier = verifier
+	}
+}
+
+// WithClock uses the given clock rather than time.Now when issuing and
+// checking the expiration of tokens
+func WithClock(clock func() time.Time) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// WithLogger uses the given logger rather than logging to stdout
+func WithLogger(logger zerolog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithConfigSources loads the settings that aren't set explicitly from the given
+// sources rather than from DefaultConfigSources
+func WithConfigSources(sources ConfigSources) Option {
+	return func(s *Server) {
+		s.sources = sources
+	}
+}
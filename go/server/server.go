@@ -3,36 +3,31 @@ package server
 import (
 	"context"
 	"net/http"
-	"os"
-	"strings"
 	"sync"
+	"time"
 
 	rice "github.com/GeertJohan/go.rice"
 	"github.com/go-chi/chi"
-	migrate "github.com/golang-migrate/migrate/v4"
 	"github.com/jmoiron/sqlx"
-	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
 
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
 	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
 	"github.com/andrewstucki/web-app-tools/go/oauth"
 	"github.com/andrewstucki/web-app-tools/go/oauth/callbacks"
 	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
 	"github.com/andrewstucki/web-app-tools/go/security"
-	"github.com/andrewstucki/web-app-tools/go/server/middleware"
-	"github.com/andrewstucki/web-app-tools/go/sql"
+	sqlBootstrap "github.com/andrewstucki/web-app-tools/go/sql/bootstrap"
 	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
-	sqlMiddleware "github.com/andrewstucki/web-app-tools/go/sql/middleware"
-	"github.com/andrewstucki/web-app-tools/go/sql/migrator"
 	sqlSecurity "github.com/andrewstucki/web-app-tools/go/sql/security"
 	"github.com/andrewstucki/web-app-tools/go/sql/state"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
 )
 
-func init() {
-	// ignore the error if no .env file is found
-	godotenv.Load()
-}
-
 type assetServer struct {
 	*rice.Box
 }
@@ -57,6 +52,27 @@ type SetupConfig struct {
 	DB      *sqlx.DB
 	Logger  zerolog.Logger
 	Handler *oauth.Handler
+	// Clock provides the current time, it can be replaced in tests with WithClock
+	Clock func() time.Time
+	// Server can be used to register lifecycle hooks
+	Server *Server
+	// Authorizer is the server's own authorizer, the app's roles should be
+	// registered on it, it's also returned by Authorizer(ctx) in api requests
+	// and login hooks
+	Authorizer *security.Authorizer
+	// Tokens manages api tokens when the config has a token store, it can be
+	// used to mount the token endpoints with tokens.NewRouter
+	Tokens *tokens.Manager
+	// ServiceAccounts manages service accounts and their keys when the config
+	// has a service account store
+	ServiceAccounts *accounts.Manager
+	// Bootstrap decides which login runs OnFirstUser, in ModeSetupToken its
+	// SetupToken is logged at startup and redeemed at /api/setup
+	Bootstrap *bootstrap.Manager
+	// Impersonation manages impersonation sessions when the config has an
+	// impersonation store, it can be used to mount the session endpoints with
+	// impersonation.NewRouter
+	Impersonation *impersonation.Manager
 }
 
 // Config provides the configuration for the server
@@ -72,209 +88,204 @@ type Config struct {
 	Domains        []string
 	Setup          func(config *SetupConfig)
 	GetCurrentUser func(ctx context.Context, claimsOrToken *ClaimsOrToken) (interface{}, error)
-	OnFirstUser    func(ctx context.Context, claims *verifier.StandardClaims) error
-	OnLogin        func(ctx context.Context, claims *verifier.StandardClaims) error
+	// OnFirstUser is called instead of OnLogin for the login that sets the site up
+	// and OnLogin for every other login, both are called in a transaction and are
+	// given the callback request, their decision can deny the login, which rolls
+	// the transaction back, or redirect the user elsewhere
+	OnFirstUser oauth.LoginHook
+	OnLogin     oauth.LoginHook
+	// AllowedRedirects are where users can be sent once they log in, whether by
+	// the redirect parameter or a login hook, it defaults to "/"
+	AllowedRedirects []string
+	// SetupMode decides which login runs OnFirstUser, it defaults to the first login,
+	// bootstrap.ModeEmail only allows the verified SetupEmails and bootstrap.ModeSetupToken
+	// logs a one-time token at startup that a logged in user posts to /api/setup
+	SetupMode   bootstrap.Mode
+	SetupEmails []string
+	// Authorizer builds the server's authorizer, it defaults to one that stores
+	// memberships with the postgres namespace manager
+	Authorizer func(db *sqlx.DB) *security.Authorizer
+	// Bootstrap records whether the site has been set up, it defaults to a postgres
+	// bootstrapper that reads and updates the single row of a "site_settings" table
+	// with an "initialized boolean NOT NULL DEFAULT FALSE" column, which has to be
+	// created by the app's migrations like the example's 000005 migration does
+	Bootstrap func(db *sqlx.DB) bootstrap.Bootstrapper
+	// Session opts into postgres row-level security, it's called at the start of
+	// every api transaction and CurrentUser can be used to look up the user
+	Session func(ctx context.Context) (*sqlSecurity.Session, error)
+	// Tokens opts into hashed api tokens, tokens in the X-Api-Token header are
+	// authenticated against the returned store and passed to GetCurrentUser as
+	// APIToken, without it the raw header is passed along as Token
+	Tokens func(db *sqlx.DB) tokens.Store
+	// ServiceAccounts opts into service accounts, requests with an assertion signed
+	// by a key registered in the returned store as their bearer token are passed to
+	// GetCurrentUser with the service account, assertions must be addressed to BaseURL
+	ServiceAccounts func(db *sqlx.DB) accounts.Store
+	// Impersonation opts into impersonation sessions, requests by a session's actor
+	// with the session's id in the X-Impersonation-Session header are passed to
+	// GetCurrentUser with the session, so CurrentUser returns the impersonated user,
+	// while the actor stays the security actor for audit logs and sensitive checks
+	Impersonation func(db *sqlx.DB) impersonation.Store
+	// UserID returns the id of a user returned by GetCurrentUser, it's required
+	// for impersonation to check that sessions belong to the real current user,
+	// it's also used to set logged in users as the security actor of their requests
+	UserID func(user interface{}) uuid.UUID
+	// ShutdownTimeout bounds how long in-flight requests and transactions are
+	// drained for when shutting down, it defaults to 15 seconds
+	ShutdownTimeout time.Duration
+	// TLSCertFile and TLSKeyFile serve https and http/2 rather than plaintext,
+	// the files are reloaded when they change so certificates can be rotated
+	TLSCertFile string
+	TLSKeyFile  string
+	// ClientCAFile verifies client certificates signed by one of the pem encoded
+	// authorities in the file when a client presents one, see ClientCertificate
+	ClientCAFile string
+	// RequireClientCert rejects connections without a verified client certificate
+	RequireClientCert bool
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout are passed to the
+	// http server, they default to 30 seconds, 10 seconds, 30 seconds and 2 minutes
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
 }
 
-type wrappedCallbacks struct {
-	*callbacks.LocalStorageCallbacks
-	config      *SetupConfig
-	initialHook func(ctx context.Context, claims *verifier.StandardClaims) error
-	hook        func(ctx context.Context, claims *verifier.StandardClaims) error
+// errLoginDenied rolls back the first user transaction when the hook denies the login
+var errLoginDenied = errors.New("login denied")
 
-	mutex       sync.Mutex
-	initialized bool
+// loginHooks runs the first user hook when a login sets the site up and the login
+// hook otherwise, each in a transaction started from the callback request's context,
+// the transaction is rolled back when the hook denies the login
+type loginHooks struct {
+	config       *SetupConfig
+	transactions *sync.WaitGroup
+	initialHook  oauth.LoginHook
+	hook         oauth.LoginHook
 }
 
-func (c *wrappedCallbacks) checkAndInitialize(claims *verifier.StandardClaims) error {
-	tx, ctx, err := sqlContext.StartTx(context.Background(), c.config.DB)
-	if err != nil {
-		return err
-	}
-	if err := tx.GetContext(ctx, &c.initialized, "SELECT initialized FROM site_settings"); err != nil {
-		c.config.Logger.Error().Err(err).Msg("error checking site settings")
-		tx.Rollback()
-		return err
-	}
-	if !c.initialized {
-		if err := c.initialHook(ctx, claims); err != nil {
-			c.config.Logger.Error().Err(err).Msg("error running first user callback")
-			tx.Rollback()
-			return err
-		}
-		if _, err := tx.ExecContext(ctx, "UPDATE site_settings SET initialized = TRUE"); err != nil {
-			c.config.Logger.Error().Err(err).Msg("error updating site settings")
-			tx.Rollback()
-			return err
+func (h *loginHooks) onLogin(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, error) {
+	ctx = WithAuthorizer(ctx, h.config.Authorizer)
+	if h.initialHook != nil {
+		decision, ran, err := h.bootstrap(ctx, event)
+		if err != nil || ran {
+			return decision, err
 		}
 	}
-
-	if err := tx.Commit(); err != nil {
-		c.config.Logger.Error().Err(err).Msg("error committing first user transaction")
-		tx.Rollback()
-		return err
+	if h.hook != nil {
+		return h.callHook(ctx, event)
 	}
-	return nil
+	return oauth.Allow(), nil
 }
 
-func (c *wrappedCallbacks) callHook(claims *verifier.StandardClaims) error {
-	tx, ctx, err := sqlContext.StartTx(context.Background(), c.config.DB)
+// bootstrap runs the first user hook if the login sets the site up, the bootstrapper
+// makes sure that it only runs once across every replica
+func (h *loginHooks) bootstrap(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, bool, error) {
+	h.transactions.Add(1)
+	defer h.transactions.Done()
+
+	tx, ctx, err := sqlContext.StartTx(ctx, h.config.DB)
 	if err != nil {
-		return err
+		return oauth.LoginDecision{}, false, err
 	}
-	if err := c.hook(ctx, claims); err != nil {
-		c.config.Logger.Error().Err(err).Msg("error running login callback")
-		tx.Rollback()
+	decision := oauth.Allow()
+	ran, err := h.config.Bootstrap.Login(ctx, event.Claims, func(ctx context.Context) error {
+		decision, err = h.initialHook(ctx, event)
+		if err == nil && decision.Denied {
+			return errLoginDenied
+		}
 		return err
-	}
-	if err := tx.Commit(); err != nil {
-		c.config.Logger.Error().Err(err).Msg("error committing login transaction")
+	})
+	if err == errLoginDenied {
 		tx.Rollback()
-		return err
+		return decision, true, nil
 	}
-	return nil
-}
-
-func (c *wrappedCallbacks) OnSuccess(w http.ResponseWriter, location, raw string, claims *verifier.StandardClaims) {
-	ran := false
-	if c.initialHook != nil {
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
-
-		if !c.initialized {
-			if err := c.checkAndInitialize(claims); err != nil {
-				c.LocalStorageCallbacks.OnError(w, err)
-				return
-			}
-			c.initialized = true
-			ran = true
-		}
+	if err != nil {
+		h.config.Logger.Error().Err(err).Msg("error running first user callback")
+		tx.Rollback()
+		return oauth.LoginDecision{}, false, err
 	}
-	if !ran && c.hook != nil {
-		if err := c.callHook(claims); err != nil {
-			c.LocalStorageCallbacks.OnError(w, err)
-			return
-		}
+	if err := sqlContext.Commit(ctx); err != nil {
+		h.config.Logger.Error().Err(err).Msg("error committing first user transaction")
+		tx.Rollback()
+		return oauth.LoginDecision{}, false, err
 	}
-	c.LocalStorageCallbacks.OnSuccess(w, location, raw, claims)
+	return decision, ran, nil
 }
 
-// RunServer runs a server with the specified config
-func RunServer(config Config) {
-	logger := zerolog.New(os.Stdout)
+func (h *loginHooks) callHook(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, error) {
+	h.transactions.Add(1)
+	defer h.transactions.Done()
 
-	if config.Migrations == nil {
-		logger.Fatal().Msg("must specify migrations")
-	}
-
-	dbURL := config.DatabaseURL
-	if dbURL == "" {
-		dbURL = os.Getenv("POSTGRES_URL")
-	}
-
-	migrator, err := migrator.NewBoxMigrator(config.Migrations, dbURL)
+	tx, ctx, err := sqlContext.StartTx(ctx, h.config.DB)
 	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to connect to initialize migrator")
-	}
-	if err := migrator.Up(); err != nil && err != migrate.ErrNoChange {
-		logger.Fatal().Err(err).Msg("failed to run migrations")
+		return oauth.LoginDecision{}, err
 	}
-
-	db, err := sql.Connect(dbURL)
+	decision, err := h.hook(ctx, event)
 	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to connect to database")
+		h.config.Logger.Error().Err(err).Msg("error running login callback")
+		tx.Rollback()
+		return oauth.LoginDecision{}, err
 	}
-
-	security.RegisterManager(sqlSecurity.NewNamespaceManager(db))
-
-	render := common.NewJSONRenderer()
-	router := chi.NewRouter()
-	setupConfig := &SetupConfig{
-		Render: render,
-		DB:     db,
-		Logger: logger,
+	if decision.Denied {
+		tx.Rollback()
+		return decision, nil
 	}
-
-	handler, err := initializeOAuth(setupConfig, config)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("failed to initialize oauth handler")
+	if err := sqlContext.Commit(ctx); err != nil {
+		h.config.Logger.Error().Err(err).Msg("error committing login transaction")
+		tx.Rollback()
+		return oauth.LoginDecision{}, err
 	}
+	return decision, nil
+}
 
-	router.Mount("/oauth", handler)
-	router.Route("/api", func(router chi.Router) {
-		router.Use(
-			middleware.RequestLogger(logger),
-			middleware.Recoverer(render, logger),
-			handler.AuthenticationMiddleware(false, func(w http.ResponseWriter) {
-				render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
-			}),
-			tokenUser(handler),
-			sqlMiddleware.Transaction(db, render, logger),
-			currentUser(handler, render, logger, config.GetCurrentUser),
-		)
-		setupConfig.Router = router
-		setupConfig.Handler = handler
-		config.Setup(setupConfig)
-	})
-	if config.Assets != nil {
-		router.Handle("/*", http.FileServer(newAssetServer(config.Assets)))
+// RunServer runs a server with the specified config until the process receives
+// SIGINT or SIGTERM, then drains it
+func RunServer(config Config) error {
+	server, err := New(config)
+	if err != nil {
+		return err
 	}
+	return server.Start(context.Background())
+}
 
-	hostPort := config.HostPort
-	if hostPort == "" {
-		hostPort = os.Getenv("HOST_PORT")
+func initializeOAuth(setup *SetupConfig, config Config, tokenVerifier oauth.Verifier) (*oauth.Handler, error) {
+	if tokenVerifier == nil {
+		tokenVerifier = defaultVerifier(config)
 	}
 
-	if err := http.ListenAndServe(hostPort, router); err != nil {
-		logger.Fatal().Err(err).Msg("failed to run server")
-	}
+	return oauth.New(&oauth.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		MountURL:     config.BaseURL + "/oauth",
+		SecretKey:    config.SecretKey,
+		Verifier:     tokenVerifier,
+		TokenManager: state.NewTokenManager(setup.DB),
+		Callbacks:    callbacks.NewLocalStorageCallbacks(),
+		OnLogin: (&loginHooks{
+			config:       setup,
+			transactions: &setup.Server.transactions,
+			initialHook:  config.OnFirstUser,
+			hook:         config.OnLogin,
+		}).onLogin,
+		AllowedRedirects: config.AllowedRedirects,
+		Logger:           &setup.Logger,
+		Clock:            setup.Clock,
+	})
 }
 
-func initializeOAuth(setup *SetupConfig, config Config) (*oauth.Handler, error) {
+// defaultVerifier returns a google id token verifier restricted to the configured domains
+func defaultVerifier(config Config) *verifier.Verifier {
 	verifier := verifier.NewVerifier()
-	domains := config.Domains
-	if len(config.Domains) == 0 {
-		if envDomains := strings.TrimSpace(os.Getenv("GOOGLE_DOMAINS")); envDomains != "" {
-			domains = strings.Split(envDomains, ",")
-			for i, domain := range domains {
-				domains[i] = strings.TrimSpace(domain)
-			}
-		}
-	}
-	if len(domains) > 0 {
-		verifier = verifier.WithDomains(domains...)
+	if len(config.Domains) > 0 {
+		verifier = verifier.WithDomains(config.Domains...)
 	}
+	return verifier
+}
 
-	clientID := config.ClientID
-	if clientID == "" {
-		clientID = os.Getenv("GOOGLE_CLIENT_ID")
+// defaultBootstrapper returns the configured bootstrapper or one backed by "site_settings"
+func defaultBootstrapper(config Config, db *sqlx.DB) bootstrap.Bootstrapper {
+	if config.Bootstrap != nil {
+		return config.Bootstrap(db)
 	}
-	clientSecret := config.ClientSecret
-	if clientSecret == "" {
-		clientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
-	}
-	baseURL := config.BaseURL
-	if baseURL == "" {
-		baseURL = os.Getenv("BASE_URL")
-	}
-	secretKey := config.SecretKey
-	if secretKey == "" {
-		secretKey = os.Getenv("JWT_SECRET")
-	}
-
-	return oauth.New(&oauth.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		MountURL:     baseURL + "/oauth",
-		SecretKey:    secretKey,
-		Verifier:     verifier,
-		TokenManager: state.NewTokenManager(setup.DB),
-		Callbacks: &wrappedCallbacks{
-			LocalStorageCallbacks: callbacks.NewLocalStorageCallbacks(),
-			config:                setup,
-			initialHook:           config.OnFirstUser,
-			hook:                  config.OnLogin,
-		},
-		Logger: &setup.Logger,
-	})
+	return sqlBootstrap.NewBootstrapper(db)
 }
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/bootstrap"
+	bootstrapMemory "github.com/andrewstucki/web-app-tools/go/bootstrap/memory"
+	"github.com/andrewstucki/web-app-tools/go/oauth"
+	"github.com/andrewstucki/web-app-tools/go/oauth/verifier"
+)
+
+func TestLoginHooks(t *testing.T) {
+	db := sqlx.MustOpen("server-test", "")
+	defer db.Close()
+
+	failed := errors.New("failed")
+	calls := []string{}
+	decide := func(name string) oauth.LoginHook {
+		return func(ctx context.Context, event *oauth.LoginEvent) (oauth.LoginDecision, error) {
+			require.NotNil(t, Tx(ctx))
+			calls = append(calls, name)
+			switch event.Request.UserAgent() {
+			case "denied":
+				return oauth.Deny("denied"), nil
+			case "redirected":
+				return oauth.Redirect("/welcome"), nil
+			case "failed":
+				return oauth.LoginDecision{}, failed
+			}
+			return oauth.Allow(), nil
+		}
+	}
+	server := &Server{}
+	hooks := &loginHooks{
+		config: &SetupConfig{
+			DB:        db,
+			Logger:    zerolog.Nop(),
+			Bootstrap: bootstrap.NewManager(bootstrapMemory.NewBootstrapper()),
+		},
+		transactions: &server.transactions,
+		initialHook:  decide("first"),
+		hook:         decide("login"),
+	}
+	login := func(userAgent string) (oauth.LoginDecision, error) {
+		r := httptest.NewRequest("GET", "/oauth/callback", nil)
+		r.Header.Set("User-Agent", userAgent)
+		return hooks.onLogin(r.Context(), &oauth.LoginEvent{
+			Request:  r,
+			Claims:   &verifier.StandardClaims{Email: "user@example.com"},
+			Provider: oauth.ProviderGoogle,
+		})
+	}
+
+	// the site isn't set up when the first user is denied or the hook fails
+	decision, err := login("denied")
+	require.NoError(t, err)
+	require.Equal(t, oauth.Deny("denied"), decision)
+	_, err = login("failed")
+	require.Equal(t, failed, err)
+	require.Equal(t, []string{"first", "first"}, calls)
+
+	decision, err = login("redirected")
+	require.NoError(t, err)
+	require.Equal(t, "/welcome", decision.Location)
+	decision, err = login("")
+	require.NoError(t, err)
+	require.Equal(t, oauth.Allow(), decision)
+	decision, err = login("denied")
+	require.NoError(t, err)
+	require.True(t, decision.Denied)
+	require.Equal(t, []string{"first", "first", "first", "login", "login"}, calls)
+}
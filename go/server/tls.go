@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultReadTimeout       = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+var (
+	// certificateCheckInterval is how often certificate files are checked for changes
+	certificateCheckInterval = 10 * time.Second
+
+	// ErrInvalidClientCA is returned when the client certificate authority file has no certificates
+	ErrInvalidClientCA = errors.New("no certificates found in client ca file")
+)
+
+// ClientCertificate returns the verified certificate presented by the client,
+// it's nil if the request isn't over mutual tls
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certificateReloader serves a certificate from files, reloading it once
+// either of the files is modified
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	modified    time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// lastModified returns the latest modification time of the files
+func (c *certificateReloader) lastModified() (time.Time, error) {
+	latest := time.Time{}
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the certificate if the files changed since the last load, the
+// current certificate is kept if the files can't be loaded, e.g. when only one of
+// them has been replaced so far
+func (c *certificateReloader) reload() (bool, error) {
+	modified, err := c.lastModified()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check certificate files")
+	}
+	c.mutex.RLock()
+	unchanged := c.certificate != nil && modified.Equal(c.modified)
+	c.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to load certificate")
+	}
+	c.mutex.Lock()
+	c.certificate = &certificate
+	c.modified = modified
+	c.mutex.Unlock()
+	return true, nil
+}
+
+// watch reloads the certificate whenever the files change until the context is done
+func (c *certificateReloader) watch(ctx context.Context, interval time.Duration, onReload func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reloaded, err := c.reload(); reloaded || err != nil {
+				onReload(err)
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, it's used as tls.Config.GetCertificate
+func (c *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.certificate, nil
+}
+
+// tlsConfig returns the tls configuration for the config, or nil when serving plaintext
+func tlsConfig(config Config) (*tls.Config, *certificateReloader, error) {
+	if config.TLSCertFile == "" {
+		return nil, nil, nil
+	}
+	reloader, err := newCertificateReloader(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if config.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read client ca file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, ErrInvalidClientCA
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, reloader, nil
+}
+
+// httpServer returns the http server for the handler with the config's timeouts
+func httpServer(config Config, handler http.Handler) *http.Server {
+	timeout := func(value, fallback time.Duration) time.Duration {
+		if value == 0 {
+			return fallback
+		}
+		return value
+	}
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       timeout(config.ReadTimeout, defaultReadTimeout),
+		ReadHeaderTimeout: timeout(config.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      timeout(config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       timeout(config.IdleTimeout, defaultIdleTimeout),
+	}
+}
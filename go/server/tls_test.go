@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+func newTestCertificate(t *testing.T, name string, parent *testCertificate, usage x509.ExtKeyUsage) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	directory, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	authority := newTestCertificate(t, "authority", nil, x509.ExtKeyUsageAny)
+	serverCertificate := newTestCertificate(t, "server", authority, x509.ExtKeyUsageServerAuth)
+	clientCertificate := newTestCertificate(t, "internal", authority, x509.ExtKeyUsageClientAuth)
+	config := Config{
+		TLSCertFile:  filepath.Join(directory, "server.crt"),
+		TLSKeyFile:   filepath.Join(directory, "server.key"),
+		ClientCAFile: filepath.Join(directory, "ca.crt"),
+	}
+	require.NoError(t, ioutil.WriteFile(config.TLSCertFile, serverCertificate.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(config.TLSKeyFile, serverCertificate.keyPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(config.ClientCAFile, authority.certPEM, 0600))
+
+	server, listener := testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "anonymous"
+		if certificate := ClientCertificate(r); certificate != nil {
+			name = certificate.Subject.CommonName
+		}
+		w.Write([]byte(r.Proto + " " + name))
+	}))
+	server.http.TLSConfig, server.certificates, err = tlsConfig(config)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.serve(ctx, listener)
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-stopped)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.certificate)
+	get := func(certificates ...tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certificates},
+		}}
+		response, err := client.Get("https://" + listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		return string(body), err
+	}
+
+	body, err := get()
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0 anonymous", body)
+	pair, err := tls.X509KeyPair(clientCertificate.certPEM, clientCertificate.keyPEM)
+	require.NoError(t, err)
+	body, err = get(pair)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0 internal", body)
+
+	// rotating the files swaps the certificate without a restart
+	rotated := newTestCertificate(t, "rotated", authority, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, ioutil.WriteFile(config.TLSCertFile, rotated.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(config.TLSKeyFile, rotated.keyPEM, 0600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(config.TLSKeyFile, later, later))
+	reloaded, err := server.certificates.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	current, err := server.certificates.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(current.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, "rotated", leaf.Subject.CommonName)
+	reloaded, err = server.certificates.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	config.RequireClientCert = true
+	required, _, err := tlsConfig(config)
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, required.ClientAuth)
+}
+
+func TestHTTPServerTimeouts(t *testing.T) {
+	server := httpServer(Config{WriteTimeout: time.Minute}, http.NotFoundHandler())
+	require.Equal(t, defaultReadTimeout, server.ReadTimeout)
+	require.Equal(t, defaultReadHeaderTimeout, server.ReadHeaderTimeout)
+	require.Equal(t, time.Minute, server.WriteTimeout)
+	require.Equal(t, defaultIdleTimeout, server.IdleTimeout)
+
+	config, err := LoadConfig(Config{}, ConfigSources{Lookup: func(key string) (string, bool) {
+		switch key {
+		case "READ_TIMEOUT":
+			return "5s", true
+		case "TLS_REQUIRE_CLIENT_CERT":
+			return "true", true
+		case "IDLE_TIMEOUT":
+			return "forever", true
+		}
+		return "", false
+	}})
+	require.Equal(t, &ConfigError{Problems: []string{`IDLE_TIMEOUT must be a duration such as "30s"`}}, err)
+	require.Equal(t, 5*time.Second, config.ReadTimeout)
+	require.True(t, config.RequireClientCert)
+	require.Contains(t, config.Validate().Error(), "TLS_REQUIRE_CLIENT_CERT requires TLS_CLIENT_CA_FILE")
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
 	"github.com/andrewstucki/web-app-tools/go/oauth"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
 )
 
 var (
@@ -23,16 +27,26 @@ func getToken(ctx context.Context) string {
 	return token.(string)
 }
 
-// tokenUser is a middleware that checks for an API token into the context
-func tokenUser(handler *oauth.Handler) func(next http.Handler) http.Handler {
+// tokenUser is a middleware that checks for an API token into the context, when
+// there's a token manager the token is authenticated with it rather than
+// being passed along as is
+func tokenUser(handler *oauth.Handler, manager *tokens.Manager, render common.Renderer, logger zerolog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
+		authenticated := next
+		if manager != nil {
+			authenticated = tokens.Middleware(manager, render, logger)(next)
+		}
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if claims := handler.Claims(r.Context()); claims != nil {
 				// Skip the check for an API token since we're already authed
 				next.ServeHTTP(w, r)
 				return
 			}
-			token := r.Header.Get("X-Api-Token")
+			if manager != nil {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+			token := r.Header.Get(tokens.Header)
 			if token == "" {
 				next.ServeHTTP(w, r)
 				return
@@ -2,10 +2,15 @@ package server
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
 
+	"github.com/andrewstucki/web-app-tools/go/common"
 	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+	sqlMiddleware "github.com/andrewstucki/web-app-tools/go/sql/middleware"
+	sqlSecurity "github.com/andrewstucki/web-app-tools/go/sql/security"
 )
 
 // Tx MUST be called only after using the transaction middleware
@@ -16,3 +21,14 @@ func Tx(ctx context.Context) *sqlx.Tx {
 	}
 	return tx
 }
+
+// transaction returns the transaction middleware, setting the row-level
+// security session on each transaction if a session callback is given
+func transaction(db *sqlx.DB, render common.Renderer, logger zerolog.Logger, session func(ctx context.Context) (*sqlSecurity.Session, error)) func(next http.Handler) http.Handler {
+	if session == nil {
+		return sqlMiddleware.Transaction(db, render, logger)
+	}
+	return sqlMiddleware.TransactionWithSession(db, render, logger, func(r *http.Request) (*sqlSecurity.Session, error) {
+		return session(r.Context())
+	})
+}
@@ -0,0 +1,151 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	persistServiceAccount = `
+	INSERT INTO service_accounts (id, namespace_id, name, created_at)
+		VALUES (:id, :namespace_id, :name, :created_at);
+	`
+	getServiceAccount = `
+	SELECT id, namespace_id, name, created_at FROM service_accounts WHERE id = $1;
+	`
+	listServiceAccounts = `
+	SELECT id, namespace_id, name, created_at FROM service_accounts
+	WHERE namespace_id = $1
+	ORDER BY name;
+	`
+	deleteServiceAccountKeys = `
+	DELETE FROM service_account_keys WHERE account_id = $1;
+	`
+	deleteServiceAccount = `
+	DELETE FROM service_accounts WHERE id = $1;
+	`
+	persistKey = `
+	INSERT INTO service_account_keys (id, account_id, public_key, created_at)
+		VALUES (:id, :account_id, :public_key, :created_at);
+	`
+	getKey = `
+	SELECT id, account_id, public_key, created_at FROM service_account_keys WHERE id = $1;
+	`
+	removeKey = `
+	DELETE FROM service_account_keys WHERE id = $1 AND account_id = $2;
+	`
+)
+
+// Store is a service account store that
+// writes accounts to a SQL database, it expects
+// to have "service_accounts" and "service_account_keys"
+// to read/write from
+type Store struct {
+	db *sqlx.DB
+}
+
+// NewStore creates a new service account store from the given
+// database
+func NewStore(db *sqlx.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// CreateServiceAccount stores a new service account
+func (s *Store) CreateServiceAccount(ctx context.Context, account *accounts.ServiceAccount) error {
+	return s.named(ctx, persistServiceAccount, account)
+}
+
+// GetServiceAccount returns the service account with the given id or ErrServiceAccountNotFound
+func (s *Store) GetServiceAccount(ctx context.Context, id uuid.UUID) (*accounts.ServiceAccount, error) {
+	account := &accounts.ServiceAccount{}
+	if err := sqlx.GetContext(ctx, sqlContext.GetQueryer(ctx, s.db), account, getServiceAccount, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, accounts.ErrServiceAccountNotFound
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// ListServiceAccounts returns the service accounts owned by the namespace ordered by name
+func (s *Store) ListServiceAccounts(ctx context.Context, namespace uuid.UUID) ([]accounts.ServiceAccount, error) {
+	found := []accounts.ServiceAccount{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, s.db), &found, listServiceAccounts, namespace); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// DeleteServiceAccount deletes the service account and its keys, it returns
+// ErrServiceAccountNotFound if there's no such service account
+func (s *Store) DeleteServiceAccount(ctx context.Context, id uuid.UUID) error {
+	queryer := sqlContext.GetQueryer(ctx, s.db)
+	if _, err := queryer.ExecContext(ctx, deleteServiceAccountKeys, id); err != nil {
+		return err
+	}
+	result, err := queryer.ExecContext(ctx, deleteServiceAccount, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return accounts.ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+// AddKey stores a new key
+func (s *Store) AddKey(ctx context.Context, key *accounts.Key) error {
+	return s.named(ctx, persistKey, key)
+}
+
+// GetKey returns the key with the given id or ErrKeyNotFound
+func (s *Store) GetKey(ctx context.Context, id string) (*accounts.Key, error) {
+	key := &accounts.Key{}
+	if err := sqlx.GetContext(ctx, sqlContext.GetQueryer(ctx, s.db), key, getKey, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, accounts.ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// RemoveKey deletes the service account's key with the given id, it returns
+// ErrKeyNotFound if the service account has no such key
+func (s *Store) RemoveKey(ctx context.Context, account uuid.UUID, id string) error {
+	result, err := sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, removeKey, id, account)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return accounts.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *Store) named(ctx context.Context, statement string, arg interface{}) error {
+	query, args, err := sqlx.Named(statement, arg)
+	if err != nil {
+		return err
+	}
+	_, err = sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, s.db.Rebind(query), args...)
+	return err
+}
@@ -0,0 +1,59 @@
+package accounts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/accounts"
+	sqlTesting "github.com/andrewstucki/web-app-tools/go/sql/testing"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	sqlTesting.Database(t, postgresURL, func(db *sqlx.DB) {
+		ctx := context.Background()
+		store := NewStore(db)
+		namespace := uuid.NewV4()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+
+		robot := &accounts.ServiceAccount{ID: uuid.NewV4(), Namespace: namespace, Name: "robot", CreatedAt: now}
+		builder := &accounts.ServiceAccount{ID: uuid.NewV4(), Namespace: namespace, Name: "builder", CreatedAt: now}
+		require.NoError(t, store.CreateServiceAccount(ctx, robot))
+		require.NoError(t, store.CreateServiceAccount(ctx, builder))
+
+		found, err := store.GetServiceAccount(ctx, robot.ID)
+		require.NoError(t, err)
+		require.Equal(t, "robot", found.Name)
+		_, err = store.GetServiceAccount(ctx, uuid.NewV4())
+		require.Equal(t, accounts.ErrServiceAccountNotFound, err)
+
+		listed, err := store.ListServiceAccounts(ctx, namespace)
+		require.NoError(t, err)
+		require.Len(t, listed, 2)
+		require.Equal(t, "builder", listed[0].Name)
+		listed, err = store.ListServiceAccounts(ctx, uuid.NewV4())
+		require.NoError(t, err)
+		require.Len(t, listed, 0)
+
+		key := &accounts.Key{ID: "thumbprint", Account: robot.ID, PublicKey: "public key", CreatedAt: now}
+		require.NoError(t, store.AddKey(ctx, key))
+		foundKey, err := store.GetKey(ctx, key.ID)
+		require.NoError(t, err)
+		require.Equal(t, robot.ID, foundKey.Account)
+		require.Equal(t, accounts.ErrKeyNotFound, store.RemoveKey(ctx, builder.ID, key.ID))
+		require.NoError(t, store.RemoveKey(ctx, robot.ID, key.ID))
+		_, err = store.GetKey(ctx, key.ID)
+		require.Equal(t, accounts.ErrKeyNotFound, err)
+
+		// deleting an account deletes its keys
+		require.NoError(t, store.AddKey(ctx, key))
+		require.NoError(t, store.DeleteServiceAccount(ctx, robot.ID))
+		_, err = store.GetKey(ctx, key.ID)
+		require.Equal(t, accounts.ErrKeyNotFound, err)
+		require.Equal(t, accounts.ErrServiceAccountNotFound, store.DeleteServiceAccount(ctx, robot.ID))
+	})
+}
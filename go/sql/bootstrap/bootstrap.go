@@ -0,0 +1,109 @@
+package bootstrap
+
+import (
+	"context"
+
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// DefaultLockKey is the key of the advisory lock held while setting the site up
+	DefaultLockKey = int64(0x626f6f74)
+
+	// DefaultInitializedQuery checks the "site_settings" table used by earlier versions
+	DefaultInitializedQuery = `SELECT initialized FROM site_settings;`
+	// DefaultMarkQuery marks the site as set up in the "site_settings" table
+	DefaultMarkQuery = `UPDATE site_settings SET initialized = TRUE;`
+
+	lockQuery = `SELECT pg_advisory_xact_lock($1);`
+)
+
+// Bootstrapper is a bootstrapper that serializes setup
+// across replicas with a postgres advisory lock, by default
+// it expects to have "site_settings" to read/write from
+type Bootstrapper struct {
+	db          *sqlx.DB
+	lockKey     int64
+	initialized string
+	mark        string
+}
+
+// NewBootstrapper creates a new bootstrapper from the given
+// database
+func NewBootstrapper(db *sqlx.DB) *Bootstrapper {
+	return &Bootstrapper{
+		db:          db,
+		lockKey:     DefaultLockKey,
+		initialized: DefaultInitializedQuery,
+		mark:        DefaultMarkQuery,
+	}
+}
+
+// WithLockKey sets the key of the advisory lock, it only needs changing
+// when the default collides with another advisory lock
+func (b *Bootstrapper) WithLockKey(key int64) *Bootstrapper {
+	b.lockKey = key
+	return b
+}
+
+// WithQueries replaces the "site_settings" queries, initialized must return a
+// single boolean and mark can be empty when running setup is enough to make
+// initialized true, e.g. with "SELECT EXISTS (SELECT 1 FROM users)"
+func (b *Bootstrapper) WithQueries(initialized, mark string) *Bootstrapper {
+	b.initialized = initialized
+	b.mark = mark
+	return b
+}
+
+// Initialized reports whether the site has been set up
+func (b *Bootstrapper) Initialized(ctx context.Context) (bool, error) {
+	initialized := false
+	if err := sqlx.GetContext(ctx, sqlContext.GetQueryer(ctx, b.db), &initialized, b.initialized); err != nil {
+		return false, err
+	}
+	return initialized, nil
+}
+
+// Initialize runs setup and marks the site as set up unless it already is, it uses
+// the transaction on the context if there is one and otherwise starts its own, the
+// advisory lock is held until that transaction ends
+func (b *Bootstrapper) Initialize(ctx context.Context, setup func(ctx context.Context) error) (bool, error) {
+	tx := sqlContext.FromContext(ctx)
+	if tx == nil {
+		started, txCtx, err := sqlContext.StartTx(ctx, b.db)
+		if err != nil {
+			return false, err
+		}
+		ran, err := b.initialize(txCtx, started, setup)
+		if err != nil {
+			started.Rollback()
+			return false, err
+		}
+		if err := sqlContext.Commit(txCtx); err != nil {
+			return false, err
+		}
+		return ran, nil
+	}
+	return b.initialize(ctx, tx, setup)
+}
+
+func (b *Bootstrapper) initialize(ctx context.Context, tx *sqlx.Tx, setup func(ctx context.Context) error) (bool, error) {
+	if _, err := tx.ExecContext(ctx, lockQuery, b.lockKey); err != nil {
+		return false, err
+	}
+	initialized, err := b.Initialized(ctx)
+	if err != nil || initialized {
+		return false, err
+	}
+	if err := setup(ctx); err != nil {
+		return false, err
+	}
+	if b.mark != "" {
+		if _, err := tx.ExecContext(ctx, b.mark); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
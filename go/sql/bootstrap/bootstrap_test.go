@@ -0,0 +1,65 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+	sqlTesting "github.com/andrewstucki/web-app-tools/go/sql/testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrapper(t *testing.T) {
+	sqlTesting.Database(t, postgresURL, func(db *sqlx.DB) {
+		ctx := context.Background()
+		bootstrapper := NewBootstrapper(db)
+
+		// a failed setup leaves the site uninitialized
+		failed := errors.New("failed")
+		_, err := bootstrapper.Initialize(ctx, func(ctx context.Context) error { return failed })
+		require.Equal(t, failed, err)
+		initialized, err := bootstrapper.Initialized(ctx)
+		require.NoError(t, err)
+		require.False(t, initialized)
+
+		// so does a setup inside of a transaction that rolls back
+		tx, txCtx, err := sqlContext.StartTx(ctx, db)
+		require.NoError(t, err)
+		ran, err := bootstrapper.Initialize(txCtx, func(ctx context.Context) error { return nil })
+		require.NoError(t, err)
+		require.True(t, ran)
+		require.NoError(t, tx.Rollback())
+		initialized, err = bootstrapper.Initialized(ctx)
+		require.NoError(t, err)
+		require.False(t, initialized)
+
+		// the advisory lock only lets one of the racing setups run
+		var runs int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := bootstrapper.Initialize(ctx, func(ctx context.Context) error {
+					atomic.AddInt32(&runs, 1)
+					return nil
+				})
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), runs)
+
+		initialized, err = bootstrapper.Initialized(ctx)
+		require.NoError(t, err)
+		require.True(t, initialized)
+		ran, err = bootstrapper.Initialize(ctx, func(ctx context.Context) error { return nil })
+		require.NoError(t, err)
+		require.False(t, ran)
+	})
+}
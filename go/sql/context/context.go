@@ -3,24 +3,78 @@ package context
 import (
 	"context"
 	"database/sql"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 )
 
 var transactionKey = "transaction-context-key"
 
+type transaction struct {
+	tx *sqlx.Tx
+
+	mutex sync.Mutex
+	hooks []func()
+}
+
+func transactionFromContext(ctx context.Context) *transaction {
+	state := ctx.Value(&transactionKey)
+	if state == nil {
+		return nil
+	}
+	return state.(*transaction)
+}
+
 // WithTransaction returns a context with the transaction injected
 func WithTransaction(ctx context.Context, tx *sqlx.Tx) context.Context {
-	return context.WithValue(ctx, &transactionKey, tx)
+	return context.WithValue(ctx, &transactionKey, &transaction{tx: tx})
 }
 
 // FromContext returns a transaction found in the context
 func FromContext(ctx context.Context) *sqlx.Tx {
-	tx := ctx.Value(&transactionKey)
-	if tx == nil {
-		return nil
+	if state := transactionFromContext(ctx); state != nil {
+		return state.tx
+	}
+	return nil
+}
+
+// InTransaction reports whether the context has a transaction
+func InTransaction(ctx context.Context) bool {
+	return FromContext(ctx) != nil
+}
+
+// OnCommit registers fn to run once the transaction on the context is committed
+// through Commit, fn is dropped if the transaction rolls back and runs right away
+// when the context has no transaction
+func OnCommit(ctx context.Context, fn func()) {
+	state := transactionFromContext(ctx)
+	if state == nil {
+		fn()
+		return
+	}
+	state.mutex.Lock()
+	state.hooks = append(state.hooks, fn)
+	state.mutex.Unlock()
+}
+
+// Commit commits the transaction on the context and then runs
+// any functions registered with OnCommit
+func Commit(ctx context.Context) error {
+	state := transactionFromContext(ctx)
+	if state == nil {
+		return sql.ErrTxDone
+	}
+	if err := state.tx.Commit(); err != nil {
+		return err
+	}
+	state.mutex.Lock()
+	hooks := state.hooks
+	state.hooks = nil
+	state.mutex.Unlock()
+	for _, hook := range hooks {
+		hook()
 	}
-	return tx.(*sqlx.Tx)
+	return nil
 }
 
 // StartTx starts a transaction and injects it into the context
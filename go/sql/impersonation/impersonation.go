@@ -0,0 +1,82 @@
+package impersonation
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	persistSession = `
+	INSERT INTO impersonation_sessions (id, actor_id, user_id, reason, created_at, expires_at)
+		VALUES (:id, :actor_id, :user_id, :reason, :created_at, :expires_at);
+	`
+	getSession = `
+	SELECT id, actor_id, user_id, reason, created_at, expires_at, ended_at
+	FROM impersonation_sessions WHERE id = $1;
+	`
+	endSession = `
+	UPDATE impersonation_sessions SET ended_at = $3
+	WHERE id = $1 AND actor_id = $2 AND ended_at IS NULL;
+	`
+)
+
+// Store is an impersonation store that
+// writes sessions to a SQL database, it expects
+// to have "impersonation_sessions" to read/write from
+type Store struct {
+	db *sqlx.DB
+}
+
+// NewStore creates a new impersonation store from the given
+// database
+func NewStore(db *sqlx.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// CreateSession stores a new session
+func (s *Store) CreateSession(ctx context.Context, session *impersonation.Session) error {
+	query, args, err := sqlx.Named(persistSession, session)
+	if err != nil {
+		return err
+	}
+	_, err = sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, s.db.Rebind(query), args...)
+	return err
+}
+
+// GetSession returns the session with the given id or ErrSessionNotFound
+func (s *Store) GetSession(ctx context.Context, id uuid.UUID) (*impersonation.Session, error) {
+	session := &impersonation.Session{}
+	if err := sqlx.GetContext(ctx, sqlContext.GetQueryer(ctx, s.db), session, getSession, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, impersonation.ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// EndSession records when the actor's session was stopped, it returns
+// ErrSessionNotFound if the actor has no such session that is still running
+func (s *Store) EndSession(ctx context.Context, actor, id uuid.UUID, endedAt time.Time) error {
+	result, err := sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, endSession, id, actor, endedAt)
+	if err != nil {
+		return err
+	}
+	ended, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if ended == 0 {
+		return impersonation.ErrSessionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package impersonation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/impersonation"
+	sqlTesting "github.com/andrewstucki/web-app-tools/go/sql/testing"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	sqlTesting.Database(t, postgresURL, func(db *sqlx.DB) {
+		ctx := context.Background()
+		store := NewStore(db)
+		actor, user := uuid.NewV4(), uuid.NewV4()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		// sessions reference the users table
+		for _, id := range []uuid.UUID{actor, user} {
+			db.MustExec(`INSERT INTO users (id, email, google_id) VALUES ($1, $2, $3)`, id, id.String()+"@example.com", id.String())
+		}
+
+		session := &impersonation.Session{
+			ID:        uuid.NewV4(),
+			Actor:     actor,
+			User:      user,
+			Reason:    "ticket 123",
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Hour),
+		}
+		require.NoError(t, store.CreateSession(ctx, session))
+
+		found, err := store.GetSession(ctx, session.ID)
+		require.NoError(t, err)
+		require.Equal(t, session.User, found.User)
+		require.Equal(t, "ticket 123", found.Reason)
+		require.Nil(t, found.EndedAt)
+		_, err = store.GetSession(ctx, uuid.NewV4())
+		require.Equal(t, impersonation.ErrSessionNotFound, err)
+
+		require.Equal(t, impersonation.ErrSessionNotFound, store.EndSession(ctx, session.User, session.ID, now))
+		require.NoError(t, store.EndSession(ctx, actor, session.ID, now))
+		found, err = store.GetSession(ctx, session.ID)
+		require.NoError(t, err)
+		require.True(t, now.Equal(*found.EndedAt))
+		// sessions can only be ended once
+		require.Equal(t, impersonation.ErrSessionNotFound, store.EndSession(ctx, actor, session.ID, now))
+	})
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/andrewstucki/web-app-tools/go/common"
 	"github.com/andrewstucki/web-app-tools/go/sql/context"
+	"github.com/andrewstucki/web-app-tools/go/sql/security"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/jmoiron/sqlx"
@@ -14,6 +15,14 @@ import (
 // Transaction is a middleware that wraps a handler in a transaction and commits
 // the transaction if the status code to return is in the 2xx-3xx range
 func Transaction(db *sqlx.DB, renderer common.Renderer, logger zerolog.Logger) func(next http.Handler) http.Handler {
+	return TransactionWithSession(db, renderer, logger, nil)
+}
+
+// TransactionWithSession works like Transaction but also sets the row-level security
+// settings on each transaction to the session returned by resolve, the request passed
+// to resolve already has the transaction in its context, a nil session hides every
+// namespaced row
+func TransactionWithSession(db *sqlx.DB, renderer common.Renderer, logger zerolog.Logger, resolve func(r *http.Request) (*security.Session, error)) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
@@ -24,12 +33,21 @@ func Transaction(db *sqlx.DB, renderer common.Renderer, logger zerolog.Logger) f
 				return
 			}
 			txCtx := context.WithTransaction(ctx, tx)
+			r = r.Clone(txCtx)
+			if resolve != nil {
+				if err := setSession(r, resolve); err != nil {
+					logger.Error().Err(err).Msg("error while setting row-level security session")
+					tx.Rollback()
+					renderer.Error(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					return
+				}
+			}
 			wrapped := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
-			next.ServeHTTP(wrapped, r.Clone(txCtx))
+			next.ServeHTTP(wrapped, r)
 
 			status := wrapped.Status()
 			if 200 <= status && status < 400 {
-				if err := tx.Commit(); err != nil {
+				if err := context.Commit(txCtx); err != nil {
 					logger.Error().Err(err).Msg("error while starting transaction")
 					renderer.Error(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 				}
@@ -42,3 +60,11 @@ func Transaction(db *sqlx.DB, renderer common.Renderer, logger zerolog.Logger) f
 		})
 	}
 }
+
+func setSession(r *http.Request, resolve func(r *http.Request) (*security.Session, error)) error {
+	session, err := resolve(r)
+	if err != nil {
+		return err
+	}
+	return security.SetSession(r.Context(), session)
+}
@@ -0,0 +1,82 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	persistAuditEvent = `
+	INSERT INTO audit_events (kind, actor_id, subject_id, subject_kind, namespace_id, group_id, role, action, resource, allowed, policy, created_at)
+		VALUES (:kind, :actor_id, :subject_id, :subject_kind, :namespace_id, :group_id, :role, :action, :resource, :allowed, :policy, :created_at);
+	`
+	getAuditEvents = `
+	SELECT kind, actor_id, subject_id, subject_kind, namespace_id, group_id, role, action, resource, allowed, policy, created_at
+	FROM audit_events
+	`
+)
+
+// AuditLog is an audit sink that writes
+// events to a SQL database, it expects
+// to have "audit_events" to read/write
+// from
+type AuditLog struct {
+	db *sqlx.DB
+}
+
+// NewAuditLog creates a new audit log from the given
+// database
+func NewAuditLog(db *sqlx.DB) *AuditLog {
+	return &AuditLog{
+		db: db,
+	}
+}
+
+// Record stores the audit event in the transaction on the context
+// so that changes that get rolled back aren't recorded
+func (l *AuditLog) Record(ctx context.Context, event *security.AuditEvent) error {
+	query, args, err := sqlx.Named(persistAuditEvent, event)
+	if err != nil {
+		return err
+	}
+	_, err = sqlContext.GetQueryer(ctx, l.db).ExecContext(ctx, l.db.Rebind(query), args...)
+	return err
+}
+
+// History returns events matching the query, newest first
+func (l *AuditLog) History(ctx context.Context, query security.AuditQuery) ([]security.AuditEvent, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if query.Actor != nil {
+		args = append(args, *query.Actor)
+		conditions = append(conditions, "actor_id = $"+strconv.Itoa(len(args)))
+	}
+	if query.Subject != nil {
+		args = append(args, *query.Subject)
+		conditions = append(conditions, "subject_id = $"+strconv.Itoa(len(args)))
+	}
+	statement := getAuditEvents
+	if len(conditions) > 0 {
+		statement += "WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY created_at DESC"
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		statement += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	events := []security.AuditEvent{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, l.db), &events, statement, args...); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,115 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	persistElevation = `
+	INSERT INTO elevations (id, user_id, namespace_id, role, reason, duration, status, requested_at)
+		VALUES (:id, :user_id, :namespace_id, :role, :reason, :duration, :status, :requested_at);
+	`
+	getElevation = `
+	SELECT id, user_id, namespace_id, role, reason, duration, status, requested_at, decided_by, decided_at
+	FROM elevations WHERE id = $1;
+	`
+	decideElevation = `
+	UPDATE elevations SET status = $2, decided_by = $3, decided_at = $4
+	WHERE id = $1 AND status = 'pending';
+	`
+	getPendingElevations = `
+	SELECT id, user_id, namespace_id, role, reason, duration, status, requested_at, decided_by, decided_at
+	FROM elevations WHERE status = 'pending'
+	ORDER BY requested_at;
+	`
+)
+
+// ElevationStore is an elevation store that
+// writes requests to a SQL database, it expects
+// to have "elevations" to read/write from
+type ElevationStore struct {
+	db *sqlx.DB
+}
+
+// NewElevationStore creates a new elevation store from the given
+// database
+func NewElevationStore(db *sqlx.DB) *ElevationStore {
+	return &ElevationStore{
+		db: db,
+	}
+}
+
+// CreateElevation stores a new elevation request
+func (s *ElevationStore) CreateElevation(ctx context.Context, elevation *security.Elevation) error {
+	query, args, err := sqlx.Named(persistElevation, elevation)
+	if err != nil {
+		return err
+	}
+	_, err = sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, s.db.Rebind(query), args...)
+	return err
+}
+
+// GetElevation returns the elevation request with the given id or ErrElevationNotFound
+func (s *ElevationStore) GetElevation(ctx context.Context, id uuid.UUID) (*security.Elevation, error) {
+	elevation := &security.Elevation{}
+	if err := sqlx.GetContext(ctx, sqlContext.GetQueryer(ctx, s.db), elevation, getElevation, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, security.ErrElevationNotFound
+		}
+		return nil, err
+	}
+	return elevation, nil
+}
+
+// DecideElevation records the decision on a pending elevation request, it
+// returns ErrElevationDecided if the request is no longer pending
+func (s *ElevationStore) DecideElevation(ctx context.Context, elevation *security.Elevation) error {
+	result, err := sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, decideElevation, elevation.ID, elevation.Status, elevation.DecidedBy, elevation.DecidedAt)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return security.ErrElevationDecided
+	}
+	return nil
+}
+
+// InTransaction runs fn in the transaction on the context, starting one
+// when there isn't one, it rolls back if fn fails
+func (s *ElevationStore) InTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if sqlContext.FromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	tx, txCtx, err := sqlContext.StartTx(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if err := fn(txCtx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return sqlContext.Commit(txCtx)
+}
+
+// PendingElevations returns every elevation request awaiting a decision,
+// oldest first
+func (s *ElevationStore) PendingElevations(ctx context.Context) ([]security.Elevation, error) {
+	elevations := []security.Elevation{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, s.db), &elevations, getPendingElevations); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return elevations, nil
+}
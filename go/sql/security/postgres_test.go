@@ -0,0 +1,15 @@
+package security
+
+import (
+	"testing"
+
+	sqlTesting "github.com/andrewstucki/web-app-tools/go/sql/testing"
+)
+
+// postgresURL is empty when no database could be found or started,
+// in which case the database tests are skipped
+var postgresURL string
+
+func TestMain(m *testing.M) {
+	sqlTesting.Main(m, "security-sql-test", &postgresURL)
+}
@@ -0,0 +1,139 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// UserIDSetting is the session setting that holds the current user's id
+	UserIDSetting = "app.user_id"
+	// NamespaceIDsSetting is the session setting that holds a comma separated
+	// list of the namespaces the current user holds a role in
+	NamespaceIDsSetting = "app.namespace_ids"
+
+	setSession = `
+	SELECT set_config($1, $2, true), set_config($3, $4, true);
+	`
+)
+
+var (
+	// ErrNoTransaction is returned when setting a session without a transaction in the context
+	ErrNoTransaction = errors.New("row-level security settings require a transaction")
+)
+
+// Session holds the values exposed to row-level security policies
+// for the duration of a transaction
+type Session struct {
+	User       uuid.UUID
+	Namespaces []uuid.UUID
+}
+
+// SetSession sets the row-level security settings on the transaction in the
+// context, the settings are local to the transaction, a nil session clears them
+// so that policies hide every namespaced row
+func SetSession(ctx context.Context, session *Session) error {
+	tx := sqlContext.FromContext(ctx)
+	if tx == nil {
+		return ErrNoTransaction
+	}
+	user, namespaces := "", ""
+	if session != nil {
+		user = session.User.String()
+		ids := make([]string, len(session.Namespaces))
+		for i, id := range session.Namespaces {
+			ids[i] = id.String()
+		}
+		namespaces = strings.Join(ids, ",")
+	}
+	_, err := tx.ExecContext(ctx, setSession, UserIDSetting, user, NamespaceIDsSetting, namespaces)
+	return err
+}
+
+// RowLevelSecurity describes the row-level security policy for
+// a table whose rows belong to a namespace
+type RowLevelSecurity struct {
+	// Table is the name of the table to protect
+	Table string
+	// NamespaceColumn is the column holding the row's namespace, it
+	// defaults to "namespace_id"
+	NamespaceColumn string
+	// GlobalRoles are the roles that can see every row when held
+	// directly by the user in the global namespace
+	GlobalRoles []string
+}
+
+func (p RowLevelSecurity) policyName() string {
+	return pq.QuoteIdentifier(p.Table + "_namespace_isolation")
+}
+
+// Up returns the statements that enable row-level security on the table and
+// restrict it to the session's namespaces, rows stay hidden from connections
+// that never set a session
+func (p RowLevelSecurity) Up() string {
+	table := pq.QuoteIdentifier(p.Table)
+	column := p.NamespaceColumn
+	if column == "" {
+		column = "namespace_id"
+	}
+	condition := fmt.Sprintf(
+		"%s.%s = ANY (string_to_array(NULLIF(current_setting('%s', true), ''), ',')::uuid[])",
+		table, pq.QuoteIdentifier(column), NamespaceIDsSetting,
+	)
+	if len(p.GlobalRoles) > 0 {
+		roles := make([]string, len(p.GlobalRoles))
+		for i, role := range p.GlobalRoles {
+			roles[i] = pq.QuoteLiteral(role)
+		}
+		condition += fmt.Sprintf(`
+    OR EXISTS (
+      SELECT 1 FROM memberships
+      WHERE memberships.namespace_id = '%s'
+      AND memberships.user_id = NULLIF(current_setting('%s', true), '')::uuid
+      AND memberships.role IN (%s)
+    )`, uuid.UUID{}, UserIDSetting, strings.Join(roles, ", "))
+	}
+	return fmt.Sprintf(`ALTER TABLE %[1]s ENABLE ROW LEVEL SECURITY;
+ALTER TABLE %[1]s FORCE ROW LEVEL SECURITY;
+CREATE POLICY %[2]s ON %[1]s
+  USING (
+    %[3]s
+  );
+`, table, p.policyName(), condition)
+}
+
+// Down returns the statements that undo Up
+func (p RowLevelSecurity) Down() string {
+	table := pq.QuoteIdentifier(p.Table)
+	return fmt.Sprintf(`DROP POLICY IF EXISTS %[2]s ON %[1]s;
+ALTER TABLE %[1]s NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE %[1]s DISABLE ROW LEVEL SECURITY;
+`, table, p.policyName())
+}
+
+// WriteRowLevelSecurityMigration writes a golang-migrate up and down migration
+// with the given version and name to the directory that applies the policies
+func WriteRowLevelSecurityMigration(directory string, version uint, name string, policies ...RowLevelSecurity) error {
+	up, down := []string{}, []string{}
+	for _, policy := range policies {
+		up = append(up, policy.Up())
+	}
+	// undo the policies in reverse order
+	for i := len(policies) - 1; i >= 0; i-- {
+		down = append(down, policies[i].Down())
+	}
+	prefix := filepath.Join(directory, fmt.Sprintf("%06d_%s", version, name))
+	if err := ioutil.WriteFile(prefix+".up.sql", []byte(strings.Join(up, "\n")), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(prefix+".down.sql", []byte(strings.Join(down, "\n")), 0644)
+}
@@ -0,0 +1,75 @@
+package security
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+	sqlTesting "github.com/andrewstucki/web-app-tools/go/sql/testing"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowLevelSecurityMigration(t *testing.T) {
+	directory, err := ioutil.TempDir("", "rls")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+
+	require.NoError(t, WriteRowLevelSecurityMigration(directory, 8, "enable_rls",
+		RowLevelSecurity{Table: "projects", GlobalRoles: []string{"super_admin"}},
+		RowLevelSecurity{Table: "invoices", NamespaceColumn: "account_id"},
+	))
+
+	up, err := ioutil.ReadFile(filepath.Join(directory, "000008_enable_rls.up.sql"))
+	require.NoError(t, err)
+	require.Contains(t, string(up), `ALTER TABLE "projects" FORCE ROW LEVEL SECURITY;`)
+	require.Contains(t, string(up), `CREATE POLICY "projects_namespace_isolation" ON "projects"`)
+	require.Contains(t, string(up), `memberships.role IN ('super_admin')`)
+	require.Contains(t, string(up), `"invoices"."account_id" = ANY`)
+
+	down, err := ioutil.ReadFile(filepath.Join(directory, "000008_enable_rls.down.sql"))
+	require.NoError(t, err)
+	require.Regexp(t, `(?s)"invoices_namespace_isolation".*"projects_namespace_isolation"`, string(down))
+
+	require.Equal(t, ErrNoTransaction, SetSession(context.Background(), nil))
+}
+
+func TestRowLevelSecuritySession(t *testing.T) {
+	sqlTesting.Database(t, postgresURL, func(db *sqlx.DB) {
+		policy := RowLevelSecurity{Table: "projects", GlobalRoles: []string{"super_admin"}}
+		db.MustExec(`CREATE TABLE projects (id uuid PRIMARY KEY, namespace_id uuid NOT NULL)`)
+		defer db.MustExec(`DROP TABLE projects`)
+		db.MustExec(policy.Up())
+		// superusers bypass row-level security so queries run as a restricted role
+		db.MustExec(`DROP ROLE IF EXISTS rls_test`)
+		db.MustExec(`CREATE ROLE rls_test NOLOGIN`)
+		defer db.MustExec(`DROP ROLE rls_test`)
+		db.MustExec(`GRANT SELECT ON projects, memberships TO rls_test`)
+		defer db.MustExec(`REVOKE ALL ON projects, memberships FROM rls_test`)
+
+		first, second, user, admin := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+		db.MustExec(`INSERT INTO projects VALUES ($1, $2), ($3, $4)`, uuid.NewV4(), first, uuid.NewV4(), second)
+		db.MustExec(`INSERT INTO memberships VALUES ($1, $2, 'super_admin')`, uuid.UUID{}, admin)
+
+		visible := func(session *Session) int {
+			tx, ctx, err := sqlContext.StartTx(context.Background(), db)
+			require.NoError(t, err)
+			defer tx.Rollback()
+			tx.MustExec(`SET LOCAL ROLE rls_test`)
+			require.NoError(t, SetSession(ctx, session))
+			count := 0
+			require.NoError(t, tx.Get(&count, `SELECT COUNT(*) FROM projects`))
+			return count
+		}
+
+		require.Equal(t, 0, visible(nil))
+		require.Equal(t, 1, visible(&Session{User: user, Namespaces: []uuid.UUID{first}}))
+		require.Equal(t, 2, visible(&Session{User: user, Namespaces: []uuid.UUID{first, second}}))
+		require.Equal(t, 2, visible(&Session{User: admin}))
+	})
+}
@@ -3,6 +3,7 @@ package security
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/andrewstucki/web-app-tools/go/security"
 	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
@@ -29,15 +30,109 @@ const (
 	getRolesAndMembership = `
 	SELECT role, namespace_id
 	FROM memberships WHERE
-	(namespace_id = $2 OR namespace_id = $3) AND user_id = $1;	
+	(namespace_id = $2 OR namespace_id = $3) AND user_id = $1
+	UNION ALL
+	SELECT group_memberships.role, group_memberships.namespace_id
+	FROM group_memberships
+	INNER JOIN user_group_members ON user_group_members.group_id = group_memberships.group_id
+	WHERE (group_memberships.namespace_id = $2 OR group_memberships.namespace_id = $3)
+	AND user_group_members.user_id = $1
+	UNION ALL
+	SELECT role, namespace_id
+	FROM membership_grants WHERE
+	(namespace_id = $2 OR namespace_id = $3) AND user_id = $1
+	AND (expires_at IS NULL OR expires_at > NOW());
+	`
+	getAllRolesAndMemberships = `
+	SELECT role, namespace_id
+	FROM memberships WHERE user_id = $1
+	UNION ALL
+	SELECT group_memberships.role, group_memberships.namespace_id
+	FROM group_memberships
+	INNER JOIN user_group_members ON user_group_members.group_id = group_memberships.group_id
+	WHERE user_group_members.user_id = $1
+	UNION ALL
+	SELECT role, namespace_id
+	FROM membership_grants WHERE user_id = $1
+	AND (expires_at IS NULL OR expires_at > NOW());
+	`
+	getMembers = `
+	SELECT namespace_id, user_id AS principal_id, 'user' AS kind, role, NULL::timestamp with time zone AS expires_at
+	FROM memberships WHERE namespace_id = $1
+	UNION ALL
+	SELECT namespace_id, group_id AS principal_id, 'group' AS kind, role, NULL::timestamp with time zone AS expires_at
+	FROM group_memberships WHERE namespace_id = $1
+	UNION ALL
+	SELECT namespace_id, user_id AS principal_id, 'user' AS kind, role, expires_at
+	FROM membership_grants WHERE namespace_id = $1
+	AND (expires_at IS NULL OR expires_at > NOW());
+	`
+	persistGrant = `
+	INSERT INTO membership_grants (namespace_id, user_id, role, reason, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (namespace_id, user_id, role) DO
+		UPDATE SET reason = EXCLUDED.reason, expires_at = EXCLUDED.expires_at;
+	`
+	deleteGrant = `
+	DELETE FROM membership_grants WHERE
+	namespace_id = $1 AND user_id = $2 AND role = $3;
+	`
+	getGrantsForUser = `
+	SELECT namespace_id, user_id, role, reason, expires_at
+	FROM membership_grants WHERE user_id = $1
+	AND (expires_at IS NULL OR expires_at > NOW());
+	`
+	deleteExpiredGrants = `
+	DELETE FROM membership_grants WHERE expires_at <= $1;
+	`
+	persistGroup = `
+	INSERT INTO user_groups (id, name)
+		VALUES ($1, $2)
+	ON CONFLICT (id) DO
+		UPDATE SET name = EXCLUDED.name;
+	`
+	deleteGroup = `
+	DELETE FROM user_groups WHERE id = $1;
+	`
+	deleteGroupMembers = `
+	DELETE FROM user_group_members WHERE group_id = $1;
+	`
+	deleteGroupMemberships = `
+	DELETE FROM group_memberships WHERE group_id = $1;
+	`
+	getGroupsForUser = `
+	SELECT user_groups.id, user_groups.name
+	FROM user_groups
+	INNER JOIN user_group_members ON user_group_members.group_id = user_groups.id
+	WHERE user_group_members.user_id = $1;
+	`
+	persistGroupMember = `
+	INSERT INTO user_group_members (group_id, user_id)
+		VALUES ($1, $2)
+	ON CONFLICT (group_id, user_id) DO NOTHING;
+	`
+	deleteGroupMember = `
+	DELETE FROM user_group_members WHERE
+	group_id = $1 AND user_id = $2;
+	`
+	persistGroupMembership = `
+	INSERT INTO group_memberships (namespace_id, group_id, role)
+		VALUES ($1, $2, $3)
+	ON CONFLICT (namespace_id, group_id) DO
+		UPDATE SET role = EXCLUDED.role;
+	`
+	deleteGroupMembership = `
+	DELETE FROM group_memberships WHERE
+	namespace_id = $1 AND group_id = $2;
 	`
 )
 
 // NamespaceManager is an abstraction
 // that writes and retrieves data from
 // a SQL database, it expects to have
-// "memberships" to read/write from
-// and "roles" to read from
+// "memberships", "user_groups", "user_group_members",
+// "group_memberships" and "membership_grants" to
+// read/write from
 type NamespaceManager struct {
 	db *sqlx.DB
 }
@@ -62,6 +157,108 @@ func (m *NamespaceManager) RemoveUserFromNamespace(ctx context.Context, id, user
 	return err
 }
 
+// CreateGroup creates a group or renames it if it already exists
+func (m *NamespaceManager) CreateGroup(ctx context.Context, group security.Group) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, persistGroup, group.ID, group.Name)
+	return err
+}
+
+// DeleteGroup removes a group along with its members and roles, it uses the
+// transaction on the context if there is one and otherwise starts its own
+func (m *NamespaceManager) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	if sqlContext.FromContext(ctx) != nil {
+		return m.deleteGroup(ctx, id)
+	}
+	tx, txCtx, err := sqlContext.StartTx(ctx, m.db)
+	if err != nil {
+		return err
+	}
+	if err := m.deleteGroup(txCtx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return sqlContext.Commit(txCtx)
+}
+
+func (m *NamespaceManager) deleteGroup(ctx context.Context, id uuid.UUID) error {
+	queryer := sqlContext.GetQueryer(ctx, m.db)
+	for _, query := range []string{deleteGroupMemberships, deleteGroupMembers, deleteGroup} {
+		if _, err := queryer.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GroupsFor returns all of the groups a user is a member of
+func (m *NamespaceManager) GroupsFor(ctx context.Context, user uuid.UUID) ([]security.Group, error) {
+	groups := []security.Group{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, m.db), &groups, getGroupsForUser, user); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// AddUserToGroup adds a user as a member of a group
+func (m *NamespaceManager) AddUserToGroup(ctx context.Context, group, user uuid.UUID) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, persistGroupMember, group, user)
+	return err
+}
+
+// RemoveUserFromGroup removes a user from a group
+func (m *NamespaceManager) RemoveUserFromGroup(ctx context.Context, group, user uuid.UUID) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, deleteGroupMember, group, user)
+	return err
+}
+
+// AddGroupToNamespace sets the role of a group in the given namespace
+func (m *NamespaceManager) AddGroupToNamespace(ctx context.Context, role security.Role, id, group uuid.UUID) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, persistGroupMembership, id, group, role.Name)
+	return err
+}
+
+// RemoveGroupFromNamespace removes the role of a group in the given namespace
+func (m *NamespaceManager) RemoveGroupFromNamespace(ctx context.Context, id, group uuid.UUID) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, deleteGroupMembership, id, group)
+	return err
+}
+
+// AddGrant stores a grant, replacing any grant of the same role
+// to the user in the namespace
+func (m *NamespaceManager) AddGrant(ctx context.Context, grant security.Grant) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, persistGrant, grant.Namespace, grant.User, grant.Role, grant.Reason, grant.ExpiresAt)
+	return err
+}
+
+// RemoveGrant removes the grant of a role to a user in the given namespace
+func (m *NamespaceManager) RemoveGrant(ctx context.Context, id, user uuid.UUID, role string) error {
+	_, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, deleteGrant, id, user, role)
+	return err
+}
+
+// GrantsFor returns the unexpired grants of a user across every namespace
+func (m *NamespaceManager) GrantsFor(ctx context.Context, user uuid.UUID) ([]security.Grant, error) {
+	grants := []security.Grant{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, m.db), &grants, getGrantsForUser, user); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return grants, nil
+}
+
+// PurgeExpiredGrants deletes every grant that expired by the given time
+// and returns the number of grants deleted
+func (m *NamespaceManager) PurgeExpiredGrants(ctx context.Context, now time.Time) (int64, error) {
+	result, err := sqlContext.GetQueryer(ctx, m.db).ExecContext(ctx, deleteExpiredGrants, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 type role struct {
 	DBName      string    `db:"role"`
 	DBNamespace uuid.UUID `db:"namespace_id"`
@@ -78,7 +275,8 @@ func (r *role) Name() string {
 }
 
 // RolesFor is used in gathering all of the roles for both the global and given namespace for
-// a given user
+// a given user, including any roles granted through the groups the user is a member of and
+// any unexpired grants
 func (m *NamespaceManager) RolesFor(ctx context.Context, globalNamespace, namespace, user uuid.UUID) ([]security.NamespaceRole, error) {
 	var dbRoles []*role
 	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, m.db), &dbRoles, getRolesAndMembership, user, namespace, globalNamespace); err != nil {
@@ -92,3 +290,32 @@ func (m *NamespaceManager) RolesFor(ctx context.Context, globalNamespace, namesp
 	}
 	return roles, nil
 }
+
+// NamespacesFor is used in gathering all of the roles a user holds across every namespace,
+// including any roles granted through the groups the user is a member of and any unexpired
+// grants
+func (m *NamespaceManager) NamespacesFor(ctx context.Context, user uuid.UUID) ([]security.NamespaceRole, error) {
+	var dbRoles []*role
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, m.db), &dbRoles, getAllRolesAndMemberships, user); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	roles := make([]security.NamespaceRole, len(dbRoles))
+	for i, dbRole := range dbRoles {
+		roles[i] = dbRole
+	}
+	return roles, nil
+}
+
+// MembersOf returns the memberships of every user and group that holds a role
+// directly in the given namespace, including any unexpired grants
+func (m *NamespaceManager) MembersOf(ctx context.Context, namespace uuid.UUID) ([]security.Membership, error) {
+	members := []security.Membership{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, m.db), &members, getMembers, namespace); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return members, nil
+}
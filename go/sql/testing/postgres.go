@@ -0,0 +1,147 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	rice "github.com/GeertJohan/go.rice"
+	migrate "github.com/golang-migrate/migrate/v4"
+	"github.com/jmoiron/sqlx"
+	// postgres driver
+	_ "github.com/lib/pq"
+
+	"github.com/andrewstucki/web-app-tools/go/sql/migrator"
+)
+
+const (
+	// migrationsPath holds the example app's migrations, tests run against
+	// them so they can't drift from the schema that's actually shipped
+	migrationsPath = "../../../example/migrations"
+	// lockKey is the advisory lock that keeps packages sharing
+	// POSTGRES_TEST_URL from resetting the schema under each other
+	lockKey = int64(0x74657374)
+)
+
+// StartPostgres uses the database in POSTGRES_TEST_URL if it's set
+// and otherwise starts a throwaway postgres container with docker
+// that holds a database with the given name, the returned function
+// stops the container and is nil when no container was started
+func StartPostgres(database string) (string, func(), error) {
+	if url := os.Getenv("POSTGRES_TEST_URL"); url != "" {
+		return url, nil, nil
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", nil, errors.New("POSTGRES_TEST_URL is not set and docker is not installed")
+	}
+
+	output, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_PASSWORD=postgres",
+		"-e", "POSTGRES_DB="+database,
+		"-p", "127.0.0.1::5432",
+		"postgres:12-alpine",
+	).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("starting postgres container: %v", err)
+	}
+	container := strings.TrimSpace(string(output))
+	stop := func() {
+		exec.Command("docker", "rm", "-f", container).Run()
+	}
+
+	output, err = exec.Command("docker", "port", container, "5432/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("finding postgres port: %v", err)
+	}
+	hostPort := strings.TrimSpace(strings.Split(string(output), "\n")[0])
+	url := fmt.Sprintf("postgres://postgres:postgres@%s/%s?sslmode=disable", hostPort, database)
+
+	// postgres only listens on tcp once its initialization is done
+	deadline := time.Now().Add(time.Minute)
+	for {
+		db, err := sqlx.Connect("postgres", url)
+		if err == nil {
+			db.Close()
+			return url, stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return "", nil, fmt.Errorf("waiting for postgres: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Main runs the tests of a package against a postgres database, url is
+// set to the database or left empty when none could be found or started,
+// in which case the database tests should be skipped
+func Main(m *testing.M, database string, url *string) {
+	found, stop, err := StartPostgres(database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipping postgres tests: %v\n", err)
+	}
+	*url = found
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+// Database connects to the database at url, resets its schema and runs the example
+// app's migrations on it, the test is skipped when url is empty
+func Database(t *testing.T, url string, test func(db *sqlx.DB)) {
+	if url == "" {
+		t.Skip("postgres is not available")
+	}
+	ctx := context.Background()
+	db := sqlx.MustConnect("postgres", url)
+	defer db.Close()
+
+	lock, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	if _, err := lock.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		t.Fatal(err)
+	}
+	defer lock.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if err := resetSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	defer resetSchema(db)
+	if err := migrateUp(url); err != nil {
+		t.Fatal(err)
+	}
+
+	test(db)
+}
+
+func resetSchema(db *sqlx.DB) error {
+	_, err := db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public;`)
+	return err
+}
+
+func migrateUp(url string) error {
+	box, err := rice.FindBox(migrationsPath)
+	if err != nil {
+		return err
+	}
+	migrations, err := migrator.NewBoxMigrator(box, url)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+	if err := migrations.Up(); err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sqlContext "github.com/andrewstucki/web-app-tools/go/sql/context"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	persistToken = `
+	INSERT INTO api_tokens (id, user_id, kind, name, hint, hash, scopes, created_at, expires_at)
+		VALUES (:id, :user_id, :kind, :name, :hint, :hash, :scopes, :created_at, :expires_at);
+	`
+	getToken = `
+	SELECT id, user_id, kind, name, hint, hash, scopes, created_at, expires_at, last_used_at
+	FROM api_tokens WHERE hash = $1;
+	`
+	listTokens = `
+	SELECT id, user_id, kind, name, hint, hash, scopes, created_at, expires_at, last_used_at
+	FROM api_tokens WHERE user_id = $1
+	ORDER BY created_at DESC;
+	`
+	revokeToken = `
+	DELETE FROM api_tokens WHERE id = $1 AND user_id = $2;
+	`
+	touchToken = `
+	UPDATE api_tokens SET last_used_at = $2 WHERE id = $1;
+	`
+)
+
+// Store is a token store that
+// writes tokens to a SQL database, it expects
+// to have "api_tokens" to read/write from
+type Store struct {
+	db *sqlx.DB
+}
+
+// NewStore creates a new token store from the given
+// database
+func NewStore(db *sqlx.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// CreateToken stores a new token
+func (s *Store) CreateToken(ctx context.Context, token *tokens.Token) error {
+	query, args, err := sqlx.Named(persistToken, token)
+	if err != nil {
+		return err
+	}
+	_, err = sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, s.db.Rebind(query), args...)
+	return err
+}
+
+// GetToken returns the token with the given hash or ErrTokenNotFound
+func (s *Store) GetToken(ctx context.Context, hash string) (*tokens.Token, error) {
+	token := &tokens.Token{}
+	if err := sqlx.GetContext(ctx, sqlContext.GetQueryer(ctx, s.db), token, getToken, hash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, tokens.ErrTokenNotFound
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListTokens returns the user's tokens, newest first
+func (s *Store) ListTokens(ctx context.Context, user uuid.UUID) ([]tokens.Token, error) {
+	found := []tokens.Token{}
+	if err := sqlx.SelectContext(ctx, sqlContext.GetQueryer(ctx, s.db), &found, listTokens, user); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// RevokeToken deletes the user's token with the given id, it returns
+// ErrTokenNotFound if the user has no such token
+func (s *Store) RevokeToken(ctx context.Context, user, id uuid.UUID) error {
+	result, err := sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, revokeToken, id, user)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return tokens.ErrTokenNotFound
+	}
+	return nil
+}
+
+// TouchToken records when the token was last used
+func (s *Store) TouchToken(ctx context.Context, id uuid.UUID, usedAt time.Time) error {
+	_, err := sqlContext.GetQueryer(ctx, s.db).ExecContext(ctx, touchToken, id, usedAt)
+	return err
+}
@@ -0,0 +1,68 @@
+package tokens
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+	sqlTesting "github.com/andrewstucki/web-app-tools/go/sql/testing"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	sqlTesting.Database(t, postgresURL, func(db *sqlx.DB) {
+		ctx := context.Background()
+		store := NewStore(db)
+		user, account := uuid.NewV4(), uuid.NewV4()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		expires := now.Add(time.Hour)
+
+		older := &tokens.Token{
+			ID: uuid.NewV4(), User: user, Kind: security.PrincipalUser, Name: "ci",
+			Hint: "wat_older", Hash: "older", CreatedAt: now.Add(-time.Minute),
+		}
+		newer := &tokens.Token{
+			ID: uuid.NewV4(), User: user, Kind: security.PrincipalUser, Name: "deploy",
+			Hint: "wat_newer", Hash: "newer", CreatedAt: now, ExpiresAt: &expires,
+			Scopes: tokens.Scopes{{Resource: "foo", Action: security.ActionRead}},
+		}
+		// service account tokens aren't owned by a user
+		robot := &tokens.Token{
+			ID: uuid.NewV4(), User: account, Kind: security.PrincipalServiceAccount, Name: "robot",
+			Hint: "wat_robot", Hash: "robot", CreatedAt: now,
+		}
+		for _, token := range []*tokens.Token{older, newer, robot} {
+			require.NoError(t, store.CreateToken(ctx, token))
+		}
+
+		found, err := store.GetToken(ctx, "newer")
+		require.NoError(t, err)
+		require.Equal(t, newer.ID, found.ID)
+		require.Equal(t, newer.Scopes, found.Scopes)
+		require.True(t, expires.Equal(*found.ExpiresAt))
+		_, err = store.GetToken(ctx, "missing")
+		require.Equal(t, tokens.ErrTokenNotFound, err)
+		found, err = store.GetToken(ctx, "robot")
+		require.NoError(t, err)
+		require.Equal(t, security.PrincipalServiceAccount, found.Kind)
+
+		listed, err := store.ListTokens(ctx, user)
+		require.NoError(t, err)
+		require.Len(t, listed, 2)
+		require.Equal(t, newer.ID, listed[0].ID)
+
+		require.NoError(t, store.TouchToken(ctx, older.ID, now))
+		found, err = store.GetToken(ctx, "older")
+		require.NoError(t, err)
+		require.True(t, now.Equal(*found.LastUsedAt))
+
+		require.Equal(t, tokens.ErrTokenNotFound, store.RevokeToken(ctx, account, older.ID))
+		require.NoError(t, store.RevokeToken(ctx, user, older.ID))
+		require.Equal(t, tokens.ErrTokenNotFound, store.RevokeToken(ctx, user, older.ID))
+	})
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Store is a token store that keeps
+// every token in memory
+type Store struct {
+	mutex  sync.RWMutex
+	tokens map[uuid.UUID]tokens.Token
+}
+
+// NewStore creates a new token store that
+// stores everything in memory
+func NewStore() *Store {
+	return &Store{
+		tokens: make(map[uuid.UUID]tokens.Token),
+	}
+}
+
+// CreateToken stores a new token
+func (s *Store) CreateToken(ctx context.Context, token *tokens.Token) error {
+	s.mutex.Lock()
+	s.tokens[token.ID] = *token
+	s.mutex.Unlock()
+	return nil
+}
+
+// GetToken returns the token with the given hash or ErrTokenNotFound
+func (s *Store) GetToken(ctx context.Context, hash string) (*tokens.Token, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, token := range s.tokens {
+		if token.Hash == hash {
+			return &token, nil
+		}
+	}
+	return nil, tokens.ErrTokenNotFound
+}
+
+// ListTokens returns the user's tokens, newest first
+func (s *Store) ListTokens(ctx context.Context, user uuid.UUID) ([]tokens.Token, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	found := []tokens.Token{}
+	for _, token := range s.tokens {
+		if uuid.Equal(token.User, user) {
+			found = append(found, token)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].CreatedAt.After(found[j].CreatedAt)
+	})
+	return found, nil
+}
+
+// RevokeToken deletes the user's token with the given id, it returns
+// ErrTokenNotFound if the user has no such token
+func (s *Store) RevokeToken(ctx context.Context, user, id uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || !uuid.Equal(token.User, user) {
+		return tokens.ErrTokenNotFound
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+// TouchToken records when the token was last used
+func (s *Store) TouchToken(ctx context.Context, id uuid.UUID, usedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return tokens.ErrTokenNotFound
+	}
+	token.LastUsedAt = &usedAt
+	s.tokens[id] = token
+	return nil
+}
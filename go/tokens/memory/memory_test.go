@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+	securityMemory "github.com/andrewstucki/web-app-tools/go/security/memory"
+	"github.com/andrewstucki/web-app-tools/go/tokens"
+)
+
+func testManager(t *testing.T) (*tokens.Manager, *security.Authorizer, uuid.UUID, *time.Time) {
+	editor := security.Role{Name: "editor", Policies: []security.Policy{
+		{Resource: "projects/*", Action: security.ActionAll},
+	}}
+	authorizer := security.NewAuthorizer(securityMemory.NewNamespaceManager())
+	require.NoError(t, authorizer.Register(editor))
+	user := uuid.NewV4()
+	require.NoError(t, authorizer.SetRole(context.Background(), editor, user))
+
+	now := time.Now()
+	manager := tokens.NewManager(NewStore()).WithAuthorizer(authorizer).WithClock(func() time.Time {
+		return now
+	})
+	return manager, authorizer, user, &now
+}
+
+func TestManager(t *testing.T) {
+	manager, _, user, now := testManager(t)
+	ctx := context.Background()
+	read := []security.Policy{{Resource: "projects/*", Action: security.ActionRead}}
+
+	_, _, err := manager.Create(ctx, user, "", read, nil)
+	require.Equal(t, tokens.ErrInvalidName, err)
+	_, _, err = manager.Create(ctx, user, "ci", nil, nil)
+	require.Equal(t, tokens.ErrInvalidScope, err)
+	_, _, err = manager.Create(ctx, user, "ci", []security.Policy{{Resource: "users/*", Action: security.ActionRead}}, nil)
+	require.Equal(t, tokens.ErrInvalidScope, err)
+	past := now.Add(-time.Minute)
+	_, _, err = manager.Create(ctx, user, "ci", read, &past)
+	require.Equal(t, tokens.ErrInvalidExpiration, err)
+
+	expiresAt := now.Add(time.Hour)
+	token, secret, err := manager.Create(ctx, user, "ci", read, &expiresAt)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, tokens.DefaultPrefix+"_"))
+	require.True(t, strings.HasPrefix(secret, token.Hint))
+	require.Equal(t, security.PrincipalUser, token.Kind)
+	require.NotContains(t, token.Hash, secret)
+
+	_, err = manager.Authenticate(ctx, "wat_unknown")
+	require.Equal(t, tokens.ErrInvalidToken, err)
+	_, err = manager.Authenticate(ctx, strings.TrimPrefix(secret, tokens.DefaultPrefix+"_"))
+	require.Equal(t, tokens.ErrInvalidToken, err)
+	authenticated, err := manager.Authenticate(ctx, secret)
+	require.NoError(t, err)
+	require.Equal(t, token.ID, authenticated.ID)
+	require.Equal(t, tokens.Scopes(read), authenticated.Scopes)
+
+	listed, err := manager.List(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.NotNil(t, listed[0].LastUsedAt)
+	require.True(t, now.Equal(*listed[0].LastUsedAt))
+
+	*now = expiresAt
+	_, err = manager.Authenticate(ctx, secret)
+	require.Equal(t, tokens.ErrTokenExpired, err)
+
+	require.Equal(t, tokens.ErrTokenNotFound, manager.Revoke(ctx, uuid.NewV4(), token.ID))
+	require.NoError(t, manager.Revoke(ctx, user, token.ID))
+	_, err = manager.Authenticate(ctx, secret)
+	require.Equal(t, tokens.ErrInvalidToken, err)
+}
+
+func TestRouterAndMiddleware(t *testing.T) {
+	manager, authorizer, user, _ := testManager(t)
+	render := common.NewJSONRenderer()
+	router := tokens.NewRouter(tokens.RouterConfig{
+		Manager: manager,
+		Render:  render,
+		Logger:  zerolog.Nop(),
+	})
+	request := func(as uuid.UUID, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if !uuid.Equal(as, uuid.UUID{}) {
+			r = r.WithContext(security.WithActor(r.Context(), as))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusUnauthorized, request(uuid.UUID{}, "GET", "/", "").Code)
+	require.Equal(t, http.StatusBadRequest, request(user, "POST", "/", `{"name":"ci","scopes":[{"resource":"users/*","action":"read"}]}`).Code)
+	require.Equal(t, http.StatusBadRequest, request(user, "POST", "/", `{"name":"ci","expiresIn":"soon"}`).Code)
+	response := request(user, "POST", "/", `{"name":"ci","scopes":[{"resource":"projects/*","action":"read"}],"expiresIn":"1h"}`)
+	require.Equal(t, http.StatusCreated, response.Code)
+	created := tokens.CreateResponse{}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Secret)
+	require.NotNil(t, created.ExpiresAt)
+
+	response = request(user, "GET", "/", "")
+	require.Equal(t, http.StatusOK, response.Code)
+	require.Contains(t, response.Body.String(), created.ID.String())
+	require.NotContains(t, response.Body.String(), created.Secret)
+
+	// requests made with the token are limited to its scope
+	var principal *tokens.Token
+	handler := tokens.Middleware(manager, render, zerolog.Nop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = tokens.FromContext(r.Context())
+		allowed, err := authorizer.WithUser(user).Can(r.Context(), security.Action(r.URL.Query().Get("action")), "projects/1")
+		require.NoError(t, err)
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	authenticated := func(secret, action string) int {
+		r := httptest.NewRequest("GET", "/?action="+action, nil)
+		if secret != "" {
+			r.Header.Set(tokens.Header, secret)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusUnauthorized, authenticated("wat_invalid", "read"))
+	require.Equal(t, http.StatusOK, authenticated("", "delete"))
+	require.Nil(t, principal)
+	require.Equal(t, http.StatusOK, authenticated(created.Secret, "read"))
+	require.Equal(t, created.ID, principal.ID)
+	require.Equal(t, http.StatusForbidden, authenticated(created.Secret, "delete"))
+
+	// tokens can't be used to manage tokens
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r.WithContext(tokens.WithToken(r.Context(), principal)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	require.Equal(t, http.StatusBadRequest, request(user, "DELETE", "/invalid", "").Code)
+	require.Equal(t, http.StatusNotFound, request(user, "DELETE", "/"+uuid.NewV4().String(), "").Code)
+	require.Equal(t, http.StatusNoContent, request(user, "DELETE", "/"+created.ID.String(), "").Code)
+	require.Equal(t, http.StatusUnauthorized, authenticated(created.Secret, "read"))
+}
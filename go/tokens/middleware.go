@@ -0,0 +1,60 @@
+package tokens
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// Header is the header that api tokens are read from
+const Header = "X-Api-Token"
+
+var (
+	tokenKey = "api-token-context-key"
+)
+
+// WithToken returns a context for requests made with the token, the token's owner
+// is set as the actor and every authorization decision is limited to its scopes
+func WithToken(ctx context.Context, token *Token) context.Context {
+	ctx = security.WithActorKind(ctx, token.User, token.Kind)
+	ctx = security.WithScope(ctx, token.Scopes)
+	return context.WithValue(ctx, &tokenKey, token)
+}
+
+// FromContext returns the token set on the context with WithToken
+func FromContext(ctx context.Context) *Token {
+	token := ctx.Value(&tokenKey)
+	if token == nil {
+		return nil
+	}
+	return token.(*Token)
+}
+
+// Middleware authenticates requests that have a token in the X-Api-Token header and
+// sets it on the request context with WithToken, requests without a token are passed
+// along untouched and requests with an invalid or expired token are rejected
+func Middleware(manager *Manager, render common.Renderer, logger zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := r.Header.Get(Header)
+			if secret == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token, err := manager.Authenticate(r.Context(), secret)
+			switch err {
+			case nil:
+				next.ServeHTTP(w, r.Clone(WithToken(r.Context(), token)))
+			case ErrInvalidToken, ErrTokenExpired:
+				render.Error(w, http.StatusUnauthorized, err.Error())
+			default:
+				logger.Error().Err(err).Msg("error authenticating api token")
+				render.InternalError(w)
+			}
+		})
+	}
+}
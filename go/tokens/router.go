@@ -0,0 +1,155 @@
+package tokens
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/common"
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+// RouterConfig provides the configuration for the token router
+type RouterConfig struct {
+	Manager *Manager
+	Render  common.Renderer
+	Logger  zerolog.Logger
+	// User returns the user making the request, it defaults to the
+	// user set on the request context with security.WithActor
+	User func(r *http.Request) (uuid.UUID, error)
+}
+
+// CreateRequest is the body used to create a token for the current user
+type CreateRequest struct {
+	Name   string            `json:"name"`
+	Scopes []security.Policy `json:"scopes"`
+	// ExpiresIn is parsed with time.ParseDuration, e.g. "720h", tokens
+	// created without it never expire
+	ExpiresIn string `json:"expiresIn,omitempty"`
+}
+
+// CreateResponse is a newly created token along with its secret
+type CreateResponse struct {
+	*Token
+	Secret string `json:"secret"`
+}
+
+type tokenHandler struct {
+	manager *Manager
+	render  common.Renderer
+	logger  zerolog.Logger
+	user    func(r *http.Request) (uuid.UUID, error)
+}
+
+func userFromRequest(r *http.Request) (uuid.UUID, error) {
+	user, _ := security.ActorFrom(r.Context())
+	return user, nil
+}
+
+// NewRouter returns a router with endpoints for the current user to list, create
+// and revoke their tokens, requests made with a token can't manage tokens
+func NewRouter(config RouterConfig) chi.Router {
+	handler := &tokenHandler{
+		manager: config.Manager,
+		render:  config.Render,
+		logger:  config.Logger,
+		user:    config.User,
+	}
+	if handler.user == nil {
+		handler.user = userFromRequest
+	}
+
+	router := chi.NewRouter()
+	router.Get("/", handler.list)
+	router.Post("/", handler.create)
+	router.Delete("/{token}", handler.revoke)
+	return router
+}
+
+func (h *tokenHandler) list(w http.ResponseWriter, r *http.Request) {
+	h.authenticated(w, r, func(user uuid.UUID) {
+		tokens, err := h.manager.List(r.Context(), user)
+		if err != nil {
+			h.tokenError(w, err)
+			return
+		}
+		h.render.Render(w, http.StatusOK, tokens)
+	})
+}
+
+func (h *tokenHandler) create(w http.ResponseWriter, r *http.Request) {
+	h.authenticated(w, r, func(user uuid.UUID) {
+		request := CreateRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			h.render.Error(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		var expiresAt *time.Time
+		if request.ExpiresIn != "" {
+			duration, err := time.ParseDuration(request.ExpiresIn)
+			if err != nil {
+				h.render.Error(w, http.StatusBadRequest, "invalid expiration")
+				return
+			}
+			expiration := h.manager.now().Add(duration)
+			expiresAt = &expiration
+		}
+		token, secret, err := h.manager.Create(r.Context(), user, request.Name, request.Scopes, expiresAt)
+		if err != nil {
+			h.tokenError(w, err)
+			return
+		}
+		h.render.Render(w, http.StatusCreated, &CreateResponse{Token: token, Secret: secret})
+	})
+}
+
+func (h *tokenHandler) revoke(w http.ResponseWriter, r *http.Request) {
+	h.authenticated(w, r, func(user uuid.UUID) {
+		id, err := uuid.FromString(chi.URLParam(r, "token"))
+		if err != nil {
+			h.render.Error(w, http.StatusBadRequest, "invalid token")
+			return
+		}
+		if err := h.manager.Revoke(r.Context(), user, id); err != nil {
+			h.tokenError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (h *tokenHandler) tokenError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrTokenNotFound:
+		h.render.Error(w, http.StatusNotFound, err.Error())
+	case ErrInvalidName, ErrInvalidExpiration, ErrInvalidScope:
+		h.render.Error(w, http.StatusBadRequest, err.Error())
+	default:
+		h.logger.Error().Err(err).Msg("error handling api token request")
+		h.render.InternalError(w)
+	}
+}
+
+// authenticated calls inner with the user making the request, tokens can only
+// be managed by users that aren't themselves using a token
+func (h *tokenHandler) authenticated(w http.ResponseWriter, r *http.Request, inner func(user uuid.UUID)) {
+	user, err := h.user(r)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("error getting current user")
+		h.render.InternalError(w)
+		return
+	}
+	if uuid.Equal(user, uuid.UUID{}) {
+		h.render.Error(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+	if FromContext(r.Context()) != nil {
+		h.render.Error(w, http.StatusForbidden, "api tokens can't manage api tokens")
+		return
+	}
+	inner(user)
+}
@@ -0,0 +1,241 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/andrewstucki/web-app-tools/go/security"
+)
+
+const (
+	// DefaultPrefix is the prefix of generated tokens unless one is given with WithPrefix
+	DefaultPrefix = "wat"
+
+	secretBytes = 32
+	hintLength  = 8
+)
+
+var (
+	// ErrTokenNotFound is returned when a token doesn't exist
+	ErrTokenNotFound = errors.New("api token not found")
+	// ErrInvalidToken is returned when authenticating with a malformed or unknown token
+	ErrInvalidToken = errors.New("invalid api token")
+	// ErrTokenExpired is returned when authenticating with a token that has expired
+	ErrTokenExpired = errors.New("api token expired")
+	// ErrInvalidName is returned when creating a token without a name
+	ErrInvalidName = errors.New("api tokens must have a name")
+	// ErrInvalidExpiration is returned when creating a token that is already expired
+	ErrInvalidExpiration = errors.New("api tokens must expire in the future")
+	// ErrInvalidScope is returned when creating a token without a scope or with a
+	// scope that isn't a subset of its owner's policies
+	ErrInvalidScope = errors.New("api token scopes must be a subset of the owner's policies")
+)
+
+// Scopes are the policies a token is limited to, they're stored as json
+type Scopes []security.Policy
+
+// Value implements driver.Valuer
+func (s Scopes) Value() (driver.Value, error) {
+	if s == nil {
+		s = Scopes{}
+	}
+	return json.Marshal(s)
+}
+
+// Scan implements sql.Scanner
+func (s *Scopes) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case []byte:
+		return json.Unmarshal(data, s)
+	case string:
+		return json.Unmarshal([]byte(data), s)
+	case nil:
+		*s = Scopes{}
+		return nil
+	}
+	return errors.Errorf("can't scan %T into scopes", value)
+}
+
+// Token is an api token belonging to a user or service account, only a hash
+// of the secret is kept so the secret is only available when it's created
+type Token struct {
+	ID uuid.UUID `json:"id" db:"id"`
+	// User is the id of the principal that owns the token, Kind says whether
+	// it's a user or a service account
+	User uuid.UUID              `json:"user" db:"user_id"`
+	Kind security.PrincipalKind `json:"kind" db:"kind"`
+	Name string                 `json:"name" db:"name"`
+	// Hint is the start of the secret so that the token can be recognized
+	Hint   string `json:"hint" db:"hint"`
+	Hash   string `json:"-" db:"hash"`
+	Scopes Scopes `json:"scopes" db:"scopes"`
+
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" db:"last_used_at"`
+}
+
+// Expired checks whether the token has an expiration that has passed
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Store is the storage interface for api tokens
+type Store interface {
+	// CreateToken stores a new token
+	CreateToken(ctx context.Context, token *Token) error
+	// GetToken returns the token with the given hash or ErrTokenNotFound
+	GetToken(ctx context.Context, hash string) (*Token, error)
+	// ListTokens returns the user's tokens, newest first
+	ListTokens(ctx context.Context, user uuid.UUID) ([]Token, error)
+	// RevokeToken deletes the user's token with the given id, it returns
+	// ErrTokenNotFound if the user has no such token
+	RevokeToken(ctx context.Context, user, id uuid.UUID) error
+	// TouchToken records when the token was last used
+	TouchToken(ctx context.Context, id uuid.UUID, usedAt time.Time) error
+}
+
+// Manager creates, authenticates and revokes api tokens
+type Manager struct {
+	store      Store
+	authorizer *security.Authorizer
+	prefix     string
+	now        func() time.Time
+}
+
+// NewManager creates a new token manager backed by the store
+func NewManager(store Store) *Manager {
+	return &Manager{
+		store:  store,
+		prefix: DefaultPrefix,
+		now:    time.Now,
+	}
+}
+
+// WithAuthorizer sets the authorizer that token scopes are checked against,
+// it defaults to security.Default()
+func (m *Manager) WithAuthorizer(authorizer *security.Authorizer) *Manager {
+	m.authorizer = authorizer
+	return m
+}
+
+// WithPrefix sets the prefix of generated tokens, it makes the tokens easy
+// to recognize, e.g. by secret scanners
+func (m *Manager) WithPrefix(prefix string) *Manager {
+	m.prefix = prefix
+	return m
+}
+
+// WithClock sets the function used to get the current time
+func (m *Manager) WithClock(now func() time.Time) *Manager {
+	m.now = now
+	return m
+}
+
+func (m *Manager) getAuthorizer() *security.Authorizer {
+	if m.authorizer == nil {
+		return security.Default()
+	}
+	return m.authorizer
+}
+
+// Create creates a token for the user limited to the given scopes, which must be
+// a subset of the user's own policies, it returns the token along with its secret,
+// which can't be retrieved again
+func (m *Manager) Create(ctx context.Context, user uuid.UUID, name string, scopes []security.Policy, expiresAt *time.Time) (*Token, string, error) {
+	return m.CreateFor(ctx, security.PrincipalUser, user, name, scopes, expiresAt)
+}
+
+// CreateFor creates a token like Create for a principal of the given kind, e.g. a
+// service account, the principal's roles are held in the namespace manager under its id
+func (m *Manager) CreateFor(ctx context.Context, kind security.PrincipalKind, user uuid.UUID, name string, scopes []security.Policy, expiresAt *time.Time) (*Token, string, error) {
+	now := m.now()
+	if strings.TrimSpace(name) == "" {
+		return nil, "", ErrInvalidName
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, "", ErrInvalidExpiration
+	}
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	delegated, err := m.getAuthorizer().CanDelegate(ctx, user, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if !delegated {
+		return nil, "", ErrInvalidScope
+	}
+
+	random := make([]byte, secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", errors.Wrap(err, "failed to generate token")
+	}
+	secret := m.prefix + "_" + base64.RawURLEncoding.EncodeToString(random)
+	token := &Token{
+		ID:        uuid.NewV4(),
+		User:      user,
+		Kind:      kind,
+		Name:      name,
+		Hint:      secret[:len(m.prefix)+1+hintLength],
+		Hash:      hash(secret),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := m.store.CreateToken(ctx, token); err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// List returns the user's tokens, newest first
+func (m *Manager) List(ctx context.Context, user uuid.UUID) ([]Token, error) {
+	return m.store.ListTokens(ctx, user)
+}
+
+// Revoke revokes the user's token with the given id
+func (m *Manager) Revoke(ctx context.Context, user, id uuid.UUID) error {
+	return m.store.RevokeToken(ctx, user, id)
+}
+
+// Authenticate returns the token with the given secret and records that it was used,
+// it returns ErrInvalidToken for unknown tokens and ErrTokenExpired for expired ones
+func (m *Manager) Authenticate(ctx context.Context, secret string) (*Token, error) {
+	if !strings.HasPrefix(secret, m.prefix+"_") {
+		return nil, ErrInvalidToken
+	}
+	token, err := m.store.GetToken(ctx, hash(secret))
+	if err != nil {
+		if err == ErrTokenNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	now := m.now()
+	if token.Expired(now) {
+		return nil, ErrTokenExpired
+	}
+	if err := m.store.TouchToken(ctx, token.ID, now); err != nil {
+		return nil, err
+	}
+	token.LastUsedAt = &now
+	return token, nil
+}
+
+// hash returns the hex encoded sha256 of the secret, the secrets are random
+// enough that they don't need a slow or salted hash
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}